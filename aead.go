@@ -22,6 +22,7 @@
 package crypto11
 
 import (
+	"bytes"
 	"crypto/cipher"
 	"errors"
 	"fmt"
@@ -44,6 +45,9 @@ const (
 
 var errBadGCMNonceSize = errors.New("nonce slice too small to hold IV")
 
+// errGCMNonceIgnored is returned if the token used a different IV to the nonce supplied to Seal.
+var errGCMNonceIgnored = errors.New("token ignored the supplied GCM nonce; set Config.UseGCMIVFromHSM for this token")
+
 type genericAead struct {
 	key *SecretKey
 
@@ -61,6 +65,10 @@ type genericAead struct {
 //
 // This depends on the HSM supporting the CKM_*_GCM mechanism. If it is not supported
 // then you must use cipher.NewGCM; it will be slow.
+//
+// Seal and Open behave as for the AEAD returned by cipher.NewGCM, with 16-byte tags. Whether the nonce passed to Seal
+// is used is reported by GCMNonceHonored. If it is, but the token encrypts with a different IV, Seal panics rather
+// than return a ciphertext that cannot be opened with the nonce.
 func (key *SecretKey) NewGCM() (cipher.AEAD, error) {
 	if key.Cipher.GCMMech == 0 {
 		return nil, fmt.Errorf("GCM not implemented for key type %#x", key.Cipher.GenParams[0].KeyType)
@@ -86,6 +94,13 @@ func (key *SecretKey) NewGCM() (cipher.AEAD, error) {
 	return g, nil
 }
 
+// GCMNonceHonored returns true if the nonce passed to Seal on the AEAD returned by NewGCM is used to encrypt. It is
+// false if Config.UseGCMIVFromHSM is set, in which case the token chooses the IV, and Seal overwrites the nonce slice
+// with it (if Config.GCMIVFromHSMControl.SupplyIvForHSMGCMEncrypt is set) so it can be stored with the ciphertext.
+func (key *SecretKey) GCMNonceHonored() bool {
+	return !key.context.cfg.UseGCMIVFromHSM
+}
+
 // NewCBC returns a given cipher wrapped in CBC mode.
 //
 // Despite the cipher.AEAD return type, there is no support for additional data and no authentication.
@@ -148,10 +163,20 @@ func (g genericAead) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
 			return
 		}
 
-		if g.key.context.cfg.UseGCMIVFromHSM && g.key.context.cfg.GCMIVFromHSMControl.SupplyIvForHSMGCMEncrypt {
-			if len(nonce) != len(params.IV()) {
-				return errBadGCMNonceSize
+		if params == nil {
+			return
+		}
+
+		iv := params.IV()
+		if g.key.context.cfg.UseGCMIVFromHSM {
+			if g.key.context.cfg.GCMIVFromHSMControl.SupplyIvForHSMGCMEncrypt {
+				if len(nonce) != len(iv) {
+					return errBadGCMNonceSize
+				}
+				copy(nonce, iv)
 			}
+		} else if !bytes.Equal(iv, nonce) {
+			return errGCMNonceIgnored
 		}
 
 		return
@@ -275,6 +275,14 @@ func NewAttributeSetWithIDAndLabel(id, label []byte) (a AttributeSet, err error)
 	return a, nil
 }
 
+// attributeName returns the name of a PKCS#11 attribute type, or its hex value if the name is not known.
+func attributeName(attr uint) string {
+	if name := attributeTypeString(AttributeType(attr)); name != "Unknown" {
+		return name
+	}
+	return fmt.Sprintf("%#x", attr)
+}
+
 func attributeTypeString(a AttributeType) string {
 	//noinspection GoDeprecation
 	switch a {
@@ -16,3 +16,8 @@ func TestNewAttributeNoPanicOnWrongType(t *testing.T) {
 	_, err := NewAttribute(CkaId, []string{"this is not allowed"})
 	assert.Error(t, err)
 }
+
+func TestAttributeName(t *testing.T) {
+	assert.Equal(t, "CkaSensitive", attributeName(uint(CkaSensitive)))
+	assert.Equal(t, "0x80001234", attributeName(0x80001234))
+}
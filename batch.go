@@ -0,0 +1,102 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// BatchSigner is implemented by the Signer values returned by this package.
+type BatchSigner interface {
+	Signer
+
+	// SignBatch signs each of digests in turn, as Sign does, using a single session from the pool. If some of the
+	// signatures fail, the others are returned along with a SignBatchError; the entries for the failures are nil.
+	// If WithSession is already in progress on the key, including around the call to SignBatch, the signatures are
+	// made as any other operation on the key would be, using the pinned session.
+	SignBatch(rand io.Reader, digests [][]byte, opts crypto.SignerOpts) ([][]byte, error)
+}
+
+// SignBatchError is returned by SignBatch when some of the signatures failed. It maps the index of each digest that
+// could not be signed to the error.
+type SignBatchError map[int]error
+
+// Error implements error. It reports the failure with the lowest index.
+func (e SignBatchError) Error() string {
+	indices := make([]int, 0, len(e))
+	for i := range e {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	return fmt.Sprintf("%d signatures failed, first at index %d: %v", len(e), indices[0], e[indices[0]])
+}
+
+// signBatch signs each of digests with signer, whose private key is k, with a session pinned to the key by
+// WithSession.
+//
+// The signatures are returned in the same order as digests. If any of the signatures fail, the others are still
+// attempted and the result is returned along with a SignBatchError, with a nil entry for each failure. Errors that
+// prevent any signing, such as a closed Context, are returned alone.
+func signBatch(signer ContextSigner, k *pkcs11PrivateKey, rand io.Reader, digests [][]byte,
+	opts crypto.SignerOpts) ([][]byte, error) {
+
+	if k.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	signatures := make([][]byte, len(digests))
+	failed := SignBatchError{}
+
+	signAll := func() error {
+		for i, digest := range digests {
+			signature, err := signer.SignContext(context.Background(), rand, digest, opts)
+			if err != nil {
+				failed[i] = err
+				continue
+			}
+			signatures[i] = signature
+		}
+		return nil
+	}
+
+	// If WithSession is in progress, possibly in this goroutine, calling it again could deadlock. The signatures use
+	// its pinned session anyway.
+	var err error
+	if k.sessionPinned() {
+		err = signAll()
+	} else {
+		err = k.WithSession(signAll)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(failed) > 0 {
+		return signatures, failed
+	}
+	return signatures, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignBatch(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		digests := make([][]byte, 10)
+		for i := range digests {
+			digest := sha256.Sum256(randomBytes())
+			digests[i] = digest[:]
+		}
+
+		signatures, err := key.(BatchSigner).SignBatch(nil, digests, crypto.SHA256)
+		require.NoError(t, err)
+		require.Len(t, signatures, len(digests))
+
+		var sig dsaSignature
+		for i, signature := range signatures {
+			require.NoError(t, sig.unmarshalDER(signature))
+			assert.True(t, ecdsa.Verify(key.Public().(*ecdsa.PublicKey), digests[i], sig.R, sig.S))
+		}
+	})
+}
+
+func TestSignBatchWithinWithSession(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		digest := sha256.Sum256(randomBytes())
+		err = key.(SessionPinner).WithSession(func() error {
+			signatures, err := key.(BatchSigner).SignBatch(nil, [][]byte{digest[:]}, crypto.SHA256)
+			if err != nil {
+				return err
+			}
+			var sig dsaSignature
+			require.NoError(t, sig.unmarshalDER(signatures[0]))
+			assert.True(t, ecdsa.Verify(key.Public().(*ecdsa.PublicKey), digest[:], sig.R, sig.S))
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestSignBatchConcurrent(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		digest := sha256.Sum256(randomBytes())
+		digests := [][]byte{digest[:], digest[:], digest[:]}
+
+		const goroutines = 4
+		errs := make(chan error, goroutines)
+		for i := 0; i < goroutines; i++ {
+			go func() {
+				_, err := key.(BatchSigner).SignBatch(nil, digests, crypto.SHA256)
+				errs <- err
+			}()
+		}
+		for i := 0; i < goroutines; i++ {
+			assert.NoError(t, <-errs)
+		}
+	})
+}
+
+func TestSignBatchError(t *testing.T) {
+	err := SignBatchError{3: errClosed, 1: errClosed}
+	assert.Contains(t, err.Error(), "2 signatures failed, first at index 1")
+}
+
+func BenchmarkSignBatch(b *testing.B) {
+	ctx, err := ConfigureFromFile("config")
+	require.NoError(b, err)
+	defer func() { require.NoError(b, ctx.Close()) }()
+
+	key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+	require.NoError(b, err)
+	defer func() { _ = key.Delete() }()
+
+	digest := sha256.Sum256([]byte("benchmark"))
+	digests := make([][]byte, 100)
+	for i := range digests {
+		digests[i] = digest[:]
+	}
+
+	b.Run("Sign", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, digest := range digests {
+				_, err := key.Sign(nil, digest, crypto.SHA256)
+				require.NoError(b, err)
+			}
+		}
+	})
+
+	b.Run("SignBatch", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			_, err := key.(BatchSigner).SignBatch(nil, digests, crypto.SHA256)
+			require.NoError(b, err)
+		}
+	})
+}
@@ -23,6 +23,8 @@ package crypto11
 
 import (
 	"crypto/cipher"
+	"errors"
+	"fmt"
 	"runtime"
 
 	"github.com/miekg/pkcs11"
@@ -200,3 +202,156 @@ func (bmc *blockModeCloser) Close() {
 		panic("nontrivial result from *Final operation")
 	}
 }
+
+// PaddedBlockModeCloser represents a block cipher running in CBC mode with PKCS#7 padding applied by the token
+// (e.g. CKM_AES_CBC_PAD). Because padding changes the length of the data, it cannot implement cipher.BlockMode.
+//
+// Data is passed through Update in pieces of any length, and the operation is completed by Final. Close releases the
+// resources of an operation that is abandoned before Final is called; it does nothing after Final.
+type PaddedBlockModeCloser interface {
+	// Update processes src, returning as much output as is available. Some output may be held back until a later call
+	// to Update or Final.
+	Update(src []byte) ([]byte, error)
+
+	// Final completes the operation and returns any remaining output. When encrypting, the padding is added; when
+	// decrypting, the padding is validated and removed.
+	Final() ([]byte, error)
+
+	// Close releases resources associated with the block mode.
+	Close()
+}
+
+// errMalformedPadding is returned when decrypted data does not end with valid PKCS#7 padding.
+var errMalformedPadding = errors.New("malformed CBC padding")
+
+// NewCBCPadEncrypterCloser returns a PaddedBlockModeCloser which encrypts in cipher block chaining mode with PKCS#7
+// padding, using the given key. The length of iv must be the same as the key's block size.
+//
+// Use of NewCBCPadEncrypterCloser represents a commitment to call either the Final() or Close() method of the returned
+// PaddedBlockModeCloser.
+func (key *SecretKey) NewCBCPadEncrypterCloser(iv []byte) (PaddedBlockModeCloser, error) {
+	return key.newPaddedBlockModeCloser(modeEncrypt, iv)
+}
+
+// NewCBCPadDecrypterCloser returns a PaddedBlockModeCloser which decrypts in cipher block chaining mode with PKCS#7
+// padding, using the given key. The length of iv must be the same as the key's block size and must match the iv used
+// to encrypt the data. Final returns an error if the padding is malformed.
+//
+// Use of NewCBCPadDecrypterCloser represents a commitment to call either the Final() or Close() method of the returned
+// PaddedBlockModeCloser.
+func (key *SecretKey) NewCBCPadDecrypterCloser(iv []byte) (PaddedBlockModeCloser, error) {
+	return key.newPaddedBlockModeCloser(modeDecrypt, iv)
+}
+
+// paddedBlockModeCloser is a concrete implementation of PaddedBlockModeCloser.
+type paddedBlockModeCloser struct {
+	// PKCS#11 session to use, or nil once the operation has finished
+	session *pkcs11Session
+
+	// modeDecrypt or modeEncrypt
+	mode int
+
+	// Cleanup function
+	cleanup func()
+}
+
+func (key *SecretKey) newPaddedBlockModeCloser(mode int, iv []byte) (*paddedBlockModeCloser, error) {
+	if key.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	if key.Cipher.CBCPKCSMech == 0 {
+		return nil, fmt.Errorf("CBC with padding not implemented for key type %#x", key.Cipher.GenParams[0].KeyType)
+	}
+	if len(iv) != key.Cipher.BlockSize {
+		return nil, fmt.Errorf("iv must be %d bytes", key.Cipher.BlockSize)
+	}
+
+	session, err := key.context.getSession()
+	if err != nil {
+		return nil, err
+	}
+
+	pbmc := &paddedBlockModeCloser{
+		session: session,
+		mode:    mode,
+		cleanup: func() {
+			key.context.pool.Put(session)
+		},
+	}
+	mechDescription := []*pkcs11.Mechanism{pkcs11.NewMechanism(key.Cipher.CBCPKCSMech, iv)}
+
+	switch mode {
+	case modeDecrypt:
+		err = session.ctx.DecryptInit(session.handle, mechDescription, key.handle)
+	case modeEncrypt:
+		err = session.ctx.EncryptInit(session.handle, mechDescription, key.handle)
+	default:
+		panic("unexpected mode")
+	}
+	if err != nil {
+		pbmc.cleanup()
+		return nil, err
+	}
+
+	return pbmc, nil
+}
+
+func (pbmc *paddedBlockModeCloser) Update(src []byte) (result []byte, err error) {
+	if pbmc.session == nil {
+		return nil, errors.New("operation has finished")
+	}
+
+	switch pbmc.mode {
+	case modeDecrypt:
+		result, err = pbmc.session.ctx.DecryptUpdate(pbmc.session.handle, src)
+	case modeEncrypt:
+		result, err = pbmc.session.ctx.EncryptUpdate(pbmc.session.handle, src)
+	}
+	if err != nil {
+		// A failed update terminates the operation
+		pbmc.release()
+	}
+	return result, err
+}
+
+func (pbmc *paddedBlockModeCloser) Final() (result []byte, err error) {
+	if pbmc.session == nil {
+		return nil, errors.New("operation has finished")
+	}
+
+	switch pbmc.mode {
+	case modeDecrypt:
+		result, err = pbmc.session.ctx.DecryptFinal(pbmc.session.handle)
+		if isPKCS11Error(err, pkcs11.CKR_ENCRYPTED_DATA_INVALID) ||
+			isPKCS11Error(err, pkcs11.CKR_ENCRYPTED_DATA_LEN_RANGE) {
+			err = errMalformedPadding
+		}
+	case modeEncrypt:
+		result, err = pbmc.session.ctx.EncryptFinal(pbmc.session.handle)
+	}
+	pbmc.release()
+	return result, err
+}
+
+func (pbmc *paddedBlockModeCloser) Close() {
+	if pbmc.session == nil {
+		return
+	}
+
+	// There is no way to cancel an operation in PKCS#11 other than to complete it, so finish it and discard the
+	// result.
+	switch pbmc.mode {
+	case modeDecrypt:
+		_, _ = pbmc.session.ctx.DecryptFinal(pbmc.session.handle)
+	case modeEncrypt:
+		_, _ = pbmc.session.ctx.EncryptFinal(pbmc.session.handle)
+	}
+	pbmc.release()
+}
+
+// release returns the session to the pool.
+func (pbmc *paddedBlockModeCloser) release() {
+	pbmc.session = nil
+	pbmc.cleanup()
+}
@@ -0,0 +1,215 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"sync"
+)
+
+// brainpoolCurve implements elliptic.Curve for the Brainpool curves of RFC 5639. Unlike the NIST curves, these do
+// not have a = -3, so the generic arithmetic of elliptic.CurveParams cannot be used. The arithmetic here is simple
+// and not constant-time: it is intended for handling public keys, with private key operations left to the token.
+type brainpoolCurve struct {
+	*elliptic.CurveParams
+
+	// a is the curve coefficient in y² = x³ + ax + b
+	a *big.Int
+}
+
+var (
+	brainpoolOnce                                     sync.Once
+	brainpoolP256r1, brainpoolP384r1, brainpoolP512r1 *brainpoolCurve
+)
+
+func initBrainpool() {
+	brainpoolP256r1 = newBrainpoolCurve("brainpoolP256r1", 256,
+		"A9FB57DBA1EEA9BC3E660A909D838D726E3BF623D52620282013481D1F6E5377",
+		"7D5A0975FC2C3057EEF67530417AFFE7FB8055C126DC5C6CE94A4B44F330B5D9",
+		"26DC5C6CE94A4B44F330B5D9BBD77CBF958416295CF7E1CE6BCCDC18FF8C07B6",
+		"8BD2AEB9CB7E57CB2C4B482FFC81B7AFB9DE27E1E3BD23C23A4453BD9ACE3262",
+		"547EF835C3DAC4FD97F8461A14611DC9C27745132DED8E545C1D54C72F046997",
+		"A9FB57DBA1EEA9BC3E660A909D838D718C397AA3B561A6F7901E0E82974856A7")
+	brainpoolP384r1 = newBrainpoolCurve("brainpoolP384r1", 384,
+		"8CB91E82A3386D280F5D6F7E50E641DF152F7109ED5456B412B1DA197FB71123ACD3A729901D1A71874700133107EC53",
+		"7BC382C63D8C150C3C72080ACE05AFA0C2BEA28E4FB22787139165EFBA91F90F8AA5814A503AD4EB04A8C7DD22CE2826",
+		"04A8C7DD22CE28268B39B55416F0447C2FB77DE107DCD2A62E880EA53EEB62D57CB4390295DBC9943AB78696FA504C11",
+		"1D1C64F068CF45FFA2A63A81B7C13F6B8847A3E77EF14FE3DB7FCAFE0CBD10E8E826E03436D646AAEF87B2E247D4AF1E",
+		"8ABE1D7520F9C2A45CB1EB8E95CFD55262B70B29FEEC5864E19C054FF99129280E4646217791811142820341263C5315",
+		"8CB91E82A3386D280F5D6F7E50E641DF152F7109ED5456B31F166E6CAC0425A7CF3AB6AF6B7FC3103B883202E9046565")
+	brainpoolP512r1 = newBrainpoolCurve("brainpoolP512r1", 512,
+		"AADD9DB8DBE9C48B3FD4E6AE33C9FC07CB308DB3B3C9D20ED6639CCA703308717D4D9B009BC66842AECDA12AE6A380E62881FF2F2D82C68528AA6056583A48F3",
+		"7830A3318B603B89E2327145AC234CC594CBDD8D3DF91610A83441CAEA9863BC2DED5D5AA8253AA10A2EF1C98B9AC8B57F1117A72BF2C7B9E7C1AC4D77FC94CA",
+		"3DF91610A83441CAEA9863BC2DED5D5AA8253AA10A2EF1C98B9AC8B57F1117A72BF2C7B9E7C1AC4D77FC94CADC083E67984050B75EBAE5DD2809BD638016F723",
+		"81AEE4BDD82ED9645A21322E9C4C6A9385ED9F70B5D916C1B43B62EEF4D0098EFF3B1F78E2D0D48D50D1687B93B97D5F7C6D5047406A5E688B352209BCB9F822",
+		"7DDE385D566332ECC0EABFA9CF7822FDF209F70024A57B1AA000C55B881F8111B2DCDE494A5F485E5BCA4BD88A2763AED1CA2B2FA8F0540678CD1E0F3AD80892",
+		"AADD9DB8DBE9C48B3FD4E6AE33C9FC07CB308DB3B3C9D20ED6639CCA70330870553E5C414CA92619418661197FAC10471DB1D381085DDADDB58796829CA90069")
+}
+
+func newBrainpoolCurve(name string, bitSize int, p, a, b, gx, gy, n string) *brainpoolCurve {
+	return &brainpoolCurve{
+		CurveParams: &elliptic.CurveParams{
+			Name:    name,
+			BitSize: bitSize,
+			P:       mustParseHex(p),
+			B:       mustParseHex(b),
+			Gx:      mustParseHex(gx),
+			Gy:      mustParseHex(gy),
+			N:       mustParseHex(n),
+		},
+		a: mustParseHex(a),
+	}
+}
+
+func mustParseHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex constant " + s)
+	}
+	return n
+}
+
+// BrainpoolP256r1 returns a Curve which implements brainpoolP256r1 (RFC 5639, section 3.4).
+func BrainpoolP256r1() elliptic.Curve {
+	brainpoolOnce.Do(initBrainpool)
+	return brainpoolP256r1
+}
+
+// BrainpoolP384r1 returns a Curve which implements brainpoolP384r1 (RFC 5639, section 3.6).
+func BrainpoolP384r1() elliptic.Curve {
+	brainpoolOnce.Do(initBrainpool)
+	return brainpoolP384r1
+}
+
+// BrainpoolP512r1 returns a Curve which implements brainpoolP512r1 (RFC 5639, section 3.7).
+func BrainpoolP512r1() elliptic.Curve {
+	brainpoolOnce.Do(initBrainpool)
+	return brainpoolP512r1
+}
+
+func (curve *brainpoolCurve) Params() *elliptic.CurveParams {
+	return curve.CurveParams
+}
+
+// rhs returns x³ + ax + b mod p.
+func (curve *brainpoolCurve) rhs(x *big.Int) *big.Int {
+	result := new(big.Int).Mul(x, x)
+	result.Mul(result, x)
+
+	ax := new(big.Int).Mul(curve.a, x)
+	result.Add(result, ax)
+	result.Add(result, curve.B)
+	return result.Mod(result, curve.P)
+}
+
+func (curve *brainpoolCurve) IsOnCurve(x, y *big.Int) bool {
+	if x.Sign() < 0 || x.Cmp(curve.P) >= 0 || y.Sign() < 0 || y.Cmp(curve.P) >= 0 {
+		return false
+	}
+
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, curve.P)
+	return y2.Cmp(curve.rhs(x)) == 0
+}
+
+// The point at infinity is represented as (0, 0), as in crypto/elliptic.
+func isInfinity(x, y *big.Int) bool {
+	return x.Sign() == 0 && y.Sign() == 0
+}
+
+func (curve *brainpoolCurve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	if isInfinity(x1, y1) {
+		return new(big.Int).Set(x2), new(big.Int).Set(y2)
+	}
+	if isInfinity(x2, y2) {
+		return new(big.Int).Set(x1), new(big.Int).Set(y1)
+	}
+	if x1.Cmp(x2) == 0 {
+		if y1.Cmp(y2) == 0 {
+			return curve.Double(x1, y1)
+		}
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = (y2 - y1) / (x2 - x1)
+	numerator := new(big.Int).Sub(y2, y1)
+	denominator := new(big.Int).Sub(x2, x1)
+	denominator.Mod(denominator, curve.P)
+	denominator.ModInverse(denominator, curve.P)
+	lambda := numerator.Mul(numerator, denominator)
+	lambda.Mod(lambda, curve.P)
+
+	return curve.addWithLambda(lambda, x1, y1, x2)
+}
+
+func (curve *brainpoolCurve) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	if isInfinity(x1, y1) || y1.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+
+	// λ = (3x² + a) / 2y
+	numerator := new(big.Int).Mul(x1, x1)
+	numerator.Mul(numerator, big.NewInt(3))
+	numerator.Add(numerator, curve.a)
+	denominator := new(big.Int).Lsh(y1, 1)
+	denominator.Mod(denominator, curve.P)
+	denominator.ModInverse(denominator, curve.P)
+	lambda := numerator.Mul(numerator, denominator)
+	lambda.Mod(lambda, curve.P)
+
+	return curve.addWithLambda(lambda, x1, y1, x1)
+}
+
+// addWithLambda completes a point addition or doubling, given the slope λ of the line through the points.
+func (curve *brainpoolCurve) addWithLambda(lambda, x1, y1, x2 *big.Int) (*big.Int, *big.Int) {
+	// x3 = λ² - x1 - x2
+	x3 := new(big.Int).Mul(lambda, lambda)
+	x3.Sub(x3, x1)
+	x3.Sub(x3, x2)
+	x3.Mod(x3, curve.P)
+
+	// y3 = λ(x1 - x3) - y1
+	y3 := new(big.Int).Sub(x1, x3)
+	y3.Mul(y3, lambda)
+	y3.Sub(y3, y1)
+	y3.Mod(y3, curve.P)
+
+	return x3, y3
+}
+
+func (curve *brainpoolCurve) ScalarMult(x1, y1 *big.Int, k []byte) (*big.Int, *big.Int) {
+	x, y := new(big.Int), new(big.Int)
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			x, y = curve.Double(x, y)
+			if b>>uint(bit)&1 == 1 {
+				x, y = curve.Add(x, y, x1, y1)
+			}
+		}
+	}
+	return x, y
+}
+
+func (curve *brainpoolCurve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return curve.ScalarMult(curve.Gx, curve.Gy, k)
+}
@@ -0,0 +1,111 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var brainpoolCurves = []elliptic.Curve{
+	BrainpoolP256r1(),
+	BrainpoolP384r1(),
+	BrainpoolP512r1(),
+}
+
+func TestBrainpoolCurves(t *testing.T) {
+	for _, curve := range brainpoolCurves {
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			params := curve.Params()
+			require.True(t, curve.IsOnCurve(params.Gx, params.Gy))
+
+			x, y := curve.ScalarBaseMult(params.N.Bytes())
+			assert.True(t, isInfinity(x, y), "n·G should be the point at infinity")
+
+			// 2G + 3G == 5G
+			x1, y1 := curve.ScalarBaseMult([]byte{2})
+			x2, y2 := curve.ScalarBaseMult([]byte{3})
+			x3, y3 := curve.ScalarBaseMult([]byte{5})
+			x, y = curve.Add(x1, y1, x2, y2)
+			assert.Equal(t, 0, x.Cmp(x3))
+			assert.Equal(t, 0, y.Cmp(y3))
+			assert.True(t, curve.IsOnCurve(x, y))
+
+			assert.False(t, curve.IsOnCurve(params.Gx, new(big.Int).Add(params.Gy, big.NewInt(1))))
+
+			params2, err := marshalEcParams(curve)
+			require.NoError(t, err)
+			curve2, err := unmarshalEcParams(params2)
+			require.NoError(t, err)
+			assert.Equal(t, curve, curve2)
+
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			require.NoError(t, err)
+			digest := crypto.SHA256.New().Sum(nil)
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+			require.NoError(t, err)
+			assert.True(t, ecdsa.Verify(&key.PublicKey, digest, r, s))
+
+			byteLen := (params.BitSize + 7) / 8
+			compressed := make([]byte, 1+byteLen)
+			compressed[0] = 2 | byte(key.Y.Bit(0))
+			xBytes := key.X.Bytes()
+			copy(compressed[1+byteLen-len(xBytes):], xBytes)
+
+			pub, err := ParseECPoint(curve, compressed)
+			require.NoError(t, err)
+			assert.Equal(t, 0, key.Y.Cmp(pub.Y))
+		})
+	}
+}
+
+func TestHardBrainpool(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		for _, curve := range brainpoolCurves {
+			id := randomBytes()
+
+			key, err := ctx.GenerateECDSAKeyPairWithLabel(id, nil, curve)
+			if isPKCS11Error(err, pkcs11.CKR_CURVE_NOT_SUPPORTED) ||
+				isPKCS11Error(err, pkcs11.CKR_DOMAIN_PARAMS_INVALID) {
+				assert.Contains(t, err.Error(), curve.Params().Name)
+				t.Logf("Skipping unsupported curve %s", curve.Params().Name)
+				continue
+			}
+			require.NoError(t, err)
+			defer func(k Signer) { _ = k.Delete() }(key)
+
+			testEcdsaSigning(t, key, crypto.SHA256, curve.Params().Name, "SHA-256")
+
+			key2, err := ctx.FindKeyPair(id, nil)
+			require.NoError(t, err)
+			assert.Equal(t, curve, key2.Public().(*ecdsa.PublicKey).Curve)
+		}
+	})
+}
@@ -23,6 +23,11 @@ package crypto11
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
@@ -32,6 +37,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrAmbiguousCertificate is returned when a certificate is looked up, or deleted, using attributes that match more
+// than one certificate.
+var ErrAmbiguousCertificate = errors.New("more than one certificate matches")
+
 func findCertificate(session *pkcs11Session, id []byte, label []byte, serial *big.Int) (cert *x509.Certificate, err error) {
 	if id == nil && label == nil && serial == nil {
 		return nil, errors.New("id, label and serial cannot all be nil")
@@ -62,6 +71,9 @@ func findCertificate(session *pkcs11Session, id []byte, label []byte, serial *bi
 	if len(handles) == 0 {
 		return nil, nil
 	}
+	if len(handles) > 1 {
+		return nil, ErrAmbiguousCertificate
+	}
 
 	return getX509Certificate(session, handles[0])
 }
@@ -174,7 +186,8 @@ func findCertificateChain(session *pkcs11Session, cert *x509.Certificate) (certs
 }
 
 // FindCertificate retrieves a previously imported certificate. Any combination of id, label
-// and serial can be provided. An error is return if all are nil.
+// and serial can be provided. An error is return if all are nil, and ErrAmbiguousCertificate is
+// returned if more than one certificate matches.
 func (c *Context) FindCertificate(id []byte, label []byte, serial *big.Int) (*x509.Certificate, error) {
 
 	if c.closed.Get() {
@@ -191,6 +204,7 @@ func (c *Context) FindCertificate(id []byte, label []byte, serial *big.Int) (*x5
 }
 
 // FindCertificateWithAttributes retrieves a previously imported certificate with selected attributes.
+// ErrAmbiguousCertificate is returned if more than one certificate matches.
 func (c *Context) FindCertificateWithAttributes(template AttributeSet) (*x509.Certificate, error) {
 	if c.closed.Get() {
 		return nil, errClosed
@@ -206,6 +220,9 @@ func (c *Context) FindCertificateWithAttributes(template AttributeSet) (*x509.Ce
 		if len(handles) == 0 {
 			return nil
 		}
+		if len(handles) > 1 {
+			return ErrAmbiguousCertificate
+		}
 
 		if cert, err = getX509Certificate(session, handles[0]); err != nil {
 			return err
@@ -217,6 +234,24 @@ func (c *Context) FindCertificateWithAttributes(template AttributeSet) (*x509.Ce
 	return cert, err
 }
 
+// FindCertificateBySubject retrieves a previously imported certificate by its DER-encoded subject, as found in
+// x509.Certificate.RawSubject. ErrAmbiguousCertificate is returned if more than one certificate matches.
+func (c *Context) FindCertificateBySubject(subject []byte) (*x509.Certificate, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if len(subject) == 0 {
+		return nil, errors.New("subject cannot be empty")
+	}
+
+	template := NewAttributeSet()
+	if err := template.Set(CkaSubject, subject); err != nil {
+		return nil, err
+	}
+	return c.FindCertificateWithAttributes(template)
+}
+
 // FindCertificateChain retrieves a previously imported certificate chain. Any combination of id, label
 // and serial can be provided. An error is return if all are nil.
 func (c *Context) FindCertificateChain(id []byte, label []byte, serial *big.Int) (certs []*x509.Certificate, err error) {
@@ -306,6 +341,88 @@ func (c *Context) FindAllPairedCertificates() (certificates []tls.Certificate, e
 	return
 }
 
+// FindKeyPairForCertificate retrieves the key pair whose public key is the subject public key of cert, or nil if none
+// can be found. Key pairs are looked up by CKA_ID, using the certificate's subject key identifier and the CKA_ID of
+// the certificate object on the token, if there is one. If several key pairs share an id, the one whose public key
+// matches cert is returned; an error is returned if key pairs are found but none of them match.
+func (c *Context) FindKeyPairForCertificate(cert *x509.Certificate) (Signer, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if cert == nil {
+		return nil, errors.New("certificate must not be nil")
+	}
+
+	var ids [][]byte
+	if len(cert.SubjectKeyId) > 0 {
+		ids = append(ids, cert.SubjectKeyId)
+	}
+
+	err := c.withSession(func(session *pkcs11Session) error {
+		template := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+			pkcs11.NewAttribute(pkcs11.CKA_VALUE, cert.Raw),
+		}
+		handles, err := findCertificatesWithAttributes(session, template)
+		if err != nil {
+			return err
+		}
+
+		for _, handle := range handles {
+			attributes, err := session.ctx.GetAttributeValue(session.handle, handle,
+				[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, nil)})
+			if err != nil {
+				return err
+			}
+			if id := attributes[0].Value; len(id) > 0 {
+				ids = append(ids, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to find certificate on token")
+	}
+
+	found := false
+	for _, id := range ids {
+		keys, err := c.FindKeyPairs(id, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			found = true
+			if publicKeysEqual(key.Public(), cert.PublicKey) {
+				return key, nil
+			}
+		}
+	}
+
+	if found {
+		return nil, errors.New("no key pair with the certificate's id has a matching public key")
+	}
+	return nil, nil
+}
+
+// publicKeysEqual returns true if a and b are the same RSA, ECDSA or DSA public key.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		b, ok := b.(*rsa.PublicKey)
+		return ok && a.E == b.E && a.N.Cmp(b.N) == 0
+	case *ecdsa.PublicKey:
+		b, ok := b.(*ecdsa.PublicKey)
+		return ok && a.Curve.Params().Name == b.Curve.Params().Name && a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+	case *dsa.PublicKey:
+		b, ok := b.(*dsa.PublicKey)
+		return ok && a.Y.Cmp(b.Y) == 0 && a.P.Cmp(b.P) == 0 && a.Q.Cmp(b.Q) == 0 && a.G.Cmp(b.G) == 0
+	default:
+		return false
+	}
+}
+
 // ImportCertificate imports a certificate onto the token. The id parameter is used to
 // set CKA_ID and must be non-nil.
 func (c *Context) ImportCertificate(id []byte, certificate *x509.Certificate) error {
@@ -373,7 +490,7 @@ func (c *Context) ImportCertificateWithAttributes(template AttributeSet, certifi
 		pkcs11.NewAttribute(pkcs11.CKA_VALUE, certificate.Raw),
 	})
 
-	err = c.withSession(func(session *pkcs11Session) error {
+	err = c.withRWSession(func(session *pkcs11Session) error {
 		_, err = session.ctx.CreateObject(session.handle, template.ToSlice())
 		return err
 	})
@@ -415,13 +532,14 @@ func (c *Context) DeleteCertificate(id []byte, label []byte, serial *big.Int) er
 }
 
 // DeleteCertificateWithAttributes destroys a previously imported certificate by selected attributes.
-// It will return nil if succeeds or if the certificate does not exist.
+// It will return nil if succeeds or if the certificate does not exist. ErrAmbiguousCertificate is
+// returned, and nothing is deleted, if more than one certificate matches.
 func (c *Context) DeleteCertificateWithAttributes(template AttributeSet) error {
 	if c.closed.Get() {
 		return errClosed
 	}
 
-	err := c.withSession(func(session *pkcs11Session) (err error) {
+	err := c.withRWSession(func(session *pkcs11Session) (err error) {
 		handles, err := findCertificatesWithAttributes(session, template.ToSlice())
 		if err != nil {
 			return err
@@ -430,9 +548,78 @@ func (c *Context) DeleteCertificateWithAttributes(template AttributeSet) error {
 		if len(handles) == 0 {
 			return nil
 		}
+		if len(handles) > 1 {
+			return ErrAmbiguousCertificate
+		}
 
 		return session.ctx.DestroyObject(session.handle, handles[0])
 	})
 
 	return err
 }
+
+// CreateCertificate issues a certificate signed by the key pair with the given CKA_ID, which must be the key of
+// parent. It behaves like x509.CreateCertificate, with the token key as the signer: the certificate is built from
+// template, issued for pub and returned in DER form. To create a self-signed certificate, pass template as parent
+// and the key pair's public key as pub.
+//
+// If template.SignatureAlgorithm is not set, x509.CreateCertificate chooses one appropriate to the signing key.
+func (c *Context) CreateCertificate(id []byte, template, parent *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if err := notNilBytes(id, "id"); err != nil {
+		return nil, err
+	}
+
+	signer, err := c.FindKeyPair(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, errors.Errorf("no key pair found with id %x", id)
+	}
+
+	// x509.CreateCertificate checks the signature against parent.PublicKey only in later Go versions, so check
+	// here that the issuing key really belongs to parent.
+	if parent.PublicKey != nil {
+		signerDER, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			return nil, errors.WithMessage(err, "unsupported signing key")
+		}
+		parentDER, err := x509.MarshalPKIXPublicKey(parent.PublicKey)
+		if err != nil {
+			return nil, errors.WithMessage(err, "unsupported parent public key")
+		}
+		if !bytes.Equal(signerDER, parentDER) {
+			return nil, errors.New("signing key does not match the parent certificate's public key")
+		}
+	}
+
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+}
+
+// CreateCertificateRequest creates a certificate signing request signed by key, for enrolling a key pair held on the
+// token. It behaves like x509.CreateCertificateRequest, with key as the signer, and returns the request in DER form.
+// The key's public half must be of a type x509 supports, such as an RSA or ECDSA key.
+//
+// If template.SignatureAlgorithm is not set, x509.CreateCertificateRequest chooses one appropriate to the key.
+func (c *Context) CreateCertificateRequest(key Signer, template *x509.CertificateRequest) ([]byte, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if key == nil {
+		return nil, errors.New("key cannot be nil")
+	}
+	if template == nil {
+		return nil, errors.New("template cannot be nil")
+	}
+
+	if _, err := x509.MarshalPKIXPublicKey(key.Public()); err != nil {
+		return nil, errors.WithMessage(err, "unsupported key for certificate request")
+	}
+
+	return x509.CreateCertificateRequest(rand.Reader, template, key)
+}
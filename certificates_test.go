@@ -22,11 +22,13 @@
 package crypto11
 
 import (
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/hex"
 	"math/big"
 	"testing"
 	"time"
@@ -323,3 +325,183 @@ func generateRandomCert(t *testing.T, parent *x509.Certificate, commonName strin
 
 	return cert
 }
+
+func TestCreateCertificate(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		caID := randomBytes()
+		caKey, err := ctx.GenerateECDSAKeyPair(caID, elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = caKey.Delete() }()
+
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "Test CA"},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+
+		caDER, err := ctx.CreateCertificate(caID, caTemplate, caTemplate, caKey.Public())
+		require.NoError(t, err)
+		caCert, err := x509.ParseCertificate(caDER)
+		require.NoError(t, err)
+
+		leafKey, err := rsa.GenerateKey(rand.Reader, rsaSize)
+		require.NoError(t, err)
+
+		leafTemplate := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "Test leaf"},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+
+		leafDER, err := ctx.CreateCertificate(caID, leafTemplate, caCert, &leafKey.PublicKey)
+		require.NoError(t, err)
+		leafCert, err := x509.ParseCertificate(leafDER)
+		require.NoError(t, err)
+
+		require.NoError(t, leafCert.CheckSignatureFrom(caCert))
+
+		// The issuing key must match the parent certificate
+		_, err = ctx.CreateCertificate(caID, leafTemplate, leafCert, &leafKey.PublicKey)
+		require.Error(t, err)
+	})
+}
+
+func TestCreateCertificateRequest(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		ecdsaKey, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = ecdsaKey.Delete() }()
+
+		rsaKey, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = rsaKey.Delete() }()
+
+		template := &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "Test request"},
+			DNSNames: []string{"test.example.com"},
+		}
+
+		for _, key := range []Signer{ecdsaKey, rsaKey} {
+			der, err := ctx.CreateCertificateRequest(key, template)
+			require.NoError(t, err)
+
+			csr, err := x509.ParseCertificateRequest(der)
+			require.NoError(t, err)
+			require.NoError(t, csr.CheckSignature())
+			require.True(t, publicKeysEqual(key.Public(), csr.PublicKey))
+			require.Equal(t, "Test request", csr.Subject.CommonName)
+			require.Equal(t, template.DNSNames, csr.DNSNames)
+		}
+
+		_, err = ctx.CreateCertificateRequest(nil, template)
+		require.Error(t, err)
+		_, err = ctx.CreateCertificateRequest(ecdsaKey, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestAmbiguousCertificate(t *testing.T) {
+	skipTest(t, skipTestCert)
+
+	withContext(t, func(ctx *Context) {
+		label := randomBytes()
+		cert1 := generateRandomCert(t, nil, "Ambiguous", nil, nil)
+		cert2 := generateRandomCert(t, nil, "Ambiguous", nil, nil)
+
+		id1 := randomBytes()
+		require.NoError(t, ctx.ImportCertificateWithLabel(id1, label, cert1))
+		defer func() { _ = ctx.DeleteCertificate(id1, nil, nil) }()
+
+		id2 := randomBytes()
+		require.NoError(t, ctx.ImportCertificateWithLabel(id2, label, cert2))
+		defer func() { _ = ctx.DeleteCertificate(id2, nil, nil) }()
+
+		_, err := ctx.FindCertificate(nil, label, nil)
+		require.Equal(t, ErrAmbiguousCertificate, err)
+
+		_, err = ctx.FindCertificateBySubject(cert1.RawSubject)
+		require.Equal(t, ErrAmbiguousCertificate, err)
+
+		require.Equal(t, ErrAmbiguousCertificate, ctx.DeleteCertificate(nil, label, nil))
+
+		found, err := ctx.FindCertificate(id1, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, cert1.Raw, found.Raw)
+	})
+}
+
+func TestFindCertificateBySubject(t *testing.T) {
+	skipTest(t, skipTestCert)
+
+	withContext(t, func(ctx *Context) {
+		cert := generateRandomCert(t, nil, hex.EncodeToString(randomBytes()), nil, nil)
+
+		id := randomBytes()
+		require.NoError(t, ctx.ImportCertificate(id, cert))
+		defer func() { _ = ctx.DeleteCertificate(id, nil, nil) }()
+
+		found, err := ctx.FindCertificateBySubject(cert.RawSubject)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		require.Equal(t, cert.Raw, found.Raw)
+
+		_, err = ctx.FindCertificateBySubject(nil)
+		require.Error(t, err)
+	})
+}
+
+func TestFindKeyPairForCertificate(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		// Two key pairs share an id, so the public key must decide between them
+		id := randomBytes()
+		key1, err := ctx.GenerateECDSAKeyPair(id, elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key1.Delete() }()
+		key2, err := ctx.GenerateECDSAKeyPair(id, elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key2.Delete() }()
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "Test"},
+			SubjectKeyId: id,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key2.Public(), key2)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+
+		found, err := ctx.FindKeyPairForCertificate(cert)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.True(t, publicKeysEqual(key2.Public(), found.Public()))
+
+		// A certificate for some other key with the same id
+		other := generateRandomCert(t, nil, "Other", nil, id)
+		_, err = ctx.FindKeyPairForCertificate(other)
+		require.Error(t, err)
+
+		unknown := generateRandomCert(t, nil, "Unknown", nil, randomBytes())
+		found, err = ctx.FindKeyPairForCertificate(unknown)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+	})
+}
+
+func TestPublicKeysEqual(t *testing.T) {
+	key1, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	key2, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	assert.True(t, publicKeysEqual(&key1.PublicKey, &rsa.PublicKey{N: key1.N, E: key1.E}))
+	assert.False(t, publicKeysEqual(&key1.PublicKey, &key2.PublicKey))
+	assert.False(t, publicKeysEqual(&key1.PublicKey, nil))
+}
@@ -22,9 +22,14 @@
 package crypto11
 
 import (
+	"crypto"
 	"crypto/dsa"
 	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -108,3 +113,52 @@ func TestErrorAfterClosed(t *testing.T) {
 	_, err = ctx.GetPubAttributes(nil, []AttributeType{CkaLabel})
 	assert.Equal(t, errClosed, err)
 }
+
+func TestCloseWhileSigning(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		id := randomBytes()
+		key, err := ctx.GenerateRSAKeyPair(id, rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		signingCtx, err := ConfigureFromFile("config")
+		require.NoError(t, err)
+
+		signer, err := signingCtx.FindKeyPair(id, nil)
+		require.NoError(t, err)
+		require.NotNil(t, signer)
+
+		digest := sha256.Sum256([]byte("close while signing"))
+
+		const goroutines = 10
+		errs := make(chan error, goroutines)
+		var wg sync.WaitGroup
+
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					sig, err := signer.Sign(nil, digest[:], crypto.SHA256)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if err = rsa.VerifyPKCS1v15(signer.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, signingCtx.Close())
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			assert.Equal(t, errClosed, err)
+		}
+	})
+}
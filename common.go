@@ -23,6 +23,7 @@ package crypto11
 
 import (
 	"C"
+	"context"
 	"encoding/asn1"
 	"math/big"
 	"unsafe"
@@ -100,22 +101,26 @@ func (sig *dsaSignature) marshalDER() ([]byte, error) {
 	return asn1.Marshal(*sig)
 }
 
+// Return r and s as big-endian integers of size bytes each, concatenated
+func (sig *dsaSignature) marshalRaw(size int) ([]byte, error) {
+	r, s := sig.R.Bytes(), sig.S.Bytes()
+	if len(r) > size || len(s) > size {
+		return nil, errors.New("DSA signature is too long for the key")
+	}
+	raw := make([]byte, 2*size)
+	copy(raw[size-len(r):size], r)
+	copy(raw[2*size-len(s):], s)
+	return raw, nil
+}
+
 // Compute *DSA signature and marshal the result in DER form
-func (c *Context) dsaGeneric(key pkcs11.ObjectHandle, mechanism uint, digest []byte) ([]byte, error) {
-	var err error
-	var sigBytes []byte
-	var sig dsaSignature
-	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}
-	err = c.withSession(func(session *pkcs11Session) error {
-		if err = c.ctx.SignInit(session.handle, mech, key); err != nil {
-			return err
-		}
-		sigBytes, err = c.ctx.Sign(session.handle, digest)
-		return err
-	})
+func (k *pkcs11PrivateKey) dsaGeneric(ctx context.Context, mechanism uint, digest []byte) ([]byte, error) {
+	sigBytes, err := k.dsaGenericRaw(ctx, mechanism, digest)
 	if err != nil {
 		return nil, err
 	}
+
+	var sig dsaSignature
 	err = sig.unmarshalBytes(sigBytes)
 	if err != nil {
 		return nil, err
@@ -123,3 +128,32 @@ func (c *Context) dsaGeneric(key pkcs11.ObjectHandle, mechanism uint, digest []b
 
 	return sig.marshalDER()
 }
+
+// Compute *DSA signature and return it as produced by the token, i.e. r and s concatenated
+func (k *pkcs11PrivateKey) dsaGenericRaw(ctx context.Context, mechanism uint, digest []byte) ([]byte, error) {
+	c := k.context
+	var err error
+	var sigBytes []byte
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}
+	err = k.withSessionContext(ctx, func(session *pkcs11Session) error {
+		// Read the handle here, as it is replaced if the key has to be found again
+		key := k.handle
+		return c.withContextLogin(session, func() error {
+			if err = c.ctx.SignInit(session.handle, mech, key); err != nil {
+				return newOperationError(session, key, "sign", mechanism, err)
+			}
+			if err = c.contextSpecificLogin(session); err != nil {
+				return newOperationError(session, key, "sign", mechanism, err)
+			}
+			if sigBytes, err = c.ctx.Sign(session.handle, digest); err != nil {
+				return newOperationError(session, key, "sign", mechanism, err)
+			}
+			c.traceMechanism("sign", mechanism)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sigBytes, nil
+}
@@ -1,6 +1,8 @@
 package crypto11
 
 import (
+	"bytes"
+	"math/big"
 	"testing"
 )
 
@@ -34,3 +36,19 @@ func TestULongMasking(t *testing.T) {
 		}
 	}
 }
+
+func TestDSASignatureMarshalRaw(t *testing.T) {
+	sig := dsaSignature{R: big.NewInt(0x0102), S: big.NewInt(0x03)}
+
+	raw, err := sig.marshalRaw(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, []byte{0, 0, 1, 2, 0, 0, 0, 3}) {
+		t.Errorf("unexpected raw signature: %x", raw)
+	}
+
+	if _, err = sig.marshalRaw(1); err == nil {
+		t.Error("expected an error for a signature too long for the key")
+	}
+}
@@ -70,9 +70,19 @@
 // session from the pool. A zero value means there is no limit. Timeouts
 // occur if the pool is fully used and additional operations are requested.
 //
+// - OperationTimeout controls how long an operation can spend on the token once it
+// has a session. A zero value means there is no limit.
+//
 // - MaxSessions sets an upper bound on the number of sessions. If this value is zero,
 // a default maximum is used (see DefaultMaxSessions). In every case the maximum
-// supported sessions as reported by the token is obeyed.
+// supported sessions as reported by the token is obeyed. The maximum can be changed
+// later with Context.SetMaxSessions.
+//
+// - MinSessions sets the number of sessions opened in the pool by Configure. Since
+// one session is kept for the Context's own use, it must be less than the maximum.
+//
+// - IdleTimeout causes pooled sessions that have been idle for longer than the timeout
+// to be replaced with fresh ones. Replacement never reduces the number of open sessions.
 //
 // Limitations
 //
@@ -88,11 +98,15 @@
 package crypto11
 
 import (
+	"context"
 	"crypto"
+	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -110,17 +124,91 @@ const (
 	// DefaultGCMIVLength controls the expected length of IVs generated by the token
 	DefaultGCMIVLength = 16
 
+	// DefaultSlotEventPollInterval controls how often WaitForSlotEvent checks for slot events, unless otherwise
+	// specified in the Config object.
+	DefaultSlotEventPollInterval = 500 * time.Millisecond
+
 	// Thales vendor constant for CKU_CRYPTO_USER
 	CryptoUser      = 0x80000001
 	DefaultUserType = 1 // 1 -> CKU_USER
 )
 
-// errTokenNotFound represents the failure to find the requested PKCS#11 token
-var errTokenNotFound = errors.New("could not find PKCS#11 token")
+// LoginUserType selects how a Context authenticates to the token.
+type LoginUserType int
+
+const (
+	// LoginUser logs in as the normal user (CKU_USER, or the user type given by Config.UserType) with the
+	// configured PIN. This is the default.
+	LoginUser LoginUserType = iota
+
+	// LoginSO logs in as the Security Officer (CKU_SO) with Config.SOPin.
+	LoginSO
+
+	// LoginNone does not log in, so only public objects are accessible. This suits tokens that do not support
+	// logging in, or whose user PIN is not initialised.
+	LoginNone
+)
+
+// String returns the name of the login type used in config files.
+func (t LoginUserType) String() string {
+	switch t {
+	case LoginUser:
+		return "user"
+	case LoginSO:
+		return "so"
+	case LoginNone:
+		return "none"
+	default:
+		return fmt.Sprintf("LoginUserType(%d)", int(t))
+	}
+}
+
+// MarshalText encodes the login type as its name.
+func (t LoginUserType) MarshalText() ([]byte, error) {
+	switch t {
+	case LoginUser, LoginSO, LoginNone:
+		return []byte(t.String()), nil
+	default:
+		return nil, errors.Errorf("unknown login type %d", int(t))
+	}
+}
+
+// UnmarshalText decodes a login type from its name.
+func (t *LoginUserType) UnmarshalText(text []byte) error {
+	for _, candidate := range []LoginUserType{LoginUser, LoginSO, LoginNone} {
+		if string(text) == candidate.String() {
+			*t = candidate
+			return nil
+		}
+	}
+	return errors.Errorf("unknown login type %q, expected user, so or none", text)
+}
+
+// TokenNotFoundError is returned when no token matches the Config. It lists the tokens that were found instead, to
+// help diagnose a misconfigured token selector.
+type TokenNotFoundError struct {
+	// Available describes the tokens present in the library's slots. It is nil if they could not be listed.
+	Available []TokenDescriptor
+}
+
+func (e *TokenNotFoundError) Error() string {
+	if len(e.Available) == 0 {
+		return "could not find PKCS#11 token (no tokens are present)"
+	}
+
+	available := make([]string, len(e.Available))
+	for i, token := range e.Available {
+		available[i] = fmt.Sprintf("slot %d: label %q, serial %q", token.Slot, token.Label, token.SerialNumber)
+	}
+	return fmt.Sprintf("could not find PKCS#11 token (available tokens: %s)", strings.Join(available, "; "))
+}
 
 // errClosed is returned if a Context is used after a call to Close.
 var errClosed = errors.New("cannot used closed Context")
 
+// ErrConnectTimeout is returned by Configure if connecting to the token takes longer than Config.ConnectTimeout.
+var ErrConnectTimeout = errors.New("timed out connecting to the PKCS#11 token")
+
 // pkcs11Object contains a reference to a loaded PKCS#11 object.
 type pkcs11Object struct {
 	// The PKCS#11 object handle.
@@ -132,12 +220,87 @@ type pkcs11Object struct {
 }
 
 func (o *pkcs11Object) Delete() error {
-	return o.context.withSession(func(session *pkcs11Session) error {
+	return o.context.withRWSession(func(session *pkcs11Session) error {
 		err := session.ctx.DestroyObject(session.handle, o.handle)
 		return errors.WithMessage(err, "failed to destroy key")
 	})
 }
 
+// Attribute returns the value of a single attribute (CKA_...) of the object, for example to check CKA_SENSITIVE.
+func (o *pkcs11Object) Attribute(attr uint) (*pkcs11.Attribute, error) {
+	attributes, err := o.Attributes([]uint{attr})
+	if err != nil {
+		return nil, err
+	}
+	return attributes[0], nil
+}
+
+// IsToken returns true if the object is a token object (CKA_TOKEN true), which persists on the token, or false if it
+// is a session object, which is destroyed when the Context is closed.
+func (o *pkcs11Object) IsToken() (bool, error) {
+	attribute, err := o.Attribute(pkcs11.CKA_TOKEN)
+	if err != nil {
+		return false, err
+	}
+	return len(attribute.Value) == 1 && attribute.Value[0] != 0, nil
+}
+
+// AlwaysAuthenticate returns true if the object has CKA_ALWAYS_AUTHENTICATE set, so that the user must log in again
+// with CKU_CONTEXT_SPECIFIC before each operation with it (see Config.ContextSpecificLogin).
+func (o *pkcs11Object) AlwaysAuthenticate() (bool, error) {
+	attribute, err := o.Attribute(pkcs11.CKA_ALWAYS_AUTHENTICATE)
+	if isPKCS11Error(err, pkcs11.CKR_ATTRIBUTE_TYPE_INVALID) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(attribute.Value) == 1 && attribute.Value[0] != 0, nil
+}
+
+// Identifier returns the CKA_ID and CKA_LABEL of the object, which are empty if not set.
+func (o *pkcs11Object) Identifier() (id []byte, label []byte, err error) {
+	attributes, err := o.Attributes([]uint{pkcs11.CKA_ID, pkcs11.CKA_LABEL})
+	if err != nil {
+		return nil, nil, err
+	}
+	return attributes[0].Value, attributes[1].Value, nil
+}
+
+// Attributes returns the values of the given attributes (CKA_...) of the object, in the order requested. If the token
+// cannot return one of the attributes, the error identifies which.
+func (o *pkcs11Object) Attributes(attrs []uint) ([]*pkcs11.Attribute, error) {
+	if o.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	template := make([]*pkcs11.Attribute, len(attrs))
+	for i, attr := range attrs {
+		template[i] = pkcs11.NewAttribute(attr, nil)
+	}
+
+	var attributes []*pkcs11.Attribute
+	err := o.context.withSession(func(session *pkcs11Session) (err error) {
+		attributes, err = session.ctx.GetAttributeValue(session.handle, o.handle, template)
+		if err == nil {
+			return nil
+		}
+
+		// Find out which attribute is at fault
+		for _, a := range template {
+			if _, attrErr := session.ctx.GetAttributeValue(session.handle, o.handle,
+				[]*pkcs11.Attribute{a}); attrErr != nil {
+				return errors.WithMessagef(attrErr, "failed to read attribute %s", attributeName(a.Type))
+			}
+		}
+		return errors.WithMessage(err, "failed to read attributes")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
 // pkcs11PrivateKey contains a reference to a loaded PKCS#11 private key object.
 type pkcs11PrivateKey struct {
 	pkcs11Object
@@ -146,8 +309,78 @@ type pkcs11PrivateKey struct {
 	pubKeyHandle pkcs11.ObjectHandle
 
 	// pubKey is an exported copy of the public key. We pre-export the key material because crypto.Signer.Public
-	// doesn't allow us to return errors.
+	// doesn't allow us to return errors. Protected by Context.pubKeyMutex once the key has been returned to the caller.
 	pubKey crypto.PublicKey
+
+	// identity is used to find the key again if its handles become invalid. It is nil if the key has no CKA_ID.
+	identity *keyIdentity
+}
+
+// Copy creates a copy of the object on the token using C_CopyObject, with the attributes in template replacing those
+// of the original, and returns the handle of the new object. Tokens refuse to copy objects whose CKA_COPYABLE is false.
+func (o *pkcs11Object) Copy(template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	if o.context.closed.Get() {
+		return 0, errClosed
+	}
+
+	var handle pkcs11.ObjectHandle
+	err := o.context.withRWSession(func(session *pkcs11Session) (err error) {
+		handle, err = session.ctx.CopyObject(session.handle, o.handle, template)
+		return err
+	})
+	if isPKCS11Error(err, pkcs11.CKR_ACTION_PROHIBITED) {
+		return 0, errors.WithMessage(err, "object cannot be copied, as CKA_COPYABLE is false")
+	}
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to copy object")
+	}
+	return handle, nil
+}
+
+// SetLabel sets the CKA_LABEL of the object. If the object has CKA_MODIFIABLE false, the error satisfies errors.Is for
+// ErrNotModifiable.
+func (o *pkcs11Object) SetLabel(label []byte) error {
+	return o.setAttribute(pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+}
+
+// SetID sets the CKA_ID of the object. If the object has CKA_MODIFIABLE false, the error satisfies errors.Is for
+// ErrNotModifiable.
+func (o *pkcs11Object) SetID(id []byte) error {
+	return o.setAttribute(pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+}
+
+func (o *pkcs11Object) setAttribute(attribute *pkcs11.Attribute) error {
+	if o.context.closed.Get() {
+		return errClosed
+	}
+
+	return o.context.withRWSession(func(session *pkcs11Session) error {
+		return setObjectAttribute(session, o.handle, attribute)
+	})
+}
+
+// setObjectAttribute sets a single attribute of the object with the given handle. If the token refuses because the
+// object has CKA_MODIFIABLE false, the error returned satisfies errors.Is for ErrNotModifiable.
+func setObjectAttribute(session *pkcs11Session, handle pkcs11.ObjectHandle, attribute *pkcs11.Attribute) error {
+	err := session.ctx.SetAttributeValue(session.handle, handle, []*pkcs11.Attribute{attribute})
+	if err == nil {
+		return nil
+	}
+
+	code, ok := err.(pkcs11.Error)
+	if ok && (code == pkcs11.CKR_ATTRIBUTE_READ_ONLY || code == pkcs11.CKR_ACTION_PROHIBITED) {
+		// Both codes are also used for other reasons, so check the object really is unmodifiable
+		template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_MODIFIABLE, nil)}
+		modifiable, attrErr := session.ctx.GetAttributeValue(session.handle, handle, template)
+		if attrErr == nil && len(modifiable[0].Value) == 1 && modifiable[0].Value[0] == 0 {
+			return &codeError{
+				err:      errors.WithMessage(err, "object cannot be modified, as CKA_MODIFIABLE is false"),
+				code:     code,
+				sentinel: ErrNotModifiable,
+			}
+		}
+	}
+	return errors.WithMessagef(err, "failed to set attribute %s", attributeName(attribute.Type))
 }
 
 // Delete implements Signer.Delete.
@@ -157,12 +390,172 @@ func (k *pkcs11PrivateKey) Delete() error {
 		return err
 	}
 
-	return k.context.withSession(func(session *pkcs11Session) error {
+	return k.context.withRWSession(func(session *pkcs11Session) error {
 		err := session.ctx.DestroyObject(session.handle, k.pubKeyHandle)
 		return errors.WithMessage(err, "failed to destroy public key")
 	})
 }
 
+// keyPair is implemented by all PKCS#11 asymmetric key pairs.
+type keyPair interface {
+	privateKey() *pkcs11PrivateKey
+}
+
+// privateKey implements keyPair.
+func (k *pkcs11PrivateKey) privateKey() *pkcs11PrivateKey {
+	return k
+}
+
+// setLabel sets CKA_LABEL on both halves of the key pair.
+func (k *pkcs11PrivateKey) setLabel(session *pkcs11Session, label []byte) error {
+	return k.setPairAttribute(session, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+}
+
+// setPairAttribute sets a single attribute on both halves of the key pair. If the public key object cannot be
+// updated, the private key object is restored to its previous value.
+func (k *pkcs11PrivateKey) setPairAttribute(session *pkcs11Session, attribute *pkcs11.Attribute) error {
+	// The public half may come from a certificate, in which case there is no public key object
+	if k.pubKeyHandle == 0 {
+		return setObjectAttribute(session, k.handle, attribute)
+	}
+
+	previous, err := session.ctx.GetAttributeValue(session.handle, k.handle,
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(attribute.Type, nil)})
+	if err != nil {
+		return errors.WithMessagef(err, "failed to read attribute %s", attributeName(attribute.Type))
+	}
+
+	if err = setObjectAttribute(session, k.handle, attribute); err != nil {
+		return err
+	}
+
+	if err = setObjectAttribute(session, k.pubKeyHandle, attribute); err != nil {
+		_ = setObjectAttribute(session, k.handle, previous[0])
+		return errors.WithMessage(err, "failed to update public key")
+	}
+	return nil
+}
+
+// SetLabel sets the CKA_LABEL of both the private and public key objects. If either has CKA_MODIFIABLE false, the
+// error satisfies errors.Is for ErrNotModifiable, and neither is changed.
+func (k *pkcs11PrivateKey) SetLabel(label []byte) error {
+	return k.setIdentifier(pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+}
+
+// SetID sets the CKA_ID of both the private and public key objects. If either has CKA_MODIFIABLE false, the error
+// satisfies errors.Is for ErrNotModifiable, and neither is changed.
+func (k *pkcs11PrivateKey) SetID(id []byte) error {
+	return k.setIdentifier(pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+}
+
+// setIdentifier sets CKA_ID or CKA_LABEL on both halves of the key pair, and updates the key's identity so it can
+// still be found again if its handles become invalid.
+func (k *pkcs11PrivateKey) setIdentifier(attribute *pkcs11.Attribute) error {
+	if k.context.closed.Get() {
+		return errClosed
+	}
+
+	// Hold the identity lock first, as refreshHandles does, so that the handles cannot be replaced meanwhile
+	if k.identity != nil {
+		k.identity.mutex.Lock()
+		defer k.identity.mutex.Unlock()
+	}
+
+	err := k.context.withRWSession(func(session *pkcs11Session) error {
+		return k.setPairAttribute(session, attribute)
+	})
+	if err != nil || k.identity == nil {
+		return err
+	}
+
+	value := append([]byte(nil), attribute.Value...)
+	if attribute.Type == pkcs11.CKA_LABEL {
+		if len(value) == 0 {
+			value = nil
+		}
+		k.identity.label = value
+	} else if len(value) != 0 {
+		// A key without a CKA_ID cannot be found again, so keep the previous one rather than matching any key
+		k.identity.id = value
+	}
+	return nil
+}
+
+// PublicHandle returns the handle of the public key object, or zero if the public key did not come from a public key
+// object (e.g. it was read from a certificate).
+func (k *pkcs11PrivateKey) PublicHandle() pkcs11.ObjectHandle {
+	defer k.lockHandles()()
+	return k.pubKeyHandle
+}
+
+// lockHandles prevents the key's handles being replaced by refreshHandles until the returned function is called.
+func (k *pkcs11PrivateKey) lockHandles() (unlock func()) {
+	if k.identity == nil {
+		return func() {}
+	}
+	k.identity.mutex.RLock()
+	return k.identity.mutex.RUnlock
+}
+
+// Attribute is like pkcs11Object.Attribute, but prevents the key's handle being replaced meanwhile.
+func (k *pkcs11PrivateKey) Attribute(attr uint) (*pkcs11.Attribute, error) {
+	defer k.lockHandles()()
+	return k.pkcs11Object.Attribute(attr)
+}
+
+// Attributes is like pkcs11Object.Attributes, but prevents the key's handle being replaced meanwhile.
+func (k *pkcs11PrivateKey) Attributes(attrs []uint) ([]*pkcs11.Attribute, error) {
+	defer k.lockHandles()()
+	return k.pkcs11Object.Attributes(attrs)
+}
+
+// IsToken is like pkcs11Object.IsToken, but prevents the key's handle being replaced meanwhile.
+func (k *pkcs11PrivateKey) IsToken() (bool, error) {
+	defer k.lockHandles()()
+	return k.pkcs11Object.IsToken()
+}
+
+// AlwaysAuthenticate is like pkcs11Object.AlwaysAuthenticate, but prevents the key's handle being replaced meanwhile.
+func (k *pkcs11PrivateKey) AlwaysAuthenticate() (bool, error) {
+	defer k.lockHandles()()
+	return k.pkcs11Object.AlwaysAuthenticate()
+}
+
+// Identifier is like pkcs11Object.Identifier, but prevents the key's handle being replaced meanwhile.
+func (k *pkcs11PrivateKey) Identifier() (id []byte, label []byte, err error) {
+	defer k.lockHandles()()
+	return k.pkcs11Object.Identifier()
+}
+
+// Copy is like pkcs11Object.Copy, but prevents the key's handle being replaced meanwhile.
+func (k *pkcs11PrivateKey) Copy(template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	defer k.lockHandles()()
+	return k.pkcs11Object.Copy(template)
+}
+
+// RefreshPublic reads the public key object again and replaces the copy of the public key returned by Public.
+func (k *pkcs11PrivateKey) RefreshPublic() error {
+	if k.context.closed.Get() {
+		return errClosed
+	}
+
+	defer k.lockHandles()()
+	if k.pubKeyHandle == 0 {
+		return errors.New("key has no public key object")
+	}
+
+	return k.context.withSession(func(session *pkcs11Session) error {
+		pub, err := exportPublicKey(session, k.pubKeyHandle)
+		if err != nil {
+			return err
+		}
+		k.context.pubKeyMutex.Lock()
+		k.pubKey = pub
+		k.context.pubKeyMutex.Unlock()
+		return nil
+	})
+}
+
 // A Context stores the connection state to a PKCS#11 token. Use Configure or ConfigureFromFile to create a new
 // Context. Call Close when finished with the token, to free up resources.
 //
@@ -171,22 +564,76 @@ type Context struct {
 	// Atomic fields must be at top (according to the package owners)
 	closed pool.AtomicBool
 
+	// waitTimeouts counts the times a session could not be obtained within Config.PoolWaitTimeout.
+	waitTimeouts pool.AtomicInt64
+
+	// generation counts the times the library has been reinitialized. See Reinitialize.
+	generation pool.AtomicInt64
+
 	ctx *PKCS11Context
 	cfg *Config
 
-	token *pkcs11.TokenInfo
-	slot  uint
-	pool  *pool.ResourcePool
+	token    *pkcs11.TokenInfo
+	slot     uint
+	slotInfo *pkcs11.SlotInfo
+	pool     *pool.ResourcePool
+
+	// readOnlySessions is true if the pool holds read-only sessions. Operations that modify the token use
+	// withRWSession to obtain a read-write session.
+	readOnlySessions bool
+
+	// slotMutex protects token, slot and slotInfo, which Reinitialize replaces.
+	slotMutex sync.RWMutex
 
 	// persistentSession is a session held open so we can be confident handles and login status
-	// persist for the duration of this context
+	// persist for the duration of this context. It is replaced by relogin and Reinitialize, so must only be used while
+	// holding reloginMutex.
 	persistentSession pkcs11.SessionHandle
+
+	// singleSession is the session used by all operations when Config.SingleThreaded is set. It is taken from the
+	// pool on first use and returned by Close.
+	singleSession *pkcs11Session
+
+	// reloginMutex serialises recovery of the long-term session after a loss of connection, the creation of
+	// session objects on it, login operations using it, and closing it.
+	reloginMutex sync.Mutex
+
+	// pin is the PIN given to Login, if any, which takes precedence over the configured PIN for context-specific
+	// logins. Protected by pinMutex.
+	pin      string
+	pinMutex sync.Mutex
+
+	// mechanisms caches the result of SupportedMechanisms until Close. Protected by mechanismsMutex.
+	mechanisms      []*pkcs11.Mechanism
+	mechanismsMutex sync.Mutex
+
+	// pins maps the handle of a private key to the *sessionPin used by WithSession on the key, while any call to
+	// WithSession on the key is in progress. Protected by pinsMutex.
+	pins      map[pkcs11.ObjectHandle]*sessionPin
+	pinsMutex sync.Mutex
+
+	// pubKeyMutex protects the pubKey field of the Context's keys, which RefreshPublic may replace.
+	pubKeyMutex sync.RWMutex
+
+	// randomIDMutex is held while a random CKA_ID is chosen and used to create a key. See withRandomID.
+	randomIDMutex sync.Mutex
 }
 
 // Encapsulates pkcs11.Ctx context.
 type PKCS11Context struct {
 	pkcs11.Ctx
 	libraryPath string
+
+	// libraryKey identifies the library in refCount. See libraryKey.
+	libraryKey string
+
+	// keepLoaded leaves the library initialized when this is the last PKCS11Context using it. See
+	// Config.KeepLibraryLoaded.
+	keepLoaded bool
+
+	// external is true if the pkcs11.Ctx was supplied by the caller, who remains responsible for initializing and
+	// finalizing it. See ConfigureWithContext.
+	external bool
 }
 
 // Signer is a PKCS#11 key that implements crypto.Signer.
@@ -205,8 +652,72 @@ type SignerDecrypter interface {
 	Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) (plaintext []byte, err error)
 }
 
-// findToken finds a token given exactly one of serial, label or slotNumber
-func (c *Context) findToken(slots []uint, serial, label string, slotNumber *int) (uint, *pkcs11.TokenInfo, error) {
+// ContextSigner is implemented by the Signer values returned by this package. SignContext is like Sign, but gives up
+// when ctx is done, returning an error that wraps ctx.Err(). This bounds the time spent waiting for a session from
+// the pool, or for a slow token to respond.
+//
+// A PKCS#11 call cannot be interrupted. If ctx is done while the token is working, the session is abandoned and
+// replaced in the pool by a new one.
+type ContextSigner interface {
+	Signer
+
+	// SignContext is like crypto.Signer.Sign, but gives up when ctx is done.
+	SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+// ContextDecrypter is implemented by the RSA keys returned by this package. DecryptContext is like Decrypt, but gives
+// up when ctx is done, in the same way as ContextSigner.SignContext.
+type ContextDecrypter interface {
+	SignerDecrypter
+
+	// DecryptContext is like crypto.Decrypter.Decrypt, but gives up when ctx is done.
+	DecryptContext(ctx context.Context, rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error)
+}
+
+// SessionPinner is implemented by the Signer values returned by this package. See pkcs11PrivateKey.WithSession.
+type SessionPinner interface {
+	// WithSession runs fn with a session from the pool dedicated to the key, which operations on the key use until
+	// fn returns.
+	WithSession(fn func() error) error
+}
+
+// AlwaysAuthenticator is implemented by the Signer values and secret keys returned by this package. See
+// pkcs11Object.AlwaysAuthenticate.
+type AlwaysAuthenticator interface {
+	// AlwaysAuthenticate returns true if the key requires a context-specific login before each use.
+	AlwaysAuthenticate() (bool, error)
+}
+
+// IdentifierSetter is implemented by the Signer values and secret keys returned by this package. For a key pair, both
+// the private and public key objects are updated.
+type IdentifierSetter interface {
+	// SetLabel sets the CKA_LABEL of the key.
+	SetLabel(label []byte) error
+
+	// SetID sets the CKA_ID of the key.
+	SetID(id []byte) error
+}
+
+// PublicKeyRefresher is implemented by the Signer values returned by this package. See
+// pkcs11PrivateKey.RefreshPublic.
+type PublicKeyRefresher interface {
+	// PublicHandle returns the handle of the public key object, or zero if there is none.
+	PublicHandle() pkcs11.ObjectHandle
+
+	// RefreshPublic reads the public key object again, updating the value returned by Public.
+	RefreshPublic() error
+}
+
+// SignerDeriver is a PKCS#11 key that implements crypto.Signer and can agree a shared secret with a peer using ECDH.
+type SignerDeriver interface {
+	Signer
+
+	// Derive agrees a shared secret with the holder of peerPublic and returns it as a generic secret key.
+	Derive(peerPublic *ecdsa.PublicKey) (*SecretKey, error)
+}
+
+// findToken finds a token given exactly one of the token selectors in config
+func (c *Context) findToken(slots []uint, config *Config) (uint, *pkcs11.TokenInfo, error) {
 	for _, slot := range slots {
 
 		tokenInfo, err := c.ctx.GetTokenInfo(slot)
@@ -214,27 +725,123 @@ func (c *Context) findToken(slots []uint, serial, label string, slotNumber *int)
 			return 0, nil, err
 		}
 
-		if (slotNumber != nil && uint(*slotNumber) == slot) ||
-			(tokenInfo.SerialNumber != "" && tokenInfo.SerialNumber == serial) ||
-			(tokenInfo.Label != "" && tokenInfo.Label == label) {
+		if (config.SlotNumber != nil && uint(*config.SlotNumber) == slot) ||
+			(tokenInfo.SerialNumber != "" && tokenInfo.SerialNumber == config.TokenSerial) ||
+			(tokenInfo.Label != "" && tokenInfo.Label == config.TokenLabel) ||
+			matchesPaddedField(tokenInfo.ManufacturerID, config.TokenManufacturer) {
 
 			return slot, &tokenInfo, nil
 		}
 
+		if config.SlotDescription != "" {
+			slotInfo, err := c.ctx.GetSlotInfo(slot)
+			if err != nil {
+				return 0, nil, err
+			}
+			if matchesPaddedField(slotInfo.SlotDescription, config.SlotDescription) {
+				return slot, &tokenInfo, nil
+			}
+		}
+
+	}
+
+	available, err := listTokens(c.ctx, slots)
+	if err != nil {
+		c.logf("crypto11: failed to list available tokens: %v", err)
+	}
+	return 0, nil, &TokenNotFoundError{Available: available}
+}
+
+// matchesPaddedField returns true if want is non-empty and equal to value, ignoring the trailing spaces with which
+// PKCS#11 pads fixed-width text fields.
+func matchesPaddedField(value, want string) bool {
+	want = strings.TrimRight(want, " ")
+	return want != "" && strings.TrimRight(value, " ") == want
+}
+
+// TokenDescriptor describes a token found by ListTokens. Text fields have the padding added by PKCS#11 removed.
+type TokenDescriptor struct {
+	// Slot is the ID of the slot containing the token, which may be used as Config.SlotNumber.
+	Slot uint
+
+	// Label is the token label (CK_TOKEN_INFO.label).
+	Label string
+
+	// SerialNumber is the token serial number, which may be used as Config.TokenSerial.
+	SerialNumber string
+
+	// ManufacturerID identifies the token manufacturer.
+	ManufacturerID string
+
+	// Model is the token model.
+	Model string
+
+	// SlotDescription describes the slot (CK_SLOT_INFO.slotDescription).
+	SlotDescription string
+}
+
+// ListTokens loads the PKCS#11 library at libraryPath and describes every token present, in the order of the slots
+// reported by the library. It does not log in. Several tokens may share a label, so callers may use it to choose one
+// deterministically, then select it by serial number with Config.TokenSerial.
+//
+// The library is initialized for the duration of the call and finalized afterwards, unless a Context is using it.
+func ListTokens(libraryPath string) (tokens []TokenDescriptor, err error) {
+	pkcs11Context, err := NewPKCS11Context(libraryPath)
+	if err != nil {
+		return nil, mapPKCS11Error(err)
+	}
+	defer func() {
+		if closeErr := pkcs11Context.Close(); err == nil && closeErr != nil {
+			err = errors.WithMessage(closeErr, "failed to finalize PKCS#11 library")
+		}
+		err = mapPKCS11Error(err)
+	}()
+
+	slots, err := pkcs11Context.GetSlotList(true)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to list PKCS#11 slots")
+	}
+	return listTokens(pkcs11Context, slots)
+}
+
+// listTokens describes the tokens in slots.
+func listTokens(pkcs11Context *PKCS11Context, slots []uint) (tokens []TokenDescriptor, err error) {
+	for _, slot := range slots {
+		tokenInfo, err := pkcs11Context.GetTokenInfo(slot)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to get token info for slot %d", slot)
+		}
+		slotInfo, err := pkcs11Context.GetSlotInfo(slot)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to get slot info for slot %d", slot)
+		}
+
+		tokens = append(tokens, TokenDescriptor{
+			Slot:            slot,
+			Label:           strings.TrimRight(tokenInfo.Label, " "),
+			SerialNumber:    strings.TrimRight(tokenInfo.SerialNumber, " "),
+			ManufacturerID:  strings.TrimRight(tokenInfo.ManufacturerID, " "),
+			Model:           strings.TrimRight(tokenInfo.Model, " "),
+			SlotDescription: strings.TrimRight(slotInfo.SlotDescription, " "),
+		})
 	}
-	return 0, nil, errTokenNotFound
+	return tokens, nil
 }
 
 // Config holds PKCS#11 configuration information.
 //
-// A token may be selected by label, serial number or slot number. It is an error to specify
-// more than one way to select the token.
+// A token may be selected by label, serial number, slot number, slot description or token manufacturer. It is an
+// error to specify more than one way to select the token.
 //
 // Supply this to Configure(), or alternatively use ConfigureFromFile().
 type Config struct {
-	// Full path to PKCS#11 library.
+	// Full path to PKCS#11 library. This is a shortcut for a Paths list with a single element.
 	Path string
 
+	// Paths lists alternative full paths to the PKCS#11 library, which are tried in order. The first library that
+	// loads and contains the requested token is used. Path and Paths must not both be given.
+	Paths []string
+
 	// Token serial number.
 	TokenSerial string
 
@@ -244,22 +851,123 @@ type Config struct {
 	// SlotNumber identifies a token to use by the slot containing it.
 	SlotNumber *int
 
+	// SlotDescription identifies a token to use by the description of the slot containing it. Trailing spaces are
+	// ignored.
+	SlotDescription string
+
+	// TokenManufacturer identifies a token to use by its manufacturer ID. The first matching token is used.
+	// Trailing spaces are ignored.
+	TokenManufacturer string
+
 	// User PIN (password).
 	Pin string
 
+	// PinFile is the path of a file containing the user PIN, used if Pin is empty. A trailing newline is ignored.
+	PinFile string
+
+	// PinEnvVar is the name of an environment variable containing the user PIN, used if Pin and PinFile are empty.
+	PinEnvVar string
+
+	// PinProvider, if set, is called to obtain the user PIN each time a login is needed, instead of using Pin.
+	// The PIN is discarded after use, so it is not held by the Context between logins. Pin must be empty
+	// if PinProvider is set. The field is ignored when reading a Config from JSON.
+	PinProvider func() (string, error) `json:"-"`
+
+	// Security Officer PIN, used only by InitPIN.
+	SOPin string
+
 	// Maximum number of concurrent sessions to open. If zero, DefaultMaxSessions is used.
 	// Otherwise, the value specified must be at least 2.
 	MaxSessions int
 
+	// ReadOnlySessions makes the pool hold read-only sessions, for tokens that allow few concurrent read-write
+	// sessions. Operations that modify the token, such as key generation and deletion, then open a one-off read-write
+	// session for their duration, and fail if the token will not provide one.
+	ReadOnlySessions bool
+
+	// Number of sessions to open in the pool when the Context is configured. Must be less than MaxSessions, as
+	// one session is kept for the Context's own use, and less than the token's maximum session count.
+	MinSessions int
+
+	// Pooled sessions unused for longer than this are closed and replaced with new sessions. Zero means idle
+	// sessions are never replaced.
+	IdleTimeout time.Duration
+
 	// User type identifies the user type logging in. If zero, DefaultUserType is used.
 	UserType int
 
 	// Maximum time to wait for a session from the sessions pool. Zero means wait indefinitely.
 	PoolWaitTimeout time.Duration
 
-	// LoginNotSupported should be set to true for tokens that do not support logging in.
+	// MaxSessionRetries is the number of times an operation is retried if its session turns out to be invalid
+	// (CKR_SESSION_HANDLE_INVALID or CKR_SESSION_CLOSED), for example after the connection to a network HSM was
+	// lost. Before each retry, the Context logs in again if necessary. Zero means operations are not retried.
+	MaxSessionRetries int
+
+	// RetryPolicy retries operations that fail with a transient error, such as CKR_DEVICE_ERROR from a network HSM
+	// under load. The zero value disables such retries.
+	RetryPolicy RetryPolicy
+
+	// ConnectTimeout bounds the time Configure spends loading the library, opening the token and logging in. Zero
+	// means wait indefinitely. If exceeded, ErrConnectTimeout is returned. PKCS#11 calls cannot be cancelled, so the
+	// connection attempt carries on in the background until the token responds, and is then closed.
+	ConnectTimeout time.Duration
+
+	// Maximum time an operation may spend using a session once it has been taken from the pool. Zero means
+	// wait indefinitely. If exceeded, ErrOperationTimeout is returned. PKCS#11 calls cannot be cancelled, so
+	// the timed-out call is abandoned in the background and its session is discarded rather than returned to the
+	// pool. Streaming operations (BlockModeCloser and HMAC) are not subject to this timeout.
+	OperationTimeout time.Duration
+
+	// SingleThreaded makes operations reuse one session, held by the Context, instead of taking a session from the
+	// pool each time. No locking is done, so the Context must not be used from more than one goroutine at a time.
+	// OperationTimeout cannot be used with this mode, and cancelling a context.Context has no effect once an
+	// operation has started. Streaming operations and Signer.WithSession still take sessions from the pool.
+	// GenerateManyRSAKeyPairs generates one key pair at a time in this mode.
+	SingleThreaded bool
+
+	// OnSessionOpen, if set, is called after each session used for operations is opened and before it is used,
+	// allowing token-specific initialisation. If it returns an error, the session is closed and the operation
+	// fails. It is not called for the Context's long-term session.
+	OnSessionOpen func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) error `json:"-"`
+
+	// MechanismTracer, if set, is called after each successful sign or decrypt operation with the name of the
+	// operation and the PKCS#11 mechanism (CKM_...) that was selected for it. On failure, the mechanism is
+	// reported via OperationError instead.
+	MechanismTracer func(operation string, mechanism uint) `json:"-"`
+
+	// Logger, if set, receives diagnostic messages about session handling: sessions being opened and discarded,
+	// logins and retries. It also receives warnings, such as keys skipped while listing key pairs. If Logger is nil,
+	// nothing is logged. A *log.Logger may be used.
+	Logger Logger `json:"-"`
+
+	// SlotEventPollInterval is how often WaitForSlotEvent checks the slots for changes. If zero,
+	// DefaultSlotEventPollInterval is used.
+	SlotEventPollInterval time.Duration
+
+	// KeepLibraryLoaded leaves the PKCS#11 library initialized when the last Context using it is closed, so that a
+	// later Configure avoids the cost of loading and initializing it again. Call Shutdown to finalize the library when
+	// it is no longer needed.
+	KeepLibraryLoaded bool
+
+	// LoginNotSupported should be set to true for tokens that do not support logging in. It is equivalent to
+	// LoginUserType set to LoginNone.
 	LoginNotSupported bool
 
+	// LoginUserType selects whether the Context logs in as the normal user (the default), as the Security Officer, or
+	// not at all. In a config file it is given as "user", "so" or "none".
+	LoginUserType LoginUserType
+
+	// ContextSpecificLogin enables re-authentication for keys with CKA_ALWAYS_AUTHENTICATE. If a signing or
+	// decryption operation fails with CKR_USER_NOT_LOGGED_IN, it is retried once with a CKU_CONTEXT_SPECIFIC login,
+	// using the PIN most recently given to Context.Login or else the configured PIN.
+	ContextSpecificLogin bool
+
+	// ProtectedAuthPath forces login via the token's protected authentication path (e.g. a PIN pad on a smartcard
+	// reader), in which case Pin is ignored. Protected authentication is also used automatically if Pin is empty
+	// and the token reports CKF_PROTECTED_AUTHENTICATION_PATH.
+	ProtectedAuthPath bool
+
 	// UseGCMIVFromHSM should be set to true for tokens such as CloudHSM, which ignore the supplied IV for
 	// GCM mode and generate their own. In this case, the token will write the IV used into the CK_GCM_PARAMS.
 	// If UseGCMIVFromHSM is true, we will copy this IV and overwrite the 'nonce' slice passed to Seal and Open. It
@@ -282,17 +990,73 @@ type GCMIVFromHSMConfig struct {
 	SupplyIvForHSMGCMDecrypt bool
 }
 
+// redactedSecret replaces PINs when a Config is logged.
+const redactedSecret = "***"
+
+// configJSON has the fields of Config, but not its methods, so it is marshalled to JSON without redaction.
+type configJSON Config
+
+// MarshalJSON encodes the Config as JSON, with Pin and SOPin replaced by "***" if set, so the result is safe to log.
+// Use MarshalJSONWithSecrets to encode the Config for persistence.
+func (c Config) MarshalJSON() ([]byte, error) {
+	if c.Pin != "" {
+		c.Pin = redactedSecret
+	}
+	if c.SOPin != "" {
+		c.SOPin = redactedSecret
+	}
+	return json.Marshal(configJSON(c))
+}
+
+// MarshalJSONWithSecrets encodes the Config as JSON, including Pin and SOPin. The result is suitable for
+// ConfigureFromFile.
+func (c Config) MarshalJSONWithSecrets() ([]byte, error) {
+	return json.Marshal(configJSON(c))
+}
+
+// String returns the Config as JSON, with Pin and SOPin redacted as by MarshalJSON.
+func (c Config) String() string {
+	data, err := c.MarshalJSON()
+	if err != nil {
+		return fmt.Sprintf("invalid config: %v", err)
+	}
+	return string(data)
+}
+
 // refCount counts the number of contexts using a particular P11 library. It must not be read or modified
 // without holding refCountMutex.
 var refCount = map[string]int{}
 var refCountMutex = sync.Mutex{}
 
+// keptLibraries holds libraries left initialized by Config.KeepLibraryLoaded, by libraryKey, until Shutdown. It must
+// not be read or modified without holding refCountMutex.
+var keptLibraries = map[string]*pkcs11.Ctx{}
+
+// libraryKey returns the key under which refCount counts the users of the library at libraryPath. Paths are made
+// absolute and have symbolic links resolved, so that different spellings of the path to the same library share a
+// count and the library is not finalized while another Context still uses it. A bare file name, which the dynamic
+// loader searches for, is used unchanged.
+func libraryKey(libraryPath string) string {
+	if filepath.Base(libraryPath) == libraryPath {
+		return libraryPath
+	}
+
+	key, err := filepath.Abs(libraryPath)
+	if err != nil {
+		return libraryPath
+	}
+	if resolved, err := filepath.EvalSymlinks(key); err == nil {
+		key = resolved
+	}
+	return key
+}
+
 // NewPKCS11Context returns PKCS11 context.
 func NewPKCS11Context(libraryPath string) (pkcs11Context *PKCS11Context, err error) {
 	refCountMutex.Lock()
 	defer refCountMutex.Unlock()
 
-	pkcs11Context = &PKCS11Context{libraryPath: libraryPath}
+	pkcs11Context = &PKCS11Context{libraryPath: libraryPath, libraryKey: libraryKey(libraryPath)}
 
 	ctx := pkcs11.New(libraryPath)
 	if ctx == nil {
@@ -305,48 +1069,113 @@ func NewPKCS11Context(libraryPath string) (pkcs11Context *PKCS11Context, err err
 	}()
 
 	pkcs11Context.Ctx = *ctx
-	numExistingContexts := refCount[libraryPath]
+	numExistingContexts := refCount[pkcs11Context.libraryKey]
 
-	// Only Initialize if we are the first Context using the library
-	if numExistingContexts == 0 {
+	// Only Initialize if we are the first Context using the library, and it was not kept initialized
+	_, kept := keptLibraries[pkcs11Context.libraryKey]
+	if numExistingContexts == 0 && !kept {
 		if err = ctx.Initialize(); err != nil {
 			return nil, errors.WithMessage(err, "failed to initialize PKCS#11 library")
 		}
 	}
 
 	// Increment the reference count
-	refCount[libraryPath] = numExistingContexts + 1
+	refCount[pkcs11Context.libraryKey] = numExistingContexts + 1
 
 	return pkcs11Context, nil
 }
 
-// Close closes PKCS11 context
+// Close closes PKCS11 context. A context supplied to ConfigureWithContext is left untouched.
 func (ctx *PKCS11Context) Close() error {
-	refCountMutex.Lock()
+	if ctx.external {
+		return nil
+	}
+
+	refCountMutex.Lock()
 	defer refCountMutex.Unlock()
 
-	count, found := refCount[ctx.libraryPath]
+	count, found := refCount[ctx.libraryKey]
 	if !found || count == 0 {
 		// We have somehow lost track of reference counts, this is very bad
 		panic("invalid reference count for PKCS#11 library")
 	}
 
-	// If we were the last Context, finalize the library
-	if count == 1 {
+	// If we were the last Context, finalize the library, unless it is to be kept initialized
+	_, kept := keptLibraries[ctx.libraryKey]
+	keep := count == 1 && !kept && ctx.keepLoaded
+	if count == 1 && !kept && !keep {
 		if err := ctx.Finalize(); err != nil {
 			return err
 		}
 	}
 
-	refCount[ctx.libraryPath] = count - 1
+	refCount[ctx.libraryKey] = count - 1
 
+	if keep {
+		keptLibraries[ctx.libraryKey] = &ctx.Ctx
+		return nil
+	}
 	ctx.Destroy()
 
 	return nil
 }
 
+// Shutdown finalizes and unloads the PKCS#11 libraries left initialized by Config.KeepLibraryLoaded. It should be
+// called when the process has finished using PKCS#11. A library still used by a Context is no longer kept, and is
+// finalized when its last Context is closed, unless that Context also has KeepLibraryLoaded set.
+func Shutdown() error {
+	refCountMutex.Lock()
+	defer refCountMutex.Unlock()
+
+	var result error
+	for key, ctx := range keptLibraries {
+		delete(keptLibraries, key)
+		if refCount[key] == 0 {
+			if err := ctx.Finalize(); err != nil && result == nil {
+				result = errors.WithMessagef(err, "failed to finalize PKCS#11 library %s", key)
+			}
+		}
+		ctx.Destroy()
+	}
+	return result
+}
+
 // Configure creates a new Context based on the supplied PKCS#11 configuration.
-func Configure(config *Config) (instance *Context, err error) {
+func Configure(config *Config) (*Context, error) {
+	return configure(config, nil)
+}
+
+// ConfigureWithContext is like Configure, but uses ctx, a PKCS#11 library that the caller has already loaded and
+// initialized, rather than loading the library given by config.Path. The caller remains responsible for ctx: it is
+// neither initialized nor finalized by this package, and Close does not finalize it. The caller must not finalize ctx
+// until every Context using it has been closed.
+func ConfigureWithContext(config *Config, ctx *pkcs11.Ctx) (*Context, error) {
+	if ctx == nil {
+		return nil, errors.New("PKCS#11 context must not be nil")
+	}
+	return configure(config, ctx)
+}
+
+// Validate checks config for errors that can be found without loading the PKCS#11 library, such as a missing or
+// ambiguous token selector, a library path that does not exist or a PIN file that cannot be read. Configure performs
+// the same checks. Validate does not modify config.
+func (config *Config) Validate() error {
+	if err := config.validateSettings(); err != nil {
+		return err
+	}
+	if err := config.validatePaths(); err != nil {
+		return err
+	}
+	if config.PinProvider == nil {
+		if _, err := resolvePin(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSettings checks the settings in config other than the library paths, without modifying config.
+func (config *Config) validateSettings() error {
 	// Have we been given exactly one way to select a token?
 	var fields []string
 	if config.SlotNumber != nil {
@@ -358,58 +1187,218 @@ func Configure(config *Config) (instance *Context, err error) {
 	if config.TokenSerial != "" {
 		fields = append(fields, "token serial number")
 	}
+	if config.SlotDescription != "" {
+		fields = append(fields, "slot description")
+	}
+	if config.TokenManufacturer != "" {
+		fields = append(fields, "token manufacturer")
+	}
 	if len(fields) == 0 {
-		return nil, fmt.Errorf("config must specify exactly one way to select a token: none given")
+		return fmt.Errorf("config must specify exactly one way to select a token: none given")
 	} else if len(fields) > 1 {
-		return nil, fmt.Errorf("config must specify exactly one way to select a token: %v given", strings.Join(fields, ", "))
+		return fmt.Errorf("config must specify exactly one way to select a token: %v given", strings.Join(fields, ", "))
+	}
+
+	if config.Pin != "" && config.PinProvider != nil {
+		return errors.New("config must not specify both Pin and PinProvider")
+	}
+
+	maxSessions := config.MaxSessions
+	if maxSessions == 0 {
+		maxSessions = DefaultMaxSessions
+	}
+	if maxSessions == 1 {
+		return errors.New("MaxSessions must be larger than 1")
+	}
+	if err := checkSessionLimits(config.MinSessions, maxSessions); err != nil {
+		return err
+	}
+	if config.IdleTimeout < 0 {
+		return errors.New("IdleTimeout must not be negative")
+	}
+	if config.MaxSessionRetries < 0 {
+		return errors.New("MaxSessionRetries must not be negative")
+	}
+	if err := config.RetryPolicy.validate(); err != nil {
+		return err
+	}
+	if config.SlotEventPollInterval < 0 {
+		return errors.New("SlotEventPollInterval must not be negative")
+	}
+	if config.ConnectTimeout < 0 {
+		return errors.New("ConnectTimeout must not be negative")
+	}
+	if config.SingleThreaded && config.OperationTimeout > 0 {
+		return errors.New("OperationTimeout cannot be used with SingleThreaded")
+	}
+
+	switch config.LoginUserType {
+	case LoginUser, LoginNone:
+	case LoginSO:
+		if config.LoginNotSupported {
+			return errors.New("config must not specify both LoginNotSupported and a Security Officer login")
+		}
+		if config.SOPin == "" {
+			return errors.New("config must specify SOPin to log in as the Security Officer")
+		}
+	default:
+		return errors.Errorf("unknown LoginUserType %d", int(config.LoginUserType))
+	}
+
+	return nil
+}
+
+// validatePaths checks that config names a PKCS#11 library. Paths containing a directory must exist, although only
+// one of Paths need do so. Bare file names are searched for by the dynamic loader, so are not checked.
+func (config *Config) validatePaths() error {
+	if config.Path != "" && len(config.Paths) > 0 {
+		return errors.New("config must not specify both Path and Paths")
+	}
+
+	paths := config.libraryPaths()
+	if len(paths) == 0 {
+		return errors.New("config must specify a PKCS#11 library path")
+	}
+
+	for _, path := range paths {
+		if filepath.Base(path) == path {
+			return nil
+		}
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+
+	if len(paths) == 1 {
+		return errors.Errorf("PKCS#11 library %s does not exist", paths[0])
+	}
+	return errors.Errorf("none of the PKCS#11 libraries exist (tried %s)", strings.Join(paths, ", "))
+}
+
+// configure creates a new Context. If p11Ctx is nil, the library given by config.Path is loaded and initialized.
+func configure(config *Config, p11Ctx *pkcs11.Ctx) (instance *Context, err error) {
+	defer func() {
+		err = mapPKCS11Error(err)
+	}()
+
+	if err = config.validateSettings(); err != nil {
+		return nil, err
+	}
+	if p11Ctx == nil {
+		if err = config.validatePaths(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.PinProvider == nil {
+		if config.Pin, err = resolvePin(config); err != nil {
+			return nil, err
+		}
 	}
 
 	if config.MaxSessions == 0 {
 		config.MaxSessions = DefaultMaxSessions
 	}
-	if config.MaxSessions == 1 {
-		return nil, errors.New("MaxSessions must be larger than 1")
+	if config.SlotEventPollInterval == 0 {
+		config.SlotEventPollInterval = DefaultSlotEventPollInterval
 	}
-
 	if config.UserType == 0 {
 		config.UserType = DefaultUserType
 	}
-
 	if config.GCMIVLength == 0 {
 		config.GCMIVLength = DefaultGCMIVLength
 	}
 
-	pkcs11Context, err := NewPKCS11Context(config.Path)
+	if config.ConnectTimeout > 0 {
+		return connectWithTimeout(config, p11Ctx)
+	}
+	return connect(config, p11Ctx)
+}
+
+// connectWithTimeout is like connect, but gives up after config.ConnectTimeout. The connection attempt carries on in
+// the background, since PKCS#11 calls cannot be cancelled, and the resulting Context is closed if it succeeds.
+func connectWithTimeout(config *Config, p11Ctx *pkcs11.Ctx) (*Context, error) {
+	type result struct {
+		instance *Context
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		instance, err := connect(config, p11Ctx)
+		done <- result{instance, err}
+	}()
+
+	timer := time.NewTimer(config.ConnectTimeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.instance, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.err == nil {
+				_ = r.instance.Close()
+			}
+		}()
+		return nil, ErrConnectTimeout
+	}
+}
+
+// connect opens the token selected by config, which has been validated, and logs in.
+func connect(config *Config, p11Ctx *pkcs11.Ctx) (_ *Context, err error) {
+	// instance is not a named result, as the deferred clean-up below needs it after an error return.
+	instance := &Context{cfg: config, readOnlySessions: config.ReadOnlySessions}
+
+	if p11Ctx != nil {
+		err = instance.openToken(&PKCS11Context{Ctx: *p11Ctx, libraryPath: config.Path, external: true})
+	} else {
+		err = instance.openFirstLibrary(config.libraryPaths())
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
-			pkcs11Context.Close()
+			instance.ctx.Close()
 		}
 	}()
 
-	instance = &Context{cfg: config, ctx: pkcs11Context}
-
-	slots, err := instance.ctx.GetSlotList(true)
+	slotInfo, err := instance.ctx.GetSlotInfo(instance.slot)
 	if err != nil {
-		return nil, errors.WithMessage(err, "failed to list PKCS#11 slots")
-	}
-
-	instance.slot, instance.token, err = instance.findToken(slots, config.TokenSerial, config.TokenLabel, config.SlotNumber)
-	if err != nil {
-		return nil, err
+		return nil, errors.WithMessage(err, "failed to get PKCS#11 slot info")
 	}
+	instance.slotInfo = &slotInfo
 
 	// Create the session pool.
 	maxSessions := instance.cfg.MaxSessions
 	tokenMaxSessions := instance.token.MaxRwSessionCount
+	if instance.readOnlySessions {
+		tokenMaxSessions = instance.token.MaxSessionCount
+	}
 	if tokenMaxSessions != pkcs11.CK_EFFECTIVELY_INFINITE && tokenMaxSessions != pkcs11.CK_UNAVAILABLE_INFORMATION {
 		maxSessions = min(maxSessions, castDown(tokenMaxSessions))
+		if err = checkSessionLimits(config.MinSessions, maxSessions); err != nil {
+			return nil, errors.WithMessagef(err, "token supports at most %d sessions", maxSessions)
+		}
+	}
+
+	// SetMaxSessions may later grow the pool, up to the token's limit.
+	sessionLimit := DefaultMaxSessions
+	if maxSessions > sessionLimit {
+		sessionLimit = maxSessions
+	}
+	if tokenMaxSessions != pkcs11.CK_EFFECTIVELY_INFINITE && tokenMaxSessions != pkcs11.CK_UNAVAILABLE_INFORMATION {
+		sessionLimit = min(sessionLimit, castDown(tokenMaxSessions))
 	}
 
 	// We will use one session to keep state alive, so the pool gets maxSessions - 1
-	instance.pool = pool.NewResourcePool(instance.resourcePoolFactoryFunc, maxSessions-1, maxSessions-1, 0, 0)
+	instance.pool = pool.NewResourcePool(instance.resourcePoolFactoryFunc, maxSessions-1, sessionLimit-1,
+		config.IdleTimeout, 0)
+	defer func() {
+		if err != nil {
+			instance.pool.Close()
+		}
+	}()
 
 	// Create a long-term session and log it in (if supported). This session won't be used by callers, instead it is
 	// used to keep a connection alive to the token to ensure object handles and the log in status remain accessible.
@@ -417,26 +1406,373 @@ func Configure(config *Config) (instance *Context, err error) {
 	if err != nil {
 		return nil, errors.WithMessagef(err, "failed to create long term session")
 	}
+	defer func() {
+		if err != nil {
+			_ = instance.ctx.CloseSession(instance.persistentSession)
+		}
+	}()
 
-	if !config.LoginNotSupported {
+	if instance.loginEnabled() {
 		// Try to log in our persistent session. This may fail with CKR_USER_ALREADY_LOGGED_IN if another instance
 		// already exists.
-		if instance.cfg.UserType == 1 {
-			err = instance.ctx.Login(instance.persistentSession, pkcs11.CKU_USER, instance.cfg.Pin)
-		} else {
-			err = instance.ctx.Login(instance.persistentSession, CryptoUser, instance.cfg.Pin)
+		if err = instance.login(instance.persistentSession); err != nil {
+			return nil, errors.WithMessagef(err, "failed to log into long term session")
+		}
+	}
+
+	if err = instance.prefillSessions(config.MinSessions); err != nil {
+		return nil, err
+	}
+
+	return instance, nil
+}
+
+// libraryPaths returns the PKCS#11 library paths to try, in order.
+func (config *Config) libraryPaths() []string {
+	if config.Path != "" {
+		return []string{config.Path}
+	}
+	return config.Paths
+}
+
+// openFirstLibrary loads each of paths in turn until one contains the configured token, and opens that token.
+// If none does, the error reports why each path failed.
+func (c *Context) openFirstLibrary(paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("config must specify a PKCS#11 library path")
+	}
+
+	var failures []string
+	for _, path := range paths {
+		pkcs11Context, err := NewPKCS11Context(path)
+		if err == nil {
+			pkcs11Context.keepLoaded = c.cfg.KeepLibraryLoaded
+			err = c.openToken(pkcs11Context)
 		}
+		if err == nil {
+			return nil
+		}
+		if len(paths) == 1 {
+			return err
+		}
+		c.logf("crypto11: cannot use PKCS#11 library %s: %v", path, err)
+		failures = append(failures, fmt.Sprintf("%s: %v", path, err))
+	}
+
+	return errors.Errorf("no usable PKCS#11 library found (tried %s)", strings.Join(failures, "; "))
+}
+
+// openToken finds the configured token using pkcs11Context. On success, the Context takes ownership of
+// pkcs11Context; otherwise it is closed.
+func (c *Context) openToken(pkcs11Context *PKCS11Context) (err error) {
+	defer func() {
 		if err != nil {
+			pkcs11Context.Close()
+		}
+	}()
 
-			pErr, isP11Error := err.(pkcs11.Error)
+	slots, err := pkcs11Context.GetSlotList(true)
+	if err != nil {
+		return errors.WithMessage(err, "failed to list PKCS#11 slots")
+	}
 
-			if !isP11Error || pErr != pkcs11.CKR_USER_ALREADY_LOGGED_IN {
-				return nil, errors.WithMessagef(err, "failed to log into long term session")
-			}
+	c.ctx = pkcs11Context
+	c.slot, c.token, err = c.findToken(slots, c.cfg)
+	if err != nil {
+		c.ctx = nil
+		return err
+	}
+	return nil
+}
+
+// checkSessionLimits checks that minSessions sessions can be held in a pool, given a maximum of maxSessions
+// sessions of which one is kept as the long-term session.
+func checkSessionLimits(minSessions, maxSessions int) error {
+	if minSessions < 0 {
+		return errors.New("MinSessions must not be negative")
+	}
+	if minSessions > maxSessions-1 {
+		return errors.Errorf("MinSessions (%d) must be less than MaxSessions (%d), as one session is reserved",
+			minSessions, maxSessions)
+	}
+	return nil
+}
+
+// login logs the configured user into a session. CKR_USER_ALREADY_LOGGED_IN is not treated as an error, since login
+// state is shared between all sessions of an application.
+func (c *Context) login(session pkcs11.SessionHandle) error {
+	pin, err := c.userPin()
+	if err != nil {
+		return err
+	}
+
+	return c.loginWithPin(session, c.userType(), pin)
+}
+
+// loginEnabled returns false if the Context is configured not to log in.
+func (c *Context) loginEnabled() bool {
+	return !c.cfg.LoginNotSupported && c.cfg.LoginUserType != LoginNone
+}
+
+// userType returns the PKCS#11 user type (CKU_...) that the Context logs in as.
+func (c *Context) userType() uint {
+	if c.cfg.LoginUserType == LoginSO {
+		return pkcs11.CKU_SO
+	}
+	if c.cfg.UserType != DefaultUserType {
+		return CryptoUser
+	}
+	return pkcs11.CKU_USER
+}
+
+// loginWithPin logs a user of type userType into a session. CKR_USER_ALREADY_LOGGED_IN is not treated as an error.
+func (c *Context) loginWithPin(session pkcs11.SessionHandle, userType uint, pin string) error {
+	c.logf("crypto11: logging in user type %d on session %d", userType, session)
+	err := c.ctx.Login(session, userType, pin)
+	if err != nil {
+		c.logf("crypto11: login on session %d: %v", session, err)
+		pErr, isP11Error := err.(pkcs11.Error)
+
+		if !isP11Error || pErr != pkcs11.CKR_USER_ALREADY_LOGGED_IN {
+			return err
 		}
 	}
 
-	return instance, nil
+	return nil
+}
+
+// userPin returns the PIN to log in with: the PIN given to Login if there is one, otherwise the configured PIN (SOPin
+// for a Security Officer login) or the result of Config.PinProvider. An empty PIN is returned if the protected
+// authentication path is in use.
+func (c *Context) userPin() (string, error) {
+	if c.useProtectedAuthPath() {
+		// The PKCS#11 wrapper passes a NULL pin to C_Login when given an empty string, which tells
+		// the token to collect the PIN itself.
+		return "", nil
+	}
+
+	c.pinMutex.Lock()
+	pin := c.pin
+	c.pinMutex.Unlock()
+	if pin != "" {
+		return pin, nil
+	}
+
+	if c.cfg.LoginUserType == LoginSO {
+		return c.cfg.SOPin, nil
+	}
+
+	if c.cfg.PinProvider != nil {
+		pin, err := c.cfg.PinProvider()
+		if err != nil {
+			return "", errors.WithMessage(err, "failed to obtain PIN")
+		}
+		return pin, nil
+	}
+	return c.cfg.Pin, nil
+}
+
+// Login logs the configured user type (see Config.LoginUserType) into the token with the given PIN. Since login state
+// is shared by all sessions with the token, this affects every operation on the Context. If
+// Config.ContextSpecificLogin is set, the PIN is also used for context-specific logins until Logout is called.
+func (c *Context) Login(pin string) error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+	return c.loginAndKeepPin(pin)
+}
+
+// loginAndKeepPin implements Login. The caller must hold reloginMutex.
+func (c *Context) loginAndKeepPin(pin string) error {
+	if err := c.loginWithPin(c.persistentSession, c.userType(), pin); err != nil {
+		return mapPKCS11Error(errors.WithMessage(err, "failed to log in"))
+	}
+
+	c.pinMutex.Lock()
+	c.pin = pin
+	c.pinMutex.Unlock()
+	return nil
+}
+
+// InitPIN initialises the user PIN of the token, as part of provisioning it. The token is logged in as the Security
+// Officer using Config.SOPin for the duration of the call, so no other operations should be in progress. Afterwards,
+// the user is logged back in with userPin, unless the Context is configured to log in as the Security Officer, who
+// is logged back in, or not to log in at all.
+func (c *Context) InitPIN(userPin string) (err error) {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	if c.cfg.SOPin == "" {
+		return errors.New("config must specify SOPin to initialise the user PIN")
+	}
+
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+
+	// Only one user type may be logged in at a time
+	err = c.ctx.Logout(c.persistentSession)
+	if err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_NOT_LOGGED_IN) {
+		return errors.WithMessage(err, "failed to log out")
+	}
+
+	// If anything fails from here on, log the previous user back in, so that the Context remains usable
+	defer func() {
+		if err == nil || !c.loginEnabled() {
+			return
+		}
+		_ = c.ctx.Logout(c.persistentSession)
+		if loginErr := c.login(c.persistentSession); loginErr != nil {
+			c.logf("crypto11: failed to log back in after InitPIN failed: %v", loginErr)
+		}
+	}()
+
+	if err = c.ctx.Login(c.persistentSession, pkcs11.CKU_SO, c.cfg.SOPin); err != nil {
+		return mapPKCS11Error(errors.WithMessage(err, "failed to log in as Security Officer"))
+	}
+
+	err = c.ctx.InitPIN(c.persistentSession, userPin)
+	if logoutErr := c.ctx.Logout(c.persistentSession); logoutErr != nil && err == nil {
+		err = errors.WithMessage(logoutErr, "failed to log out Security Officer")
+	}
+	if err != nil {
+		return errors.WithMessage(err, "failed to initialise PIN")
+	}
+
+	if !c.loginEnabled() {
+		return nil
+	}
+	if c.cfg.LoginUserType == LoginSO {
+		return c.login(c.persistentSession)
+	}
+	return c.loginAndKeepPin(userPin)
+}
+
+// SetPIN changes the PIN of the logged-in user, or of the normal user if no one is logged in, from oldPin to newPin.
+// The new PIN is used for subsequent logins by the Context, as if it had been given to Login.
+func (c *Context) SetPIN(oldPin, newPin string) error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+
+	if err := c.ctx.SetPIN(c.persistentSession, oldPin, newPin); err != nil {
+		return mapPKCS11Error(errors.WithMessage(err, "failed to set PIN"))
+	}
+
+	c.pinMutex.Lock()
+	c.pin = newPin
+	c.pinMutex.Unlock()
+	return nil
+}
+
+// withContextLogin runs f, a signing or decryption operation on session. If Config.ContextSpecificLogin is set and f
+// fails with CKR_USER_NOT_LOGGED_IN, f is retried once, with contextSpecificLogin performing a CKU_CONTEXT_SPECIFIC
+// login after the operation is initialised.
+func (c *Context) withContextLogin(session *pkcs11Session, f func() error) error {
+	err := f()
+	if !c.cfg.ContextSpecificLogin || !c.loginEnabled() || !isPKCS11Error(err, pkcs11.CKR_USER_NOT_LOGGED_IN) {
+		return err
+	}
+
+	session.contextLogin = true
+	defer func() { session.contextLogin = false }()
+	return f()
+}
+
+// contextSpecificLogin logs in with CKU_CONTEXT_SPECIFIC if the operation on session is being retried by
+// withContextLogin. It must be called immediately after C_SignInit or C_DecryptInit.
+func (c *Context) contextSpecificLogin(session *pkcs11Session) error {
+	if !session.contextLogin {
+		return nil
+	}
+
+	pin, err := c.userPin()
+	if err != nil {
+		return err
+	}
+	return c.ctx.Login(session.handle, pkcs11.CKU_CONTEXT_SPECIFIC, pin)
+}
+
+// Logout logs the user out of the token and forgets any PIN given to Login. Operations requiring a login will fail
+// until Login is called again.
+func (c *Context) Logout() error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+
+	c.pinMutex.Lock()
+	c.pin = ""
+	c.pinMutex.Unlock()
+
+	err := c.ctx.Logout(c.persistentSession)
+	if err != nil && !isPKCS11Error(err, pkcs11.CKR_USER_NOT_LOGGED_IN) {
+		return errors.WithMessage(err, "failed to log out")
+	}
+	return nil
+}
+
+// resolvePin returns the PIN from config, in order of preference: Pin, the contents of PinFile, or the value of
+// PinEnvVar. An empty PIN is returned if none of these are set.
+func resolvePin(config *Config) (string, error) {
+	switch {
+	case config.Pin != "":
+		return config.Pin, nil
+
+	case config.PinFile != "":
+		pin, err := ioutil.ReadFile(config.PinFile)
+		if os.IsNotExist(err) {
+			return "", errors.Errorf("PIN file %s does not exist", config.PinFile)
+		}
+		if err != nil {
+			return "", errors.WithMessage(err, "failed to read PIN file")
+		}
+		return strings.TrimRight(string(pin), "\r\n"), nil
+
+	case config.PinEnvVar != "":
+		pin, ok := os.LookupEnv(config.PinEnvVar)
+		if !ok {
+			return "", errors.Errorf("PIN environment variable %s is not set", config.PinEnvVar)
+		}
+		return pin, nil
+	}
+	return "", nil
+}
+
+// useProtectedAuthPath returns true if the PIN should be entered via the token's protected authentication path.
+func (c *Context) useProtectedAuthPath() bool {
+	if c.cfg.ProtectedAuthPath {
+		return true
+	}
+
+	c.slotMutex.RLock()
+	tokenHasPath := c.token.Flags&pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH != 0
+	c.slotMutex.RUnlock()
+
+	if c.cfg.LoginUserType == LoginSO {
+		return c.cfg.SOPin == "" && tokenHasPath
+	}
+	return c.cfg.Pin == "" && c.cfg.PinProvider == nil && tokenHasPath
+}
+
+// Logger receives diagnostic messages from a Context. It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// logf logs a diagnostic message to Config.Logger, if set.
+func (c *Context) logf(format string, args ...interface{}) {
+	if c.cfg.Logger != nil {
+		c.cfg.Logger.Printf(format, args...)
+	}
 }
 
 func min(a, b int) int {
@@ -491,14 +1827,288 @@ func loadConfigFromFile(configLocation string) (*Config, error) {
 	return config, errors.WithMessage(err, "could decode config file:")
 }
 
+// SlotDescription returns the description of the slot containing the token, as reported by C_GetSlotInfo
+// when the Context was configured.
+func (c *Context) SlotDescription() string {
+	c.slotMutex.RLock()
+	defer c.slotMutex.RUnlock()
+	return c.slotInfo.SlotDescription
+}
+
+// SlotFlags returns the CKF_... flags of the slot containing the token, as reported by C_GetSlotInfo
+// when the Context was configured.
+func (c *Context) SlotFlags() uint {
+	c.slotMutex.RLock()
+	defer c.slotMutex.RUnlock()
+	return c.slotInfo.Flags
+}
+
+// SlotID returns the ID of the slot containing the token. It remains available after the Context is closed.
+func (c *Context) SlotID() uint {
+	c.slotMutex.RLock()
+	defer c.slotMutex.RUnlock()
+	return c.slot
+}
+
+// TokenInfo returns information about the token, as reported by C_GetTokenInfo at the time of the call.
+func (c *Context) TokenInfo() (pkcs11.TokenInfo, error) {
+	if c.closed.Get() {
+		return pkcs11.TokenInfo{}, errClosed
+	}
+
+	info, err := c.ctx.GetTokenInfo(c.SlotID())
+	if err != nil {
+		return pkcs11.TokenInfo{}, errors.WithMessage(err, "failed to get token info")
+	}
+	return info, nil
+}
+
+// SupportedMechanisms returns the mechanisms supported by the token, as reported by C_GetMechanismList. The list is
+// read from the token once and cached until the Context is closed.
+func (c *Context) SupportedMechanisms() ([]*pkcs11.Mechanism, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	c.mechanismsMutex.Lock()
+	defer c.mechanismsMutex.Unlock()
+
+	if c.mechanisms == nil {
+		mechanisms, err := c.ctx.GetMechanismList(c.SlotID())
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to list mechanisms")
+		}
+		c.mechanisms = mechanisms
+	}
+
+	// Copy the list so callers cannot modify the cache
+	result := make([]*pkcs11.Mechanism, len(c.mechanisms))
+	for i, m := range c.mechanisms {
+		result[i] = pkcs11.NewMechanism(m.Mechanism, nil)
+	}
+	return result, nil
+}
+
+// MechanismInfo returns information about a mechanism (CKM_...) supported by the token, such as the range of key
+// sizes it allows, as reported by C_GetMechanismInfo.
+func (c *Context) MechanismInfo(mech uint) (pkcs11.MechanismInfo, error) {
+	if c.closed.Get() {
+		return pkcs11.MechanismInfo{}, errClosed
+	}
+
+	info, err := c.ctx.GetMechanismInfo(c.SlotID(), []*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)})
+	if err != nil {
+		return pkcs11.MechanismInfo{}, errors.WithMessagef(err, "failed to get mechanism info for %s",
+			mechanismString(mech))
+	}
+	return info, nil
+}
+
+// mechanismSupported returns true if the token lists mech among its supported mechanisms.
+func (c *Context) mechanismSupported(mech uint) (bool, error) {
+	mechanisms, err := c.SupportedMechanisms()
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range mechanisms {
+		if m.Mechanism == mech {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SlotInfo returns information about the slot containing the token, as reported by C_GetSlotInfo at the time of the
+// call.
+func (c *Context) SlotInfo() (pkcs11.SlotInfo, error) {
+	if c.closed.Get() {
+		return pkcs11.SlotInfo{}, errClosed
+	}
+
+	info, err := c.ctx.GetSlotInfo(c.SlotID())
+	if err != nil {
+		return pkcs11.SlotInfo{}, errors.WithMessage(err, "failed to get slot info")
+	}
+	return info, nil
+}
+
+// LoginState is a PKCS#11 session state (CK_STATE), as returned by C_GetSessionInfo.
+type LoginState uint
+
+// Session states defined by PKCS#11.
+const (
+	StateROPublicSession LoginState = 0 // CKS_RO_PUBLIC_SESSION
+	StateROUserFunctions LoginState = 1 // CKS_RO_USER_FUNCTIONS
+	StateRWPublicSession LoginState = 2 // CKS_RW_PUBLIC_SESSION
+	StateRWUserFunctions LoginState = 3 // CKS_RW_USER_FUNCTIONS
+	StateRWSOFunctions   LoginState = 4 // CKS_RW_SO_FUNCTIONS
+)
+
+// String returns the PKCS#11 name of the state.
+func (s LoginState) String() string {
+	switch s {
+	case StateROPublicSession:
+		return "CKS_RO_PUBLIC_SESSION"
+	case StateROUserFunctions:
+		return "CKS_RO_USER_FUNCTIONS"
+	case StateRWPublicSession:
+		return "CKS_RW_PUBLIC_SESSION"
+	case StateRWUserFunctions:
+		return "CKS_RW_USER_FUNCTIONS"
+	case StateRWSOFunctions:
+		return "CKS_RW_SO_FUNCTIONS"
+	default:
+		return fmt.Sprintf("unknown state %#x", uint(s))
+	}
+}
+
+// IsUser returns true if the state is one in which the normal user is logged in.
+func (s LoginState) IsUser() bool {
+	return s == StateROUserFunctions || s == StateRWUserFunctions
+}
+
+// LoginState returns the state of the Context's long-term session, as reported by C_GetSessionInfo. Since login
+// state is shared by all sessions with the token, this reveals whether the Context is logged in, and as whom.
+func (c *Context) LoginState() (LoginState, error) {
+	if c.closed.Get() {
+		return 0, errClosed
+	}
+
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+
+	info, err := c.ctx.GetSessionInfo(c.persistentSession)
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to get session info")
+	}
+	return LoginState(info.State), nil
+}
+
+// Ping checks that the token is reachable, by calling C_GetSessionInfo on a session borrowed from the pool. It is
+// cheap enough to call frequently, e.g. from a liveness probe, and returns the session to the pool afterwards. An
+// error is returned if no session can be obtained or the token does not respond successfully.
+func (c *Context) Ping() error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	return c.withSession(func(session *pkcs11Session) error {
+		if _, err := session.ctx.GetSessionInfo(session.handle); err != nil {
+			return errors.WithMessage(err, "failed to get session info")
+		}
+		return nil
+	})
+}
+
+// Reinitialize recovers the Context after the PKCS#11 library has been finalized behind its back, for example by
+// other code in the process calling C_Finalize. The library is finalized (if still initialized) and initialized
+// again, the token is found again, and a new long-term session is opened and logged in. Sessions in the pool from
+// before the call are discarded as they are next used. Keys and other objects obtained from the Context continue to
+// work, provided their object handles survive reinitialization of the library; this is normally true of token
+// objects, but session objects are lost.
+//
+// Reinitialize affects every Context using the same library. Operations running while Reinitialize is called may
+// fail. A library supplied to ConfigureWithContext cannot be reinitialized.
+func (c *Context) Reinitialize() (err error) {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	defer func() {
+		err = mapPKCS11Error(err)
+	}()
+
+	if c.ctx.external {
+		return errors.New("cannot reinitialize a PKCS#11 library supplied to ConfigureWithContext")
+	}
+
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+
+	if err := c.reinitializeLibrary(); err != nil {
+		return err
+	}
+
+	// Sessions opened before this point are now stale.
+	c.generation.Add(1)
+
+	slots, err := c.ctx.GetSlotList(true)
+	if err != nil {
+		return errors.WithMessage(err, "failed to list PKCS#11 slots")
+	}
+
+	slot, token, err := c.findToken(slots, c.cfg)
+	if err != nil {
+		return err
+	}
+
+	slotInfo, err := c.ctx.GetSlotInfo(slot)
+	if err != nil {
+		return errors.WithMessage(err, "failed to get PKCS#11 slot info")
+	}
+
+	c.slotMutex.Lock()
+	c.slot, c.token, c.slotInfo = slot, token, &slotInfo
+	c.slotMutex.Unlock()
+
+	c.mechanismsMutex.Lock()
+	c.mechanisms = nil
+	c.mechanismsMutex.Unlock()
+
+	c.persistentSession, err = c.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to create long term session")
+	}
+
+	if c.loginEnabled() {
+		if err = c.login(c.persistentSession); err != nil {
+			return errors.WithMessagef(err, "failed to log into long term session")
+		}
+	}
+
+	c.logf("crypto11: reinitialized PKCS#11 library %s", c.ctx.libraryPath)
+
+	return c.prefillSessions(c.cfg.MinSessions)
+}
+
+// reinitializeLibrary finalizes and initializes the PKCS#11 library, tolerating it being already finalized.
+func (c *Context) reinitializeLibrary() error {
+	refCountMutex.Lock()
+	defer refCountMutex.Unlock()
+
+	if err := c.ctx.Finalize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED) {
+		return errors.WithMessage(err, "failed to finalize PKCS#11 library")
+	}
+
+	if err := c.ctx.Initialize(); err != nil && !isPKCS11Error(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return errors.WithMessage(err, "failed to initialize PKCS#11 library")
+	}
+	return nil
+}
+
 // Close releases resources used by the Context and unloads the PKCS #11 library if there are no other
-// Contexts using it. Close blocks until existing operations have finished. A closed Context cannot be reused.
+// Contexts using it. A library supplied to ConfigureWithContext is never unloaded. Close blocks until existing
+// operations have finished. A closed Context cannot be reused.
 func (c *Context) Close() error {
 	c.closed.Set(true)
 
+	if c.singleSession != nil {
+		c.pool.Put(c.singleSession)
+		c.singleSession = nil
+	}
+
 	// Block until all resources returned to pool
 	c.pool.Close()
 
+	c.mechanismsMutex.Lock()
+	c.mechanisms = nil
+	c.mechanismsMutex.Unlock()
+
+	// Wait for any operation using the long-term session to finish.
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+
 	// Close our long-term session. We ignore any returned error,
 	// since we plan to kill our collection to the library anyway.
 	_ = c.ctx.CloseSession(c.persistentSession)
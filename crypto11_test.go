@@ -22,11 +22,17 @@
 package crypto11
 
 import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -166,6 +172,14 @@ func TestAmbiguousTokenConfig(t *testing.T) {
 			config: &Config{SlotNumber: &slotNum, TokenLabel: "label"},
 			err:    "config must specify exactly one way to select a token: slot number, token label given",
 		},
+		{
+			config: &Config{TokenLabel: "label", SlotDescription: "description"},
+			err:    "config must specify exactly one way to select a token: token label, slot description given",
+		},
+		{
+			config: &Config{TokenSerial: "serial", TokenManufacturer: "manufacturer"},
+			err:    "config must specify exactly one way to select a token: token serial number, token manufacturer given",
+		},
 		{
 			config: &Config{},
 			err:    "config must specify exactly one way to select a token: none given",
@@ -210,6 +224,40 @@ func TestSelectBySlot(t *testing.T) {
 	assert.Equal(t, slotNumber, slotNumber2)
 }
 
+func TestSelectBySlotDescription(t *testing.T) {
+	config, err := loadConfigFromFile("config")
+	require.NoError(t, err)
+
+	ctx, err := Configure(config)
+	require.NoError(t, err)
+
+	slotNumber := int(ctx.slot)
+	description := ctx.slotInfo.SlotDescription
+	require.NoError(t, ctx.Close())
+
+	// Padding with spaces, as in the fixed-width PKCS#11 field, must not matter
+	ctx, err = Configure(&Config{
+		SlotDescription: description + "   ",
+		Pin:             config.Pin,
+		Path:            config.Path,
+	})
+	require.NoError(t, err)
+
+	slotNumber2 := int(ctx.slot)
+	require.NoError(t, ctx.Close())
+
+	assert.Equal(t, slotNumber, slotNumber2)
+}
+
+func TestMatchesPaddedField(t *testing.T) {
+	assert.True(t, matchesPaddedField("SoftHSM project", "SoftHSM project"))
+	assert.True(t, matchesPaddedField("SoftHSM project   ", "SoftHSM project"))
+	assert.True(t, matchesPaddedField("SoftHSM project", "SoftHSM project  "))
+	assert.False(t, matchesPaddedField("SoftHSM project", "SoftHSM"))
+	assert.False(t, matchesPaddedField("", ""))
+	assert.False(t, matchesPaddedField("   ", "  "))
+}
+
 func TestSelectByNonExistingSlot(t *testing.T) {
 	config, err := loadConfigFromFile("config")
 	require.NoError(t, err)
@@ -223,7 +271,21 @@ func TestSelectByNonExistingSlot(t *testing.T) {
 
 	// Look up slot number for label
 	_, err = Configure(config)
-	require.Equal(t, errTokenNotFound, err)
+	var notFound *TokenNotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.NotEmpty(t, notFound.Available)
+}
+
+func TestTokenNotFoundError(t *testing.T) {
+	err := &TokenNotFoundError{}
+	assert.Equal(t, "could not find PKCS#11 token (no tokens are present)", err.Error())
+
+	err.Available = []TokenDescriptor{
+		{Slot: 1, Label: "first", SerialNumber: "0001"},
+		{Slot: 7, Label: "second", SerialNumber: "0002"},
+	}
+	assert.Equal(t, `could not find PKCS#11 token (available tokens: slot 1: label "first", serial "0001"; `+
+		`slot 7: label "second", serial "0002")`, err.Error())
 }
 
 func TestAccessSameLibraryTwice(t *testing.T) {
@@ -259,6 +321,152 @@ func TestAccessSameLibraryTwice(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestConfigureWithContext(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	p11Ctx := pkcs11.New(cfg.Path)
+	require.NotNil(t, p11Ctx)
+	defer p11Ctx.Destroy()
+
+	require.NoError(t, p11Ctx.Initialize())
+	defer func() { _ = p11Ctx.Finalize() }()
+
+	ctx, err := ConfigureWithContext(cfg, p11Ctx)
+	require.NoError(t, err)
+
+	_, err = ctx.FindKey(randomBytes(), nil)
+	require.NoError(t, err)
+	require.NoError(t, ctx.Close())
+
+	// The library must not have been finalized by Close
+	_, err = p11Ctx.GetSlotList(true)
+	require.NoError(t, err)
+
+	_, err = ConfigureWithContext(cfg, nil)
+	require.Error(t, err)
+}
+
+func TestLibraryKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypto11")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	library := filepath.Join(dir, "libtest.so")
+	require.NoError(t, ioutil.WriteFile(library, nil, 0600))
+	link := filepath.Join(dir, "libtest-link.so")
+	require.NoError(t, os.Symlink(library, link))
+
+	key := libraryKey(library)
+	assert.Equal(t, key, libraryKey(link))
+	assert.Equal(t, key, libraryKey(filepath.Join(dir, ".", "sub", "..", "libtest.so")))
+
+	// Bare names are found by the dynamic loader, so are not resolved
+	assert.Equal(t, "libtest.so", libraryKey("libtest.so"))
+}
+
+func TestAccessSameLibraryViaSymlink(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+	if filepath.Base(cfg.Path) == cfg.Path {
+		t.Skip("library is not given by path")
+	}
+
+	dir, err := ioutil.TempDir("", "crypto11")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	link := filepath.Join(dir, filepath.Base(cfg.Path))
+	require.NoError(t, os.Symlink(cfg.Path, link))
+
+	ctx1, err := Configure(cfg)
+	require.NoError(t, err)
+
+	cfg2, err := getConfig("config")
+	require.NoError(t, err)
+	cfg2.Path = link
+	ctx2, err := Configure(cfg2)
+	require.NoError(t, err)
+
+	refCountMutex.Lock()
+	count := refCount[libraryKey(link)]
+	refCountMutex.Unlock()
+	require.Equal(t, 2, count)
+
+	// Closing the first context must not finalize the library under the second
+	require.NoError(t, ctx1.Close())
+	_, err = ctx2.FindKey(randomBytes(), nil)
+	require.NoError(t, err)
+	require.NoError(t, ctx2.Close())
+}
+
+func TestKeepLibraryLoaded(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+	cfg.KeepLibraryLoaded = true
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	require.NoError(t, ctx.Close())
+
+	refCountMutex.Lock()
+	_, kept := keptLibraries[libraryKey(cfg.Path)]
+	refCountMutex.Unlock()
+	require.True(t, kept)
+
+	// The kept library is reused without initializing it again
+	ctx, err = Configure(cfg)
+	require.NoError(t, err)
+	_, err = ctx.FindKey(randomBytes(), nil)
+	require.NoError(t, err)
+	require.NoError(t, ctx.Close())
+
+	require.NoError(t, Shutdown())
+
+	refCountMutex.Lock()
+	kept = len(keptLibraries) != 0
+	refCountMutex.Unlock()
+	require.False(t, kept)
+
+	// The library can be initialized again after Shutdown
+	cfg.KeepLibraryLoaded = false
+	ctx, err = Configure(cfg)
+	require.NoError(t, err)
+	require.NoError(t, ctx.Close())
+}
+
+func TestShutdownWithoutKeptLibraries(t *testing.T) {
+	require.NoError(t, Shutdown())
+}
+
+func TestExternalPKCS11ContextClose(t *testing.T) {
+	// An external context has no reference count, so closing it must not touch the reference counts
+	ctx := &PKCS11Context{libraryPath: "/does/not/exist", external: true}
+	require.NoError(t, ctx.Close())
+}
+
+func TestReadOnlySessions(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+	cfg.ReadOnlySessions = true
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	err = ctx.withSession(func(session *pkcs11Session) error {
+		info, err := session.ctx.GetSessionInfo(session.handle)
+		require.NoError(t, err)
+		assert.Zero(t, info.Flags&pkcs11.CKF_RW_SESSION)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Writes still work, on a one-off read-write session
+	key, err := ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+	require.NoError(t, err)
+	require.NoError(t, key.Delete())
+}
+
 func TestNoLogin(t *testing.T) {
 	// To test that no login is respected, we attempt to perform an operation on our
 	// SoftHSM HSM without logging in and check for the error.
@@ -297,3 +505,535 @@ func randomBytes() []byte {
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
+
+func TestSlotInfo(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		slotInfo, err := ctx.ctx.GetSlotInfo(ctx.slot)
+		require.NoError(t, err)
+
+		assert.Equal(t, slotInfo.SlotDescription, ctx.SlotDescription())
+		assert.Equal(t, slotInfo.Flags, ctx.SlotFlags())
+		assert.NotZero(t, ctx.SlotFlags()&pkcs11.CKF_TOKEN_PRESENT)
+	})
+}
+
+func TestTokenAndSlotInfo(t *testing.T) {
+	ctx, err := ConfigureFromFile("config")
+	require.NoError(t, err)
+
+	tokenInfo, err := ctx.TokenInfo()
+	require.NoError(t, err)
+	assert.Equal(t, ctx.token.Label, tokenInfo.Label)
+	assert.Equal(t, ctx.token.SerialNumber, tokenInfo.SerialNumber)
+
+	slotInfo, err := ctx.SlotInfo()
+	require.NoError(t, err)
+	assert.Equal(t, ctx.SlotDescription(), slotInfo.SlotDescription)
+
+	slot := ctx.SlotID()
+	require.NoError(t, ctx.Close())
+
+	assert.Equal(t, slot, ctx.SlotID())
+
+	_, err = ctx.TokenInfo()
+	assert.Equal(t, errClosed, err)
+	_, err = ctx.SlotInfo()
+	assert.Equal(t, errClosed, err)
+}
+
+func TestUseProtectedAuthPath(t *testing.T) {
+	tests := []struct {
+		config     *Config
+		tokenFlags uint
+		expected   bool
+	}{
+		{config: &Config{Pin: "password"}, tokenFlags: 0, expected: false},
+		{config: &Config{Pin: "password"}, tokenFlags: pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH, expected: false},
+		{config: &Config{}, tokenFlags: 0, expected: false},
+		{config: &Config{}, tokenFlags: pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH, expected: true},
+		{config: &Config{Pin: "password", ProtectedAuthPath: true}, tokenFlags: 0, expected: true},
+		{config: &Config{PinProvider: func() (string, error) { return "password", nil }},
+			tokenFlags: pkcs11.CKF_PROTECTED_AUTHENTICATION_PATH, expected: false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			ctx := &Context{cfg: test.config, token: &pkcs11.TokenInfo{Flags: test.tokenFlags}}
+			assert.Equal(t, test.expected, ctx.useProtectedAuthPath())
+		})
+	}
+}
+
+func TestLoginState(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		state, err := ctx.LoginState()
+		require.NoError(t, err)
+		assert.True(t, state.IsUser(), "unexpected state %s", state)
+	})
+}
+
+func TestLoginStateString(t *testing.T) {
+	assert.Equal(t, "CKS_RW_USER_FUNCTIONS", StateRWUserFunctions.String())
+	assert.Equal(t, "CKS_RO_PUBLIC_SESSION", StateROPublicSession.String())
+	assert.Equal(t, "unknown state 0x9", LoginState(9).String())
+	assert.False(t, StateRWSOFunctions.IsUser())
+}
+
+func TestPinProvider(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	pin := cfg.Pin
+	calls := 0
+	cfg.Pin = ""
+	cfg.PinProvider = func() (string, error) {
+		calls++
+		return pin, nil
+	}
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	assert.Equal(t, 1, calls)
+
+	state, err := ctx.LoginState()
+	require.NoError(t, err)
+	assert.True(t, state.IsUser())
+}
+
+func TestPinAndPinProvider(t *testing.T) {
+	_, err := Configure(&Config{
+		TokenLabel:  "label",
+		Pin:         "password",
+		PinProvider: func() (string, error) { return "password", nil },
+	})
+	require.Error(t, err)
+}
+
+func TestMinSessions(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	cfg.MaxSessions = 4
+	cfg.MinSessions = 3
+	cfg.IdleTimeout = time.Minute
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	assert.Equal(t, int64(3), ctx.pool.Active())
+
+	cfg.MinSessions = 4
+	_, err = Configure(cfg)
+	require.Error(t, err)
+}
+
+func TestLoginLogout(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	withContext(t, func(ctx *Context) {
+		require.NoError(t, ctx.Logout())
+
+		state, err := ctx.LoginState()
+		require.NoError(t, err)
+		assert.False(t, state.IsUser())
+
+		_, err = ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+		require.Error(t, err)
+
+		require.Error(t, ctx.Login("not the pin"))
+		require.NoError(t, ctx.Login(cfg.Pin))
+
+		state, err = ctx.LoginState()
+		require.NoError(t, err)
+		assert.True(t, state.IsUser())
+	})
+}
+
+func TestWithContextLogin(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled_%v", enabled), func(t *testing.T) {
+			ctx := &Context{cfg: &Config{ContextSpecificLogin: enabled}}
+			session := &pkcs11Session{}
+
+			var calls []bool
+			err := ctx.withContextLogin(session, func() error {
+				calls = append(calls, session.contextLogin)
+				if !session.contextLogin {
+					return &OperationError{Operation: "sign", Err: pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN)}
+				}
+				return nil
+			})
+
+			if enabled {
+				require.NoError(t, err)
+				assert.Equal(t, []bool{false, true}, calls)
+				assert.False(t, session.contextLogin)
+			} else {
+				require.Error(t, err)
+				assert.Equal(t, []bool{false}, calls)
+			}
+		})
+	}
+}
+
+func TestInitAndSetPIN(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	require.NoError(t, ctx.InitPIN(cfg.Pin))
+
+	state, err := ctx.LoginState()
+	require.NoError(t, err)
+	assert.True(t, state.IsUser())
+
+	newPin := "new" + cfg.Pin
+	require.NoError(t, ctx.SetPIN(cfg.Pin, newPin))
+	require.NoError(t, ctx.SetPIN(newPin, cfg.Pin))
+	require.Error(t, ctx.SetPIN(newPin, cfg.Pin))
+}
+
+func TestInitPINFailureRestoresLogin(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+	cfg.SOPin = "wrong" + cfg.SOPin
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	require.Error(t, ctx.InitPIN(cfg.Pin))
+
+	state, err := ctx.LoginState()
+	require.NoError(t, err)
+	assert.True(t, state.IsUser())
+}
+
+func TestInitPINRequiresSOPin(t *testing.T) {
+	ctx := &Context{cfg: &Config{}}
+	require.Error(t, ctx.InitPIN("password"))
+}
+
+func TestResolvePin(t *testing.T) {
+	file, err := ioutil.TempFile("", "crypto11-pin")
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	_, err = file.WriteString("filepin\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	envVar := fmt.Sprintf("CRYPTO11_TEST_PIN_%d", rand.Int())
+	require.NoError(t, os.Setenv(envVar, "envpin"))
+	defer func() { _ = os.Unsetenv(envVar) }()
+
+	tests := []struct {
+		config   *Config
+		expected string
+		err      bool
+	}{
+		{config: &Config{}, expected: ""},
+		{config: &Config{Pin: "pin", PinFile: file.Name(), PinEnvVar: envVar}, expected: "pin"},
+		{config: &Config{PinFile: file.Name(), PinEnvVar: envVar}, expected: "filepin"},
+		{config: &Config{PinEnvVar: envVar}, expected: "envpin"},
+		{config: &Config{PinFile: file.Name() + "-missing"}, err: true},
+		{config: &Config{PinEnvVar: envVar + "_UNSET"}, err: true},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			pin, err := resolvePin(test.config)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, pin)
+		})
+	}
+}
+
+func TestObjectAttributes(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		attribute, err := key.Attribute(pkcs11.CKA_SENSITIVE)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1}, attribute.Value)
+
+		attributes, err := key.Attributes([]uint{pkcs11.CKA_EXTRACTABLE, pkcs11.CKA_KEY_TYPE})
+		require.NoError(t, err)
+		require.Len(t, attributes, 2)
+		assert.Equal(t, uint(pkcs11.CKA_EXTRACTABLE), attributes[0].Type)
+		assert.Equal(t, []byte{0}, attributes[0].Value)
+		assert.Equal(t, uint(pkcs11.CKK_AES), bytesToUlong(attributes[1].Value))
+
+		// The value of a sensitive key cannot be read
+		_, err = key.Attributes([]uint{pkcs11.CKA_KEY_TYPE, pkcs11.CKA_VALUE})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "CkaValue")
+	})
+}
+
+func TestSupportedMechanisms(t *testing.T) {
+	ctx, err := ConfigureFromFile("config")
+	require.NoError(t, err)
+
+	mechanisms, err := ctx.SupportedMechanisms()
+	require.NoError(t, err)
+
+	supported, err := ctx.mechanismSupported(pkcs11.CKM_RSA_PKCS)
+	require.NoError(t, err)
+	assert.True(t, supported)
+	assert.NotEmpty(t, mechanisms)
+
+	info, err := ctx.MechanismInfo(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN)
+	require.NoError(t, err)
+	assert.True(t, info.MaxKeySize >= 2048)
+
+	require.NoError(t, ctx.Close())
+	assert.Nil(t, ctx.mechanisms)
+
+	_, err = ctx.SupportedMechanisms()
+	assert.Equal(t, errClosed, err)
+	_, err = ctx.MechanismInfo(pkcs11.CKM_RSA_PKCS)
+	assert.Equal(t, errClosed, err)
+}
+
+func TestSupportedMechanismsCached(t *testing.T) {
+	// With no PKCS#11 library loaded, only the cache can answer
+	ctx := &Context{mechanisms: []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_SHA256, nil)}}
+
+	mechanisms, err := ctx.SupportedMechanisms()
+	require.NoError(t, err)
+	require.Len(t, mechanisms, 1)
+	mechanisms[0].Mechanism = pkcs11.CKM_MD5
+
+	supported, err := ctx.mechanismSupported(pkcs11.CKM_SHA256)
+	require.NoError(t, err)
+	assert.True(t, supported)
+
+	supported, err = ctx.mechanismSupported(pkcs11.CKM_MD5)
+	require.NoError(t, err)
+	assert.False(t, supported)
+}
+
+func TestConfigurePaths(t *testing.T) {
+	config, err := loadConfigFromFile("config")
+	require.NoError(t, err)
+
+	config.Paths = []string{"/does/not/exist.so", config.Path}
+	config.Path = ""
+
+	ctx, err := Configure(config)
+	require.NoError(t, err)
+	require.NoError(t, ctx.Close())
+}
+
+func TestConfigurePathsReportsFailures(t *testing.T) {
+	label := "test"
+	_, err := Configure(&Config{TokenLabel: label, Paths: []string{"/does/not/exist.so", "/nor/this.so"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/does/not/exist.so")
+	assert.Contains(t, err.Error(), "/nor/this.so")
+
+	_, err = Configure(&Config{TokenLabel: label, Path: "/does/not/exist.so", Paths: []string{"/nor/this.so"}})
+	require.Error(t, err)
+
+	_, err = Configure(&Config{TokenLabel: label})
+	require.Error(t, err)
+}
+
+func TestReinitialize(t *testing.T) {
+	ctx, err := ConfigureFromFile("config")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+	require.NoError(t, err)
+	defer func() { _ = key.Delete() }()
+
+	// Simulate another user of the library finalizing it.
+	require.NoError(t, ctx.ctx.Finalize())
+
+	require.NoError(t, ctx.Reinitialize())
+
+	digest := sha256.Sum256([]byte("reinitialize"))
+	_, err = key.Sign(nil, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+}
+
+func TestIncorrectPin(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+	cfg.Pin += "-incorrect"
+
+	_, err = Configure(cfg)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrPinIncorrect))
+
+	var p11Err pkcs11.Error
+	require.True(t, errors.As(err, &p11Err))
+	assert.Equal(t, pkcs11.Error(pkcs11.CKR_PIN_INCORRECT), p11Err)
+}
+
+func TestPing(t *testing.T) {
+	ctx, err := ConfigureFromFile("config")
+	require.NoError(t, err)
+
+	require.NoError(t, ctx.Ping())
+	assert.Equal(t, int64(0), ctx.PoolStats().InUse)
+
+	require.NoError(t, ctx.Close())
+	assert.Equal(t, errClosed, ctx.Ping())
+}
+
+func TestConfigRedaction(t *testing.T) {
+	config := &Config{Path: "/usr/lib/libtoken.so", TokenLabel: "token", Pin: "secret-pin", SOPin: "secret-so-pin"}
+
+	marshalled, err := json.Marshal(config)
+	require.NoError(t, err)
+
+	for _, logged := range []string{config.String(), fmt.Sprintf("%v", config), string(marshalled)} {
+		assert.NotContains(t, logged, "secret")
+		assert.Contains(t, logged, `"Pin":"***"`)
+		assert.Contains(t, logged, `"SOPin":"***"`)
+		assert.Contains(t, logged, `"TokenLabel":"token"`)
+	}
+	assert.Equal(t, "secret-pin", config.Pin)
+
+	data, err := config.MarshalJSONWithSecrets()
+	require.NoError(t, err)
+	var decoded Config
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "secret-pin", decoded.Pin)
+	assert.Equal(t, "secret-so-pin", decoded.SOPin)
+	assert.Equal(t, config.Path, decoded.Path)
+
+	// Empty PINs are left empty, rather than suggesting a PIN is set.
+	assert.Contains(t, Config{}.String(), `"Pin":""`)
+}
+
+func TestLoginUserType(t *testing.T) {
+	var config Config
+	require.NoError(t, json.Unmarshal([]byte(`{"LoginUserType":"so"}`), &config))
+	assert.Equal(t, LoginSO, config.LoginUserType)
+	require.Error(t, json.Unmarshal([]byte(`{"LoginUserType":"admin"}`), &config))
+
+	data, err := json.Marshal(Config{LoginUserType: LoginNone})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"LoginUserType":"none"`)
+
+	ctx := &Context{cfg: &Config{UserType: DefaultUserType}, token: &pkcs11.TokenInfo{}}
+	assert.True(t, ctx.loginEnabled())
+	assert.Equal(t, uint(pkcs11.CKU_USER), ctx.userType())
+
+	ctx.cfg.UserType = CryptoUser
+	assert.Equal(t, uint(CryptoUser), ctx.userType())
+
+	ctx.cfg.LoginUserType = LoginSO
+	ctx.cfg.SOPin = "so-pin"
+	assert.Equal(t, uint(pkcs11.CKU_SO), ctx.userType())
+	pin, err := ctx.userPin()
+	require.NoError(t, err)
+	assert.Equal(t, "so-pin", pin)
+
+	ctx.cfg.LoginUserType = LoginNone
+	assert.False(t, ctx.loginEnabled())
+
+	_, err = Configure(&Config{TokenLabel: "test", LoginUserType: LoginSO})
+	require.Error(t, err)
+	_, err = Configure(&Config{TokenLabel: "test", LoginUserType: LoginSO, SOPin: "1234", LoginNotSupported: true})
+	require.Error(t, err)
+}
+
+func TestLoginNone(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+	cfg.LoginUserType = LoginNone
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	state, err := ctx.LoginState()
+	require.NoError(t, err)
+	assert.False(t, state.IsUser(), "unexpected state %s", state)
+}
+
+func TestConfigValidate(t *testing.T) {
+	library, err := ioutil.TempFile("", "crypto11-library")
+	require.NoError(t, err)
+	require.NoError(t, library.Close())
+	defer func() { _ = os.Remove(library.Name()) }()
+
+	valid := &Config{Path: library.Name(), TokenLabel: "token", Pin: "pin"}
+	require.NoError(t, valid.Validate())
+	require.Equal(t, 0, valid.MaxSessions, "Validate must not apply defaults")
+
+	require.NoError(t, (&Config{Path: "libsofthsm2.so", TokenLabel: "token"}).Validate())
+	require.NoError(t, (&Config{Paths: []string{"/does/not/exist.so", library.Name()}, TokenLabel: "token"}).Validate())
+
+	for _, config := range []*Config{
+		{Path: library.Name()},
+		{Path: library.Name(), TokenLabel: "token", TokenSerial: "serial"},
+		{TokenLabel: "token"},
+		{Path: "/does/not/exist.so", TokenLabel: "token"},
+		{Paths: []string{"/does/not/exist.so", "/nor/this.so"}, TokenLabel: "token"},
+		{Path: library.Name(), TokenLabel: "token", PinFile: library.Name() + "-missing"},
+		{Path: library.Name(), TokenLabel: "token", MaxSessions: 1},
+		{Path: library.Name(), TokenLabel: "token", LoginUserType: LoginSO},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+}
+
+func TestListTokens(t *testing.T) {
+	config, err := loadConfigFromFile("config")
+	require.NoError(t, err)
+
+	tokens, err := ListTokens(config.Path)
+	require.NoError(t, err)
+	require.NotEmpty(t, tokens)
+
+	ctx, err := Configure(config)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	// The configured token can be selected again by the serial number listed for its slot.
+	for _, token := range tokens {
+		if token.Slot != ctx.SlotID() {
+			continue
+		}
+		require.NotEmpty(t, token.SerialNumber)
+
+		bySerial, err := Configure(&Config{Path: config.Path, TokenSerial: token.SerialNumber, Pin: config.Pin})
+		require.NoError(t, err)
+		assert.Equal(t, token.Slot, bySerial.SlotID())
+		require.NoError(t, bySerial.Close())
+	}
+
+	_, err = ListTokens("/does/not/exist.so")
+	require.Error(t, err)
+}
+
+func TestConnectTimeout(t *testing.T) {
+	config, err := loadConfigFromFile("config")
+	require.NoError(t, err)
+
+	config.ConnectTimeout = time.Minute
+	ctx, err := Configure(config)
+	require.NoError(t, err)
+	require.NoError(t, ctx.Close())
+
+	config.ConnectTimeout = -time.Second
+	_, err = Configure(config)
+	require.Error(t, err)
+}
@@ -0,0 +1,152 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto/cipher"
+	"errors"
+	"runtime"
+
+	"github.com/miekg/pkcs11"
+)
+
+// StreamCloser represents a cipher running in a stream mode (e.g. CTR).
+//
+// StreamCloser embeds cipher.Stream, and can be used as such.
+// However, in this case
+// (or if the Close() method is not explicitly called for any other reason),
+// resources allocated to it may remain live indefinitely.
+type StreamCloser interface {
+	cipher.Stream
+
+	// Close() releases resources associated with the stream.
+	Close()
+}
+
+// ctrCounterBits is the size of the counter within the counter block. As in cipher.NewCTR, the whole block is
+// incremented as a big-endian integer.
+const ctrCounterBits = 128
+
+// NewCTR returns a cipher.Stream which encrypts or decrypts in counter mode (CKM_AES_CTR), using the given key.
+// The iv is the initial counter block, and its length must be the same as the key's block size. The output is
+// the same as that of cipher.NewCTR.
+//
+// Each call to XORKeyStream is a round trip to the token, so the stream is much slower than software CTR mode
+// unless data is supplied in large pieces. The token must return output for all the data it is given, as stream
+// ciphers should.
+//
+// The new Stream acquires persistent resources which are released (eventually) by a finalizer.
+// If this is a problem for your application then use NewCTRCloser instead.
+func (key *SecretKey) NewCTR(iv []byte) (cipher.Stream, error) {
+	return key.newStreamCloser(iv, true)
+}
+
+// NewCTRCloser returns a StreamCloser which encrypts or decrypts in counter mode, as NewCTR does.
+//
+// Use of NewCTRCloser rather than NewCTR represents a commitment to call the Close() method
+// of the returned StreamCloser.
+func (key *SecretKey) NewCTRCloser(iv []byte) (StreamCloser, error) {
+	return key.newStreamCloser(iv, false)
+}
+
+// streamCloser is a concrete implementation of StreamCloser supporting CTR.
+type streamCloser struct {
+	// PKCS#11 session to use
+	session *pkcs11Session
+
+	// Cleanup function
+	cleanup func()
+}
+
+// newStreamCloser creates a new streamCloser in CTR mode.
+func (key *SecretKey) newStreamCloser(iv []byte, setFinalizer bool) (*streamCloser, error) {
+	if key.Cipher != CipherAES {
+		return nil, errors.New("CTR mode is only supported for AES keys")
+	}
+	if len(iv) != key.Cipher.BlockSize {
+		return nil, errors.New("IV length must equal block size")
+	}
+
+	session, err := key.context.getSession()
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &streamCloser{
+		session: session,
+		cleanup: func() {
+			key.context.pool.Put(session)
+		},
+	}
+
+	// CK_AES_CTR_PARAMS is the counter size in bits followed by the initial counter block
+	params := concat(ulongToBytes(ctrCounterBits), iv)
+	mechDescription := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_CTR, params)}
+
+	// Counter mode is symmetric, so encryption serves for both directions
+	if err = session.ctx.EncryptInit(session.handle, mechDescription, key.handle); err != nil {
+		sc.cleanup()
+		return nil, err
+	}
+	if setFinalizer {
+		runtime.SetFinalizer(sc, finalizeStreamCloser)
+	}
+
+	return sc, nil
+}
+
+func finalizeStreamCloser(obj interface{}) {
+	obj.(*streamCloser).Close()
+}
+
+func (sc *streamCloser) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("destination buffer too small")
+	}
+	if len(src) == 0 {
+		return
+	}
+	if sc.session == nil {
+		panic("stream is closed")
+	}
+
+	result, err := sc.session.ctx.EncryptUpdate(sc.session.handle, src)
+	if err != nil {
+		panic(err)
+	}
+	if len(result) != len(src) {
+		panic("token did not return output for all input")
+	}
+	copy(dst[:len(result)], result)
+	runtime.KeepAlive(sc)
+}
+
+func (sc *streamCloser) Close() {
+	if sc.session == nil {
+		return
+	}
+
+	// Any output left over would have been due from previous calls, and cannot be returned now
+	_, _ = sc.session.ctx.EncryptFinal(sc.session.handle)
+	sc.session = nil
+	sc.cleanup()
+}
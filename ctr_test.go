@@ -0,0 +1,97 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCTRMatchesSoftware(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_AES_CTR)
+
+		// Use a key whose value can be read, so the software implementation can use it too
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		_ = template.Set(CkaSensitive, false)
+		_ = template.Set(CkaExtractable, true)
+
+		key, err := ctx.GenerateSecretKeyWithAttributes(template, 256, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		value, err := key.Attribute(pkcs11.CKA_VALUE)
+		require.NoError(t, err)
+		block, err := aes.NewCipher(value.Value)
+		require.NoError(t, err)
+
+		// Start near the end of the counter's range, to check carrying
+		iv := make([]byte, aes.BlockSize)
+		for i := 8; i < len(iv); i++ {
+			iv[i] = 0xff
+		}
+
+		plaintext := make([]byte, 0, 1000)
+		for len(plaintext) < cap(plaintext) {
+			plaintext = append(plaintext, randomBytes()...)
+		}
+
+		expected := make([]byte, len(plaintext))
+		cipher.NewCTR(block, iv).XORKeyStream(expected, plaintext)
+
+		stream, err := key.NewCTRCloser(iv)
+		require.NoError(t, err)
+
+		// Uneven pieces must not disturb the key stream
+		ciphertext := make([]byte, len(plaintext))
+		for i := 0; i < len(plaintext); i += 100 {
+			end := i + 100
+			if end > len(plaintext) {
+				end = len(plaintext)
+			}
+			stream.XORKeyStream(ciphertext[i:end], plaintext[i:end])
+		}
+		stream.Close()
+		require.Equal(t, expected, ciphertext)
+
+		decrypter, err := key.NewCTR(iv)
+		require.NoError(t, err)
+		decrypted := make([]byte, len(ciphertext))
+		decrypter.XORKeyStream(decrypted, ciphertext)
+		require.Equal(t, plaintext, decrypted)
+	})
+}
+
+func TestCTRRequiresAES(t *testing.T) {
+	key := &SecretKey{Cipher: CipherDES3}
+	_, err := key.NewCTR(make([]byte, 8))
+	require.Error(t, err)
+
+	key = &SecretKey{Cipher: CipherAES}
+	_, err = key.NewCTR(make([]byte, 8))
+	require.Error(t, err)
+}
@@ -0,0 +1,274 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// DHParameters are the domain parameters of a finite-field Diffie-Hellman group (PKCS #3).
+type DHParameters struct {
+	// P is the prime modulus.
+	P *big.Int
+
+	// G is the base (generator).
+	G *big.Int
+}
+
+// DHPublicKey is a finite-field Diffie-Hellman public key.
+type DHPublicKey struct {
+	DHParameters
+
+	// Y is the public value, G^x mod P.
+	Y *big.Int
+}
+
+// DHKey is a PKCS#11 finite-field Diffie-Hellman key pair (CKK_DH), which agrees shared secrets using
+// CKM_DH_PKCS_DERIVE.
+type DHKey interface {
+	// Public returns the public half of the key pair, as a *DHPublicKey.
+	Public() crypto.PublicKey
+
+	// Delete deletes the key pair from the token.
+	Delete() error
+
+	// Derive agrees a shared secret with the holder of peerPublic and returns it as a generic secret key.
+	Derive(peerPublic *DHPublicKey) (*SecretKey, error)
+}
+
+// pkcs11PrivateKeyDH contains a reference to a loaded PKCS#11 DH private key object.
+type pkcs11PrivateKeyDH struct {
+	pkcs11PrivateKey
+}
+
+// Export the public key corresponding to a private DH key.
+func exportDHPublicKey(session *pkcs11Session, pubHandle pkcs11.ObjectHandle) (*DHPublicKey, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_BASE, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil),
+	}
+	exported, err := session.ctx.GetAttributeValue(session.handle, pubHandle, template)
+	if err != nil {
+		return nil, err
+	}
+	return &DHPublicKey{
+		DHParameters: DHParameters{
+			P: new(big.Int).SetBytes(exported[0].Value),
+			G: new(big.Int).SetBytes(exported[1].Value),
+		},
+		Y: new(big.Int).SetBytes(exported[2].Value),
+	}, nil
+}
+
+// GenerateDHKeyPair creates a DH key pair in the group given by params on the token. The id parameter is used to set
+// CKA_ID and must be non-nil.
+func (c *Context) GenerateDHKeyPair(id []byte, params *DHParameters) (DHKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	public, err := NewAttributeSetWithID(id)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the AttributeSet to allow modifications.
+	private := public.Copy()
+
+	return c.GenerateDHKeyPairWithAttributes(public, private, params)
+}
+
+// GenerateDHKeyPairWithLabel creates a DH key pair in the group given by params on the token. The id and label
+// parameters are used to set CKA_ID and CKA_LABEL respectively and must be non-nil.
+func (c *Context) GenerateDHKeyPairWithLabel(id, label []byte, params *DHParameters) (DHKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	public, err := NewAttributeSetWithIDAndLabel(id, label)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the AttributeSet to allow modifications.
+	private := public.Copy()
+
+	return c.GenerateDHKeyPairWithAttributes(public, private, params)
+}
+
+// GenerateDHKeyPairWithAttributes creates a DH key pair in the group given by params on the token. After this function
+// returns, public and private will contain the attributes applied to the key pair. If required attributes are missing,
+// they will be set to a default value.
+func (c *Context) GenerateDHKeyPairWithAttributes(public, private AttributeSet, params *DHParameters) (DHKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if params == nil || params.P == nil || params.G == nil {
+		return nil, errors.New("DH parameters must be specified")
+	}
+
+	var k DHKey
+	err := c.withObjectSession(private, func(session *pkcs11Session) error {
+		public.AddIfNotPresent([]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_DH),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIME, params.P.Bytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_BASE, params.G.Bytes()),
+		})
+		private.AddIfNotPresent([]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		})
+
+		if err := c.checkKeySize(session, pkcs11.CKM_DH_PKCS_KEY_PAIR_GEN, "DH prime", params.P.BitLen()); err != nil {
+			return err
+		}
+
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_DH_PKCS_KEY_PAIR_GEN, nil)}
+		pubHandle, privHandle, err := session.ctx.GenerateKeyPair(session.handle,
+			mech,
+			public.ToSlice(),
+			private.ToSlice())
+		if err != nil {
+			return err
+		}
+		pub, err := exportDHPublicKey(session, pubHandle)
+		if err != nil {
+			return err
+		}
+		k = &pkcs11PrivateKeyDH{
+			pkcs11PrivateKey: pkcs11PrivateKey{
+				pkcs11Object: pkcs11Object{
+					handle:  privHandle,
+					context: c,
+				},
+				pubKeyHandle: pubHandle,
+				pubKey:       pub,
+				identity:     templateIdentity(private, pkcs11.CKK_DH),
+			}}
+		return nil
+	})
+	return k, err
+}
+
+// FindDHKeyPair retrieves a previously created DH key pair, or nil if it cannot be found. DH keys are not returned by
+// FindKeyPair, as they cannot implement crypto.Signer.
+//
+// At least one of id and label must be specified. The private key must have a non-empty CKA_ID, which is used to find
+// the public key.
+func (c *Context) FindDHKeyPair(id, label []byte) (DHKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if id == nil && label == nil {
+		return nil, errors.New("id and label cannot both be nil")
+	}
+
+	var k DHKey
+	err := c.withSession(func(session *pkcs11Session) error {
+		privHandle, err := findKey(session, id, label, uintPtr(pkcs11.CKO_PRIVATE_KEY), uintPtr(pkcs11.CKK_DH))
+		if err != nil || privHandle == nil {
+			return err
+		}
+
+		attributes := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+		}
+		if attributes, err = session.ctx.GetAttributeValue(session.handle, *privHandle, attributes); err != nil {
+			return err
+		}
+		if len(attributes[0].Value) == 0 {
+			return errNoCkaId
+		}
+
+		pubHandle, err := findKey(session, attributes[0].Value, nil, uintPtr(pkcs11.CKO_PUBLIC_KEY),
+			uintPtr(pkcs11.CKK_DH))
+		if err != nil {
+			return err
+		}
+		if pubHandle == nil {
+			return errNoPublicHalf
+		}
+		pub, err := exportDHPublicKey(session, *pubHandle)
+		if err != nil {
+			return err
+		}
+
+		k = &pkcs11PrivateKeyDH{
+			pkcs11PrivateKey: pkcs11PrivateKey{
+				pkcs11Object: pkcs11Object{
+					handle:  *privHandle,
+					context: c,
+				},
+				pubKeyHandle: *pubHandle,
+				pubKey:       pub,
+				identity:     newKeyIdentity(attributes[0].Value, attributes[1].Value, pkcs11.CKK_DH),
+			}}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Derive agrees a shared secret with the holder of peerPublic using CKM_DH_PKCS_DERIVE. The peer must use the same
+// group as the key. The shared secret is returned as a generic secret session key, which is destroyed when the Context
+// is closed.
+func (key *pkcs11PrivateKeyDH) Derive(peerPublic *DHPublicKey) (*SecretKey, error) {
+	if key.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	params := key.Public().(*DHPublicKey).DHParameters
+	if peerPublic == nil || peerPublic.Y == nil {
+		return nil, errors.New("peer public key must be specified")
+	}
+	if peerPublic.P != nil && peerPublic.P.Cmp(params.P) != 0 ||
+		peerPublic.G != nil && peerPublic.G.Cmp(params.G) != 0 {
+		return nil, errors.New("peer public key is in a different DH group")
+	}
+
+	// Reject the degenerate values 0, 1 and P-1, which would leak the shared secret.
+	pMinusOne := new(big.Int).Sub(params.P, big.NewInt(1))
+	if peerPublic.Y.Cmp(big.NewInt(1)) <= 0 || peerPublic.Y.Cmp(pMinusOne) >= 0 {
+		return nil, errors.New("peer public value is out of range")
+	}
+
+	size := (params.P.BitLen() + 7) / 8
+	y := peerPublic.Y.Bytes()
+	peerValue := make([]byte, size)
+	copy(peerValue[size-len(y):], y)
+	mech := pkcs11.NewMechanism(pkcs11.CKM_DH_PKCS_DERIVE, peerValue)
+
+	return key.context.deriveSecretKey(key.handle, mech, size)
+}
@@ -0,0 +1,103 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// modp2048 is the 2048-bit MODP group from RFC 3526.
+var modp2048 = func() *DHParameters {
+	p, _ := new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08"+
+		"798E3404DDEF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386B"+
+		"FB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F3"+
+		"56208552BB9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3BE39E772C180E86039B2783A2EC07A28FB5C55D"+
+		"F06F4C52C9DE2BCBF6955817183995497CEA956AE515D2261898FA051015728E5A8AACAA68FFFFFFFFFFFFFFFF", 16)
+	return &DHParameters{P: p, G: big.NewInt(2)}
+}()
+
+func TestDHDerive(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_DH_PKCS_KEY_PAIR_GEN)
+
+		id := randomBytes()
+		alice, err := ctx.GenerateDHKeyPair(id, modp2048)
+		require.NoError(t, err)
+		defer func() { _ = alice.Delete() }()
+		bob, err := ctx.GenerateDHKeyPairWithLabel(randomBytes(), randomBytes(), modp2048)
+		require.NoError(t, err)
+		defer func() { _ = bob.Delete() }()
+
+		alicePublic := alice.Public().(*DHPublicKey)
+		assert.Equal(t, 0, modp2048.P.Cmp(alicePublic.P))
+		assert.Equal(t, 0, modp2048.G.Cmp(alicePublic.G))
+
+		found, err := ctx.FindDHKeyPair(id, nil)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, 0, alicePublic.Y.Cmp(found.Public().(*DHPublicKey).Y))
+
+		aliceSecret, err := alice.Derive(bob.Public().(*DHPublicKey))
+		require.NoError(t, err)
+		defer func() { _ = aliceSecret.Delete() }()
+
+		bobSecret, err := bob.Derive(alicePublic)
+		require.NoError(t, err)
+		defer func() { _ = bobSecret.Delete() }()
+
+		// Both parties must arrive at the same secret
+		message := randomBytes()
+		var macs [][]byte
+		for _, secret := range []*SecretKey{aliceSecret, bobSecret} {
+			h, err := secret.NewHMAC(pkcs11.CKM_SHA256_HMAC, 0)
+			require.NoError(t, err)
+			_, err = h.Write(message)
+			require.NoError(t, err)
+			macs = append(macs, h.Sum(nil))
+		}
+		assert.Equal(t, macs[0], macs[1])
+
+		for _, y := range []*big.Int{big.NewInt(1), new(big.Int).Sub(modp2048.P, big.NewInt(1))} {
+			_, err = alice.Derive(&DHPublicKey{Y: y})
+			require.Error(t, err)
+		}
+		_, err = alice.Derive(&DHPublicKey{DHParameters: DHParameters{P: big.NewInt(23), G: big.NewInt(5)},
+			Y: big.NewInt(8)})
+		require.Error(t, err)
+	})
+}
+
+func TestFindDHKeyPairNotFound(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.FindDHKeyPair(randomBytes(), nil)
+		require.NoError(t, err)
+		require.Nil(t, key)
+
+		_, err = ctx.FindDHKeyPair(nil, nil)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,218 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	"hash"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+type digestInfo struct {
+	mechanism uint
+	blockSize int
+}
+
+// digestInfos maps hash functions to the corresponding PKCS#11 digest mechanism.
+var digestInfos = map[crypto.Hash]digestInfo{
+	crypto.MD5:        {pkcs11.CKM_MD5, 64},
+	crypto.SHA1:       {pkcs11.CKM_SHA_1, 64},
+	crypto.SHA224:     {pkcs11.CKM_SHA224, 64},
+	crypto.SHA256:     {pkcs11.CKM_SHA256, 64},
+	crypto.SHA384:     {pkcs11.CKM_SHA384, 128},
+	crypto.SHA512:     {pkcs11.CKM_SHA512, 128},
+	crypto.SHA512_224: {pkcs11.CKM_SHA512_224, 128},
+	crypto.SHA512_256: {pkcs11.CKM_SHA512_256, 128},
+	crypto.RIPEMD160:  {pkcs11.CKM_RIPEMD160, 64},
+}
+
+// errDigestClosed is returned if a digest is updated after it has finished.
+var errDigestClosed = errors.New("already called Sum()")
+
+// digestMechanism returns the digest mechanism information for hashFunction, checking that the token supports it.
+func (c *Context) digestMechanism(hashFunction crypto.Hash) (digestInfo, error) {
+	info, ok := digestInfos[hashFunction]
+	if !ok {
+		return digestInfo{}, errors.Errorf("unsupported hash function for digest: %v", hashFunction)
+	}
+
+	supported, err := c.mechanismSupported(info.mechanism)
+	if err != nil {
+		return digestInfo{}, err
+	}
+	if !supported {
+		return digestInfo{}, errors.Errorf("token does not support %s", mechanismString(info.mechanism))
+	}
+	return info, nil
+}
+
+// Digest hashes data on the token using the PKCS#11 digest mechanism for hashFunction, e.g. CKM_SHA256 for
+// crypto.SHA256. An error is returned if the token does not support the mechanism.
+func (c *Context) Digest(hashFunction crypto.Hash, data []byte) ([]byte, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	info, err := c.digestMechanism(hashFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	var digest []byte
+	err = c.withSession(func(session *pkcs11Session) (err error) {
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(info.mechanism, nil)}
+		if err = session.ctx.DigestInit(session.handle, mech); err != nil {
+			return errors.WithMessage(err, "C_DigestInit failed")
+		}
+		digest, err = session.ctx.Digest(session.handle, data)
+		return errors.WithMessage(err, "C_Digest failed")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+type digestImplementation struct {
+	// PKCS#11 context
+	context *Context
+
+	// PKCS#11 session to use, or nil once released
+	session *pkcs11Session
+
+	// Hash function
+	hashFunction crypto.Hash
+
+	// PKCS#11 mechanism and block size
+	info digestInfo
+
+	// Result, or nil if we don't have the answer yet
+	result []byte
+}
+
+// NewDigest returns a new hash that streams data written to it to the token, using the PKCS#11 digest mechanism
+// for hashFunction. An error is returned if the token does not support the mechanism.
+//
+// The hash holds a session from the pool until Sum is called. After Sum() is called no new data may be added. Reset
+// starts a new digest.
+func (c *Context) NewDigest(hashFunction crypto.Hash) (hash.Hash, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	info, err := c.digestMechanism(hashFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &digestImplementation{
+		context:      c,
+		hashFunction: hashFunction,
+		info:         info,
+	}
+	if err = d.initialize(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *digestImplementation) initialize() error {
+	session, err := d.context.getSession()
+	if err != nil {
+		return err
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(d.info.mechanism, nil)}
+	if err = session.ctx.DigestInit(session.handle, mech); err != nil {
+		d.context.pool.Put(session)
+		return errors.WithMessage(err, "C_DigestInit failed")
+	}
+
+	d.session = session
+	return nil
+}
+
+// release returns the session, if still held, to the pool.
+func (d *digestImplementation) release() {
+	if d.session != nil {
+		d.context.pool.Put(d.session)
+		d.session = nil
+	}
+}
+
+func (d *digestImplementation) Write(p []byte) (n int, err error) {
+	if d.result != nil {
+		if len(p) > 0 {
+			err = errDigestClosed
+		}
+		return
+	}
+	if d.session == nil || d.context.closed.Get() {
+		// Release the session, so that closing the Context is not blocked by this digest.
+		d.release()
+		return 0, errClosed
+	}
+	if err = d.session.ctx.DigestUpdate(d.session.handle, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (d *digestImplementation) Sum(b []byte) []byte {
+	if d.result == nil {
+		if d.session == nil || d.context.closed.Get() {
+			d.release()
+			panic(errClosed)
+		}
+
+		result, err := d.session.ctx.DigestFinal(d.session.handle)
+		d.release()
+		if err != nil {
+			panic(err)
+		}
+		d.result = result
+	}
+	return append(b, d.result...)
+}
+
+func (d *digestImplementation) Reset() {
+	if d.result == nil && d.session != nil {
+		// Finish the digest in progress, so the session can be reused
+		_, _ = d.session.ctx.DigestFinal(d.session.handle)
+		d.release()
+	}
+	d.result = nil
+
+	// As with HMAC, Reset cannot report an error. A failure leaves the digest without a session, so subsequent
+	// writes fail.
+	_ = d.initialize()
+}
+
+func (d *digestImplementation) Size() int {
+	return d.hashFunction.Size()
+}
+
+func (d *digestImplementation) BlockSize() int {
+	return d.info.blockSize
+}
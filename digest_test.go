@@ -0,0 +1,87 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestMatchesSoftware(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		data := make([]byte, 0, 1000)
+		for len(data) < cap(data) {
+			data = append(data, randomBytes()...)
+		}
+
+		for _, hashFunction := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+			t.Run(fmt.Sprintf("%v", hashFunction), func(t *testing.T) {
+				expected := hashFunction.New()
+				_, err := expected.Write(data)
+				require.NoError(t, err)
+
+				digest, err := ctx.Digest(hashFunction, data)
+				require.NoError(t, err)
+				assert.Equal(t, expected.Sum(nil), digest)
+
+				h, err := ctx.NewDigest(hashFunction)
+				require.NoError(t, err)
+				assert.Equal(t, hashFunction.Size(), h.Size())
+
+				// Stream the data in uneven pieces
+				for i := 0; i < len(data); i += 100 {
+					end := i + 100
+					if end > len(data) {
+						end = len(data)
+					}
+					_, err = h.Write(data[i:end])
+					require.NoError(t, err)
+				}
+				assert.Equal(t, expected.Sum(nil), h.Sum(nil))
+
+				_, err = h.Write(data)
+				assert.Equal(t, errDigestClosed, err)
+
+				h.Reset()
+				expected.Reset()
+				assert.Equal(t, expected.Sum(nil), h.Sum(nil))
+			})
+		}
+	})
+}
+
+func TestDigestUnsupportedHash(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		_, err := ctx.Digest(crypto.SHA3_256, randomBytes())
+		require.Error(t, err)
+
+		_, err = ctx.NewDigest(crypto.SHA3_256)
+		require.Error(t, err)
+	})
+}
@@ -22,6 +22,7 @@
 package crypto11
 
 import (
+	"context"
 	"crypto"
 	"crypto/dsa"
 	"io"
@@ -115,7 +116,7 @@ func (c *Context) GenerateDSAKeyPairWithAttributes(public, private AttributeSet,
 	}
 
 	var k Signer
-	err := c.withSession(func(session *pkcs11Session) error {
+	err := c.withObjectSession(private, func(session *pkcs11Session) error {
 		p := params.P.Bytes()
 		q := params.Q.Bytes()
 		g := params.G.Bytes()
@@ -136,6 +137,10 @@ func (c *Context) GenerateDSAKeyPairWithAttributes(public, private AttributeSet,
 			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
 		})
 
+		if err := c.checkKeySize(session, pkcs11.CKM_DSA_KEY_PAIR_GEN, "DSA prime", params.P.BitLen()); err != nil {
+			return err
+		}
+
 		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_DSA_KEY_PAIR_GEN, nil)}
 		pubHandle, privHandle, err := session.ctx.GenerateKeyPair(session.handle,
 			mech,
@@ -156,6 +161,7 @@ func (c *Context) GenerateDSAKeyPairWithAttributes(public, private AttributeSet,
 				},
 				pubKeyHandle: pubHandle,
 				pubKey:       pub,
+				identity:     templateIdentity(private, pkcs11.CKK_DSA),
 			}}
 		return nil
 
@@ -163,6 +169,81 @@ func (c *Context) GenerateDSAKeyPairWithAttributes(public, private AttributeSet,
 	return k, err
 }
 
+// GenerateDSAKeyPairWithParams creates a DSA key pair on the token, with new domain parameters of L and N bits
+// generated by the token using CKM_DSA_PARAMETER_GEN. The id parameter is used to set CKA_ID and must be non-nil; label
+// is optional.
+//
+// The generated parameters are available from the Parameters field of the key's public key, which is a
+// *dsa.PublicKey. If the token cannot generate parameters, generate them with dsa.GenerateParameters and call
+// GenerateDSAKeyPairWithLabel instead.
+func (c *Context) GenerateDSAKeyPairWithParams(id, label []byte, L, N int) (Signer, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if err := notNilBytes(id, "id"); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case L == 1024 && N == 160, L == 2048 && N == 224, L == 2048 && N == 256, L == 3072 && N == 256:
+	default:
+		return nil, errors.Errorf("unsupported DSA parameter sizes L=%d, N=%d", L, N)
+	}
+
+	var params *dsa.Parameters
+	err := c.withSession(func(session *pkcs11Session) (err error) {
+		params, err = generateDSAParameters(session, L, N)
+		return err
+	})
+	if isPKCS11Error(err, pkcs11.CKR_MECHANISM_INVALID) {
+		return nil, errors.WithMessage(err, "token cannot generate DSA parameters; use dsa.GenerateParameters and "+
+			"GenerateDSAKeyPairWithLabel instead")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if label == nil {
+		return c.GenerateDSAKeyPair(id, params)
+	}
+	return c.GenerateDSAKeyPairWithLabel(id, label, params)
+}
+
+// generateDSAParameters generates DSA domain parameters in a session object, and returns them after destroying the
+// object.
+func generateDSAParameters(session *pkcs11Session, L, N int) (*dsa.Parameters, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DOMAIN_PARAMETERS),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_DSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_BITS, L),
+		pkcs11.NewAttribute(pkcs11.CKA_SUBPRIME_BITS, N),
+	}
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_DSA_PARAMETER_GEN, nil)}
+	handle, err := session.ctx.GenerateKey(session.handle, mech, template)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = session.ctx.DestroyObject(session.handle, handle) }()
+
+	template = []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_SUBPRIME, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_BASE, nil),
+	}
+	exported, err := session.ctx.GetAttributeValue(session.handle, handle, template)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dsa.Parameters{
+		P: new(big.Int).SetBytes(exported[0].Value),
+		Q: new(big.Int).SetBytes(exported[1].Value),
+		G: new(big.Int).SetBytes(exported[2].Value),
+	}, nil
+}
+
 // Sign signs a message using a DSA key.
 //
 // This completes the implemention of crypto.Signer for pkcs11PrivateKeyDSA.
@@ -171,5 +252,19 @@ func (c *Context) GenerateDSAKeyPairWithAttributes(public, private AttributeSet,
 //
 // The return value is a DER-encoded byteblock.
 func (signer *pkcs11PrivateKeyDSA) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
-	return signer.context.dsaGeneric(signer.handle, pkcs11.CKM_DSA, digest)
+	return signer.SignContext(context.Background(), rand, digest, opts)
+}
+
+// SignContext is like Sign, but gives up when ctx is done.
+func (signer *pkcs11PrivateKeyDSA) SignContext(ctx context.Context, rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) ([]byte, error) {
+
+	return signer.dsaGeneric(ctx, pkcs11.CKM_DSA, digest)
+}
+
+// SignBatch implements BatchSigner.
+func (signer *pkcs11PrivateKeyDSA) SignBatch(rand io.Reader, digests [][]byte,
+	opts crypto.SignerOpts) ([][]byte, error) {
+
+	return signBatch(signer, &signer.pkcs11PrivateKey, rand, digests, opts)
 }
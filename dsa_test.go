@@ -32,6 +32,7 @@ import (
 	"math/big"
 	"testing"
 
+	"github.com/miekg/pkcs11"
 	"github.com/stretchr/testify/require"
 )
 
@@ -202,3 +203,25 @@ func TestDsaRequiredArgs(t *testing.T) {
 	_, err = ctx.GenerateDSAKeyPairWithLabel(val, nil, dsaSizes[dsa.L2048N224])
 	require.Error(t, err)
 }
+
+func TestGenerateDSAKeyPairWithParams(t *testing.T) {
+	skipTest(t, skipTestDSA)
+
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateDSAKeyPairWithParams(randomBytes(), randomBytes(), 1024, 160)
+		if isPKCS11Error(err, pkcs11.CKR_MECHANISM_INVALID) {
+			t.Skip("token does not support CKM_DSA_PARAMETER_GEN")
+		}
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		params := key.Public().(*dsa.PublicKey).Parameters
+		require.Equal(t, 1024, params.P.BitLen())
+		require.Equal(t, 160, params.Q.BitLen())
+
+		testDsaSigning(t, key, dsa.L1024N160, "generated")
+
+		_, err = ctx.GenerateDSAKeyPairWithParams(randomBytes(), nil, 1024, 256)
+		require.Error(t, err)
+	})
+}
@@ -23,6 +23,7 @@ package crypto11
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -134,6 +135,19 @@ var wellKnownCurves = map[string]curveInfo{
 		mustMarshal(asn1.ObjectIdentifier{1, 3, 132, 0, 39}),
 		nil,
 	},
+
+	"brainpoolP256r1": {
+		mustMarshal(asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 7}),
+		BrainpoolP256r1(),
+	},
+	"brainpoolP384r1": {
+		mustMarshal(asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 11}),
+		BrainpoolP384r1(),
+	},
+	"brainpoolP512r1": {
+		mustMarshal(asn1.ObjectIdentifier{1, 3, 36, 3, 3, 2, 8, 1, 1, 13}),
+		BrainpoolP512r1(),
+	},
 }
 
 func marshalEcParams(c elliptic.Curve) ([]byte, error) {
@@ -199,7 +213,8 @@ func exportECDSAPublicKey(session *pkcs11Session, pubHandle pkcs11.ObjectHandle)
 }
 
 // GenerateECDSAKeyPair creates a ECDSA key pair on the token using curve c. The id parameter is used to
-// set CKA_ID and must be non-nil. Only a limited set of named elliptic curves are supported. The
+// set CKA_ID and must be non-nil. Only a limited set of named elliptic curves are supported: the NIST curves from
+// crypto/elliptic and the Brainpool curves returned by BrainpoolP256r1, BrainpoolP384r1 and BrainpoolP512r1. The
 // underlying PKCS#11 implementation may impose further restrictions.
 func (c *Context) GenerateECDSAKeyPair(id []byte, curve elliptic.Curve) (Signer, error) {
 	if c.closed.Get() {
@@ -234,6 +249,69 @@ func (c *Context) GenerateECDSAKeyPairWithLabel(id, label []byte, curve elliptic
 	return c.GenerateECDSAKeyPairWithAttributes(public, private, curve)
 }
 
+// GenerateECDSAKeyPairWithRandomID creates an ECDSA key pair on the token using curve c, like GenerateECDSAKeyPair,
+// with a random CKA_ID that no other object on the token has. The CKA_ID is returned along with the key. If label is
+// non-nil, it is used to set CKA_LABEL.
+func (c *Context) GenerateECDSAKeyPairWithRandomID(label []byte, curve elliptic.Curve) (Signer, []byte, error) {
+	if c.closed.Get() {
+		return nil, nil, errClosed
+	}
+
+	var k Signer
+	id, err := c.withRandomID(func(id []byte) (err error) {
+		k, err = c.GenerateECDSAKeyPairWithOptions(id, label, curve, KeyGenOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, id, nil
+}
+
+// GenerateECDSAKeyPairWithOptions creates an ECDSA key pair on the token using curve c, protecting the private key
+// as selected in opts. The id parameter is used to set CKA_ID and must be non-nil. If label is non-nil, it is used
+// to set CKA_LABEL.
+func (c *Context) GenerateECDSAKeyPairWithOptions(id, label []byte, curve elliptic.Curve, opts KeyGenOptions) (Signer,
+	error) {
+
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if err := opts.validateNonRSA(); err != nil {
+		return nil, err
+	}
+
+	public, err := newKeyAttributeSet(id, label)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the AttributeSet to allow modifications.
+	private := public.Copy()
+
+	opts.apply(private)
+	opts.applyPublic(public)
+
+	return c.GenerateECDSAKeyPairWithAttributes(public, private, curve)
+}
+
+// GenerateECDSAKeyPairEphemeral creates a short-lived ECDSA key pair on curve c. The key pair consists of session
+// objects, which are never stored on the token and are destroyed when the Context is closed. It has no CKA_ID, so
+// cannot be found with FindKeyPair.
+func (c *Context) GenerateECDSAKeyPairEphemeral(curve elliptic.Curve) (Signer, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	public := NewAttributeSet()
+	private := NewAttributeSet()
+	opts := KeyGenOptions{Ephemeral: true}
+	opts.apply(private)
+	opts.applyPublic(public)
+
+	return c.GenerateECDSAKeyPairWithAttributes(public, private, curve)
+}
+
 // GenerateECDSAKeyPairWithAttributes generates an ECDSA key pair on the token. After this function returns, public and
 // private will contain the attributes applied to the key pair. If required attributes are missing, they will be set to
 // a default value.
@@ -243,7 +321,7 @@ func (c *Context) GenerateECDSAKeyPairWithAttributes(public, private AttributeSe
 	}
 
 	var k Signer
-	err := c.withSession(func(session *pkcs11Session) error {
+	err := c.withObjectSession(private, func(session *pkcs11Session) error {
 
 		parameters, err := marshalEcParams(curve)
 		if err != nil {
@@ -263,12 +341,22 @@ func (c *Context) GenerateECDSAKeyPairWithAttributes(public, private AttributeSe
 			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
 		})
 
+		err = c.checkKeySize(session, pkcs11.CKM_ECDSA_KEY_PAIR_GEN, "elliptic curve "+curve.Params().Name,
+			curve.Params().BitSize)
+		if err != nil {
+			return err
+		}
+
 		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA_KEY_PAIR_GEN, nil)}
 		pubHandle, privHandle, err := session.ctx.GenerateKeyPair(session.handle,
 			mech,
 			public.ToSlice(),
 			private.ToSlice())
 		if err != nil {
+			if isPKCS11Error(err, pkcs11.CKR_CURVE_NOT_SUPPORTED) ||
+				isPKCS11Error(err, pkcs11.CKR_DOMAIN_PARAMS_INVALID) {
+				return errors.WithMessagef(err, "token does not support elliptic curve %s", curve.Params().Name)
+			}
 			return err
 		}
 
@@ -284,6 +372,7 @@ func (c *Context) GenerateECDSAKeyPairWithAttributes(public, private AttributeSe
 				},
 				pubKeyHandle: pubHandle,
 				pubKey:       pub,
+				identity:     templateIdentity(private, pkcs11.CKK_ECDSA),
 			}}
 		return nil
 	})
@@ -298,5 +387,123 @@ func (c *Context) GenerateECDSAKeyPairWithAttributes(public, private AttributeSe
 //
 // The return value is a DER-encoded byteblock.
 func (signer *pkcs11PrivateKeyECDSA) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
-	return signer.context.dsaGeneric(signer.handle, pkcs11.CKM_ECDSA, digest)
+	return signer.SignContext(context.Background(), rand, digest, opts)
+}
+
+// SignContext is like Sign, but gives up when ctx is done.
+func (signer *pkcs11PrivateKeyECDSA) SignContext(ctx context.Context, rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) ([]byte, error) {
+
+	return signer.dsaGeneric(ctx, pkcs11.CKM_ECDSA, digest)
+}
+
+// SignBatch implements BatchSigner.
+func (signer *pkcs11PrivateKeyECDSA) SignBatch(rand io.Reader, digests [][]byte,
+	opts crypto.SignerOpts) ([][]byte, error) {
+
+	return signBatch(signer, &signer.pkcs11PrivateKey, rand, digests, opts)
+}
+
+// RawSigner is implemented by the ECDSA keys returned by this package. See pkcs11PrivateKeyECDSA.SignRaw.
+type RawSigner interface {
+	Signer
+
+	// SignRaw signs digest, returning the signature as r and s concatenated rather than DER-encoded.
+	SignRaw(digest []byte) ([]byte, error)
+}
+
+// SignRaw signs digest like Sign, but returns r and s as big-endian integers concatenated, each padded to the byte
+// length of the curve, as used by JWS (RFC 7518) and WebAuthn, rather than DER-encoded.
+func (signer *pkcs11PrivateKeyECDSA) SignRaw(digest []byte) ([]byte, error) {
+	sigBytes, err := signer.dsaGenericRaw(context.Background(), pkcs11.CKM_ECDSA, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig dsaSignature
+	if err = sig.unmarshalBytes(sigBytes); err != nil {
+		return nil, err
+	}
+
+	size := (signer.Public().(*ecdsa.PublicKey).Curve.Params().BitSize + 7) / 8
+	return sig.marshalRaw(size)
+}
+
+// ParseECPoint parses an elliptic curve point on curve in either uncompressed or compressed form (ANSI X9.62,
+// section 4.3.6), for example the ephemeral public key of a peer in an ECDH exchange.
+func ParseECPoint(curve elliptic.Curve, point []byte) (*ecdsa.PublicKey, error) {
+	byteLen := (curve.Params().BitSize + 7) / 8
+	if len(point) == 0 {
+		return nil, errors.New("elliptic curve point is empty")
+	}
+
+	switch point[0] {
+	case 4:
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("failed to parse elliptic curve point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case 2, 3:
+		if len(point) != 1+byteLen {
+			return nil, errors.New("compressed elliptic curve point has the wrong length")
+		}
+		params := curve.Params()
+		x := new(big.Int).SetBytes(point[1:])
+		if x.Cmp(params.P) >= 0 {
+			return nil, errors.New("failed to parse elliptic curve point")
+		}
+
+		var y *big.Int
+		if bc, ok := curve.(*brainpoolCurve); ok {
+			y = bc.rhs(x)
+		} else {
+			// y² = x³ - 3x + b
+			y = new(big.Int).Mul(x, x)
+			y.Mul(y, x)
+			threeX := new(big.Int).Lsh(x, 1)
+			threeX.Add(threeX, x)
+			y.Sub(y, threeX)
+			y.Add(y, params.B)
+			y.Mod(y, params.P)
+		}
+
+		if y.ModSqrt(y, params.P) == nil {
+			return nil, errors.New("elliptic curve point is not on the curve")
+		}
+		if y.Bit(0) != uint(point[0]&1) {
+			y.Sub(params.P, y)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, errors.Errorf("unsupported elliptic curve point format %#x", point[0])
+	}
+}
+
+// Derive agrees a shared secret with the holder of peerPublic using CKM_ECDH1_DERIVE, without applying a key
+// derivation function. The private key must have CKA_DERIVE set. The shared secret is returned as a generic secret
+// session key, which is destroyed when the Context is closed.
+func (signer *pkcs11PrivateKeyECDSA) Derive(peerPublic *ecdsa.PublicKey) (*SecretKey, error) {
+	if signer.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	curve := signer.Public().(*ecdsa.PublicKey).Curve
+	if peerPublic == nil || peerPublic.X == nil || peerPublic.Y == nil {
+		return nil, errors.New("peer public key must be specified")
+	}
+	if peerPublic.Curve.Params().Name != curve.Params().Name {
+		return nil, errors.Errorf("peer public key is on curve %s, expected %s", peerPublic.Curve.Params().Name,
+			curve.Params().Name)
+	}
+	if !curve.IsOnCurve(peerPublic.X, peerPublic.Y) {
+		return nil, errors.New("peer public key is not on the curve")
+	}
+
+	mech := pkcs11.NewMechanism(pkcs11.CKM_ECDH1_DERIVE, pkcs11.NewECDH1DeriveParams(pkcs11.CKD_NULL, nil,
+		elliptic.Marshal(curve, peerPublic.X, peerPublic.Y)))
+
+	return signer.context.deriveSecretKey(signer.handle, mech, (curve.Params().BitSize+7)/8)
 }
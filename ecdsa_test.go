@@ -29,6 +29,7 @@ import (
 	_ "crypto/sha1"
 	_ "crypto/sha256"
 	_ "crypto/sha512"
+	"errors"
 	"testing"
 
 	"github.com/miekg/pkcs11"
@@ -106,8 +107,8 @@ func testEcdsaSigning(t *testing.T, key crypto.Signer, hashFunction crypto.Hash,
 
 	sigDER, err := key.Sign(rand.Reader, plaintextHash, nil)
 
-	p11Err, ok := err.(pkcs11.Error)
-	if ok && p11Err == pkcs11.CKR_KEY_SIZE_RANGE {
+	var p11Err pkcs11.Error
+	if errors.As(err, &p11Err) && p11Err == pkcs11.CKR_KEY_SIZE_RANGE {
 		// Returned by CloudHSM (at least), for key sizes it doesn't support.
 		t.Logf("Skipping unsupported curve %s and hash %s", curveName, hashName)
 		return
@@ -150,3 +151,142 @@ func TestEcdsaRequiredArgs(t *testing.T) {
 	_, err = ctx.GenerateECDSAKeyPairWithLabel(val, nil, elliptic.P224())
 	require.Error(t, err)
 }
+
+func TestECDHDerive(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		generate := func() SignerDeriver {
+			public, err := NewAttributeSetWithID(randomBytes())
+			require.NoError(t, err)
+			private := public.Copy()
+			_ = private.Set(CkaDerive, true)
+
+			key, err := ctx.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P256())
+			require.NoError(t, err)
+			return key.(SignerDeriver)
+		}
+
+		alice := generate()
+		defer func() { _ = alice.Delete() }()
+		bob := generate()
+		defer func() { _ = bob.Delete() }()
+
+		aliceSecret, err := alice.Derive(bob.Public().(*ecdsa.PublicKey))
+		require.NoError(t, err)
+		defer func() { _ = aliceSecret.Delete() }()
+
+		bobSecret, err := bob.Derive(alice.Public().(*ecdsa.PublicKey))
+		require.NoError(t, err)
+		defer func() { _ = bobSecret.Delete() }()
+
+		// The shared secret must not persist on the token
+		isToken, err := aliceSecret.IsToken()
+		require.NoError(t, err)
+		assert.False(t, isToken)
+
+		// Both parties must arrive at the same secret
+		message := randomBytes()
+		var macs [][]byte
+		for _, secret := range []*SecretKey{aliceSecret, bobSecret} {
+			h, err := secret.NewHMAC(pkcs11.CKM_SHA256_HMAC, 0)
+			require.NoError(t, err)
+			_, err = h.Write(message)
+			require.NoError(t, err)
+			macs = append(macs, h.Sum(nil))
+		}
+		assert.Equal(t, macs[0], macs[1])
+
+		other, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+		_, err = alice.Derive(&other.PublicKey)
+		require.Error(t, err)
+	})
+}
+
+func TestParseECPoint(t *testing.T) {
+	for _, curve := range curves {
+		t.Run(curve.Params().Name, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			require.NoError(t, err)
+
+			pub, err := ParseECPoint(curve, elliptic.Marshal(curve, key.X, key.Y))
+			require.NoError(t, err)
+			assert.Equal(t, 0, key.X.Cmp(pub.X))
+			assert.Equal(t, 0, key.Y.Cmp(pub.Y))
+
+			byteLen := (curve.Params().BitSize + 7) / 8
+			compressed := make([]byte, 1+byteLen)
+			compressed[0] = 2 | byte(key.Y.Bit(0))
+			xBytes := key.X.Bytes()
+			copy(compressed[1+byteLen-len(xBytes):], xBytes)
+
+			pub, err = ParseECPoint(curve, compressed)
+			require.NoError(t, err)
+			assert.Equal(t, 0, key.X.Cmp(pub.X))
+			assert.Equal(t, 0, key.Y.Cmp(pub.Y))
+
+			_, err = ParseECPoint(curve, compressed[:byteLen])
+			require.Error(t, err)
+
+			compressed[0] = 5
+			_, err = ParseECPoint(curve, compressed)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestSignWithPinnedSession(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		h := crypto.SHA256.New()
+		h.Write([]byte("pinned"))
+		digest := h.Sum(nil)
+
+		err = key.(SessionPinner).WithSession(func() error {
+			for i := 0; i < 10; i++ {
+				if _, err := key.Sign(rand.Reader, digest, crypto.SHA256); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(0), ctx.PoolStats().InUse)
+	})
+}
+
+func TestSignRaw(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+			key, err := ctx.GenerateECDSAKeyPair(randomBytes(), curve)
+			require.NoError(t, err)
+			defer func(k Signer) { _ = k.Delete() }(key)
+
+			hash := crypto.SHA256.New()
+			_, err = hash.Write([]byte("sign me raw"))
+			require.NoError(t, err)
+			digest := hash.Sum(nil)
+
+			raw, err := key.(RawSigner).SignRaw(digest)
+			require.NoError(t, err)
+
+			size := (curve.Params().BitSize + 7) / 8
+			require.Len(t, raw, 2*size)
+
+			var sig dsaSignature
+			require.NoError(t, sig.unmarshalBytes(raw))
+			assert.True(t, ecdsa.Verify(key.Public().(*ecdsa.PublicKey), digest, sig.R, sig.S))
+
+			// The DER form decodes to a signature in the same format.
+			der, err := sig.marshalDER()
+			require.NoError(t, err)
+			var decoded dsaSignature
+			require.NoError(t, decoded.unmarshalDER(der))
+			reencoded, err := decoded.marshalRaw(size)
+			require.NoError(t, err)
+			assert.Equal(t, raw, reencoded)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/miekg/pkcs11"
+)
+
+// OperationError is returned when the token fails a cryptographic operation using a key. It records which key,
+// mechanism and operation were involved. The underlying error (usually a pkcs11.Error) is available via Unwrap.
+type OperationError struct {
+	// Operation is the operation that failed, e.g. "sign" or "decrypt".
+	Operation string
+
+	// Mechanism is the PKCS#11 mechanism (CKM_...) used for the operation.
+	Mechanism uint
+
+	// KeyID is the hex-encoded CKA_ID of the key. It is empty if the CKA_ID could not be read.
+	KeyID string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// MechanismName returns the name of the mechanism used for the operation.
+func (e *OperationError) MechanismName() string {
+	return mechanismString(e.Mechanism)
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("%s failed using mechanism %s with key id %q: %v", e.Operation, e.MechanismName(), e.KeyID,
+		e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// newOperationError wraps err, returned by the token while performing operation with the given mechanism and key,
+// in an OperationError. The key's CKA_ID is read using session; failure to do so is not reported.
+func newOperationError(session *pkcs11Session, key pkcs11.ObjectHandle, operation string, mechanism uint,
+	err error) error {
+
+	var keyID string
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, nil)}
+	if attributes, attrErr := session.ctx.GetAttributeValue(session.handle, key, template); attrErr == nil {
+		keyID = hex.EncodeToString(attributes[0].Value)
+	}
+
+	return &OperationError{
+		Operation: operation,
+		Mechanism: mechanism,
+		KeyID:     keyID,
+		Err:       err,
+	}
+}
+
+// Errors reported for common PKCS#11 return codes. An error returned by Configure, or by an operation, because the
+// token failed with one of these codes satisfies errors.Is for the corresponding error below. The underlying
+// pkcs11.Error remains available via errors.As.
+var (
+	// ErrPinIncorrect is reported when the token rejects the PIN (CKR_PIN_INCORRECT).
+	ErrPinIncorrect = errors.New("PIN incorrect")
+
+	// ErrPinLocked is reported when the PIN is locked, typically after too many failed logins (CKR_PIN_LOCKED).
+	ErrPinLocked = errors.New("PIN locked")
+
+	// ErrPinExpired is reported when the PIN has expired and must be changed (CKR_PIN_EXPIRED).
+	ErrPinExpired = errors.New("PIN expired")
+
+	// ErrTokenNotPresent is reported when the token is not in its slot (CKR_TOKEN_NOT_PRESENT).
+	ErrTokenNotPresent = errors.New("token not present")
+
+	// ErrDeviceRemoved is reported when the token was removed during an operation (CKR_DEVICE_REMOVED).
+	ErrDeviceRemoved = errors.New("device removed")
+
+	// ErrSignatureInvalid is reported when the token finds a signature invalid (CKR_SIGNATURE_INVALID or
+	// CKR_SIGNATURE_LEN_RANGE).
+	ErrSignatureInvalid = errors.New("signature invalid")
+)
+
+// ErrNotModifiable is reported when an attribute of an object cannot be changed because the object has
+// CKA_MODIFIABLE false. The underlying pkcs11.Error (CKR_ATTRIBUTE_READ_ONLY or CKR_ACTION_PROHIBITED) remains
+// available via errors.As.
+var ErrNotModifiable = errors.New("object not modifiable")
+
+// codeErrors maps PKCS#11 return codes to the errors reported for them.
+var codeErrors = map[pkcs11.Error]error{
+	pkcs11.CKR_PIN_INCORRECT:       ErrPinIncorrect,
+	pkcs11.CKR_PIN_LOCKED:          ErrPinLocked,
+	pkcs11.CKR_PIN_EXPIRED:         ErrPinExpired,
+	pkcs11.CKR_TOKEN_NOT_PRESENT:   ErrTokenNotPresent,
+	pkcs11.CKR_DEVICE_REMOVED:      ErrDeviceRemoved,
+	pkcs11.CKR_SIGNATURE_INVALID:   ErrSignatureInvalid,
+	pkcs11.CKR_SIGNATURE_LEN_RANGE: ErrSignatureInvalid,
+}
+
+// codeError wraps an error caused by a PKCS#11 return code listed in codeErrors, so that errors.Is matches the
+// corresponding error. Its message is that of the wrapped error.
+type codeError struct {
+	err      error
+	code     pkcs11.Error
+	sentinel error
+}
+
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *codeError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true if target is the error reported for the return code.
+func (e *codeError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+// As sets target to the pkcs11.Error, if target is a *pkcs11.Error. This is needed because the wrapped error may
+// reach the pkcs11.Error only via the Cause method used by github.com/pkg/errors, which errors.As does not follow.
+func (e *codeError) As(target interface{}) bool {
+	if p11Err, ok := target.(*pkcs11.Error); ok {
+		*p11Err = e.code
+		return true
+	}
+	return false
+}
+
+// mapPKCS11Error wraps err in a codeError if it is caused by a PKCS#11 return code listed in codeErrors. Otherwise,
+// including if err has already been wrapped, err is returned unchanged.
+func mapPKCS11Error(err error) error {
+	for cause := err; cause != nil; cause = nextCause(cause) {
+		switch e := cause.(type) {
+		case *codeError:
+			return err
+		case pkcs11.Error:
+			if sentinel, ok := codeErrors[e]; ok {
+				return &codeError{err: err, code: e, sentinel: sentinel}
+			}
+			return err
+		}
+	}
+	return err
+}
+
+// isPKCS11Error returns true if err is, or wraps, the PKCS#11 error code. Both Unwrap and the Cause method used by
+// github.com/pkg/errors are followed.
+func isPKCS11Error(err error, code uint) bool {
+	for ; err != nil; err = nextCause(err) {
+		if p11Err, ok := err.(pkcs11.Error); ok {
+			return uint(p11Err) == code
+		}
+	}
+	return false
+}
+
+// nextCause returns the error wrapped by err, found using either Unwrap or the Cause method used by
+// github.com/pkg/errors, or nil if err does not wrap another error.
+func nextCause(err error) error {
+	switch e := err.(type) {
+	case interface{ Cause() error }:
+		return e.Cause()
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	default:
+		return nil
+	}
+}
+
+// traceMechanism reports a successful operation to the configured MechanismTracer, if any.
+func (c *Context) traceMechanism(operation string, mechanism uint) {
+	if c.cfg.MechanismTracer != nil {
+		c.cfg.MechanismTracer(operation, mechanism)
+	}
+}
+
+var mechanismNames = map[uint]string{
+	pkcs11.CKM_RSA_PKCS:            "CKM_RSA_PKCS",
+	pkcs11.CKM_RSA_X_509:           "CKM_RSA_X_509",
+	pkcs11.CKM_RSA_PKCS_OAEP:       "CKM_RSA_PKCS_OAEP",
+	pkcs11.CKM_RSA_PKCS_PSS:        "CKM_RSA_PKCS_PSS",
+	pkcs11.CKM_RSA_AES_KEY_WRAP:    "CKM_RSA_AES_KEY_WRAP",
+	pkcs11.CKM_SHA1_RSA_PKCS:       "CKM_SHA1_RSA_PKCS",
+	pkcs11.CKM_SHA224_RSA_PKCS:     "CKM_SHA224_RSA_PKCS",
+	pkcs11.CKM_SHA256_RSA_PKCS:     "CKM_SHA256_RSA_PKCS",
+	pkcs11.CKM_SHA384_RSA_PKCS:     "CKM_SHA384_RSA_PKCS",
+	pkcs11.CKM_SHA512_RSA_PKCS:     "CKM_SHA512_RSA_PKCS",
+	pkcs11.CKM_SHA1_RSA_PKCS_PSS:   "CKM_SHA1_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA224_RSA_PKCS_PSS: "CKM_SHA224_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA256_RSA_PKCS_PSS: "CKM_SHA256_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA384_RSA_PKCS_PSS: "CKM_SHA384_RSA_PKCS_PSS",
+	pkcs11.CKM_SHA512_RSA_PKCS_PSS: "CKM_SHA512_RSA_PKCS_PSS",
+	pkcs11.CKM_DSA:                 "CKM_DSA",
+	pkcs11.CKM_ECDSA:               "CKM_ECDSA",
+	pkcs11.CKM_ECDH1_DERIVE:        "CKM_ECDH1_DERIVE",
+	CKM_HKDF_DERIVE:                "CKM_HKDF_DERIVE",
+	pkcs11.CKM_AES_ECB:             "CKM_AES_ECB",
+	pkcs11.CKM_AES_CBC:             "CKM_AES_CBC",
+	pkcs11.CKM_AES_CBC_PAD:         "CKM_AES_CBC_PAD",
+	pkcs11.CKM_AES_GCM:             "CKM_AES_GCM",
+	pkcs11.CKM_AES_KEY_WRAP:        "CKM_AES_KEY_WRAP",
+	pkcs11.CKM_AES_KEY_WRAP_PAD:    "CKM_AES_KEY_WRAP_PAD",
+	pkcs11.CKM_DES3_ECB:            "CKM_DES3_ECB",
+	pkcs11.CKM_DES3_CBC:            "CKM_DES3_CBC",
+	pkcs11.CKM_DES3_CBC_PAD:        "CKM_DES3_CBC_PAD",
+
+	pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN: "CKM_RSA_PKCS_KEY_PAIR_GEN",
+	pkcs11.CKM_DSA_KEY_PAIR_GEN:      "CKM_DSA_KEY_PAIR_GEN",
+	pkcs11.CKM_DSA_PARAMETER_GEN:     "CKM_DSA_PARAMETER_GEN",
+	pkcs11.CKM_ECDSA_KEY_PAIR_GEN:    "CKM_EC_KEY_PAIR_GEN",
+	pkcs11.CKM_DH_PKCS_KEY_PAIR_GEN:  "CKM_DH_PKCS_KEY_PAIR_GEN",
+	pkcs11.CKM_DH_PKCS_DERIVE:        "CKM_DH_PKCS_DERIVE",
+}
+
+// mechanismString returns the name of a PKCS#11 mechanism, or its hex value if the name is not known.
+func mechanismString(mechanism uint) string {
+	if name, ok := mechanismNames[mechanism]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#x", mechanism)
+}
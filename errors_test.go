@@ -0,0 +1,101 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperationError(t *testing.T) {
+	var err error = &OperationError{
+		Operation: "sign",
+		Mechanism: pkcs11.CKM_RSA_PKCS_PSS,
+		KeyID:     "0102",
+		Err:       pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID),
+	}
+
+	assert.Equal(t, `sign failed using mechanism CKM_RSA_PKCS_PSS with key id "0102": `+
+		"pkcs11: 0x70: CKR_MECHANISM_INVALID", err.Error())
+
+	var p11Err pkcs11.Error
+	require.True(t, errors.As(err, &p11Err))
+	assert.Equal(t, pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID), p11Err)
+
+	var opErr *OperationError
+	require.True(t, errors.As(err, &opErr))
+	assert.Equal(t, "CKM_RSA_PKCS_PSS", opErr.MechanismName())
+}
+
+func TestMechanismString(t *testing.T) {
+	assert.Equal(t, "CKM_ECDSA", mechanismString(pkcs11.CKM_ECDSA))
+	assert.Equal(t, "0x80000001", mechanismString(0x80000001))
+}
+
+func TestIsPKCS11Error(t *testing.T) {
+	var err error = pkcs11.Error(pkcs11.CKR_SESSION_READ_ONLY)
+	assert.True(t, isPKCS11Error(err, pkcs11.CKR_SESSION_READ_ONLY))
+	assert.False(t, isPKCS11Error(err, pkcs11.CKR_GENERAL_ERROR))
+
+	err = fmt.Errorf("wrapped: %w", &OperationError{Operation: "sign", Err: err})
+	assert.True(t, isPKCS11Error(err, pkcs11.CKR_SESSION_READ_ONLY))
+
+	assert.False(t, isPKCS11Error(errors.New("not a PKCS#11 error"), pkcs11.CKR_SESSION_READ_ONLY))
+	assert.False(t, isPKCS11Error(nil, pkcs11.CKR_SESSION_READ_ONLY))
+}
+
+func TestMapPKCS11Error(t *testing.T) {
+	var err error = pkgerrors.WithMessage(pkcs11.Error(pkcs11.CKR_PIN_INCORRECT), "failed to log in")
+	mapped := mapPKCS11Error(err)
+
+	assert.True(t, errors.Is(mapped, ErrPinIncorrect))
+	assert.False(t, errors.Is(mapped, ErrPinLocked))
+	assert.Equal(t, err.Error(), mapped.Error())
+	assert.True(t, isPKCS11Error(mapped, pkcs11.CKR_PIN_INCORRECT))
+
+	var p11Err pkcs11.Error
+	require.True(t, errors.As(mapped, &p11Err))
+	assert.Equal(t, pkcs11.Error(pkcs11.CKR_PIN_INCORRECT), p11Err)
+
+	// Wrapping twice has no further effect.
+	_, isCodeError := mapPKCS11Error(pkgerrors.WithMessage(mapped, "again")).(*codeError)
+	assert.False(t, isCodeError)
+	wrapped := fmt.Errorf("wrapped: %w", mapped)
+	assert.True(t, mapPKCS11Error(wrapped) == wrapped)
+	assert.True(t, errors.Is(wrapped, ErrPinIncorrect))
+
+	err = &OperationError{Operation: "sign", Err: pkcs11.Error(pkcs11.CKR_DEVICE_REMOVED)}
+	mapped = mapPKCS11Error(err)
+	assert.True(t, errors.Is(mapped, ErrDeviceRemoved))
+	var opErr *OperationError
+	assert.True(t, errors.As(mapped, &opErr))
+
+	err = pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID)
+	assert.Equal(t, err, mapPKCS11Error(err))
+	assert.Nil(t, mapPKCS11Error(nil))
+}
@@ -0,0 +1,97 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// slotState records whether a slot holds a token, and which.
+type slotState struct {
+	present bool
+	serial  string
+}
+
+// WaitForSlotEvent waits until a token is inserted into or removed from any slot of the PKCS#11 library, and returns
+// the ID of the slot concerned. It returns ctx.Err() if ctx is done first.
+//
+// C_WaitForSlotEvent cannot be interrupted once it blocks, and the PKCS#11 wrapper does not report whether a
+// non-blocking call found an event. Instead, the slots are polled every Config.SlotEventPollInterval and compared
+// with their state when WaitForSlotEvent was called. Changes which are undone between polls go unnoticed.
+func (c *Context) WaitForSlotEvent(ctx context.Context) (uint, error) {
+	if c.closed.Get() {
+		return 0, errClosed
+	}
+
+	slots, initial, err := c.slotStates()
+	if err != nil {
+		return 0, err
+	}
+
+	ticker := time.NewTicker(c.cfg.SlotEventPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-ticker.C:
+		}
+
+		if c.closed.Get() {
+			return 0, errClosed
+		}
+
+		current, states, err := c.slotStates()
+		if err != nil {
+			return 0, err
+		}
+
+		// Check the slots present at the start, then any new ones
+		for _, slot := range append(slots, current...) {
+			if initial[slot] != states[slot] {
+				return slot, nil
+			}
+		}
+	}
+}
+
+// slotStates returns the slots of the PKCS#11 library and the state of each.
+func (c *Context) slotStates() ([]uint, map[uint]slotState, error) {
+	slots, err := c.ctx.GetSlotList(false)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed to list PKCS#11 slots")
+	}
+
+	states := make(map[uint]slotState, len(slots))
+	for _, slot := range slots {
+		var state slotState
+		if tokenInfo, err := c.ctx.GetTokenInfo(slot); err == nil {
+			state = slotState{present: true, serial: tokenInfo.SerialNumber}
+		}
+		states[slot] = state
+	}
+	return slots, states, nil
+}
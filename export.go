@@ -0,0 +1,138 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto/rsa"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// KeyNotExportableError is returned when key material cannot be exported from the token. Export is only permitted
+// for keys created with CKA_SENSITIVE false and CKA_EXTRACTABLE true, which the token's policy may forbid.
+type KeyNotExportableError struct {
+	// Sensitive is true if the key has CKA_SENSITIVE set, so the token will not reveal its value.
+	Sensitive bool
+
+	// Extractable is true if the key has CKA_EXTRACTABLE set.
+	Extractable bool
+}
+
+func (e *KeyNotExportableError) Error() string {
+	if e.Sensitive {
+		return "key cannot be exported: it is sensitive (CKA_SENSITIVE is true)"
+	}
+	return "key cannot be exported: it is not extractable (CKA_EXTRACTABLE is false)"
+}
+
+// RSAPrivateKeyExporter is implemented by the RSA keys returned by this package. See pkcs11PrivateKeyRSA.ExportPrivate.
+type RSAPrivateKeyExporter interface {
+	// ExportPrivate reads the private key from the token.
+	ExportPrivate() (*rsa.PrivateKey, error)
+}
+
+// exportAttributes reads attrs from the object, provided it may be exported. A *KeyNotExportableError is returned
+// unless the object has CKA_SENSITIVE false and CKA_EXTRACTABLE true.
+func (o *pkcs11Object) exportAttributes(attrs []uint) ([]*pkcs11.Attribute, error) {
+	flags, err := o.Attributes([]uint{pkcs11.CKA_SENSITIVE, pkcs11.CKA_EXTRACTABLE})
+	if err != nil {
+		return nil, err
+	}
+	sensitive := len(flags[0].Value) == 1 && flags[0].Value[0] != 0
+	extractable := len(flags[1].Value) == 1 && flags[1].Value[0] != 0
+	if sensitive || !extractable {
+		return nil, &KeyNotExportableError{Sensitive: sensitive, Extractable: extractable}
+	}
+
+	attributes, err := o.Attributes(attrs)
+	if isPKCS11Error(err, pkcs11.CKR_ATTRIBUTE_SENSITIVE) {
+		// The token refuses regardless of the key's attributes.
+		return nil, &KeyNotExportableError{Sensitive: true, Extractable: extractable}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// Export reads the value of the secret key (CKA_VALUE) from the token. It is intended for recovering keys created
+// for the purpose, with CKA_SENSITIVE false and CKA_EXTRACTABLE true (see KeyGenOptions); for any other key, a
+// *KeyNotExportableError is returned. Exporting keys in the clear defeats the protection given by the token, so the
+// token's policy may forbid creating such keys at all.
+func (key *SecretKey) Export() ([]byte, error) {
+	if key.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	attributes, err := key.exportAttributes([]uint{pkcs11.CKA_VALUE})
+	if err != nil {
+		return nil, err
+	}
+	return attributes[0].Value, nil
+}
+
+// ExportPrivate reads the private key from the token. As with SecretKey.Export, it is intended for recovering keys
+// created for the purpose, with CKA_SENSITIVE false and CKA_EXTRACTABLE true; for any other key, a
+// *KeyNotExportableError is returned. The token must hold the prime factors of the modulus.
+func (priv *pkcs11PrivateKeyRSA) ExportPrivate() (*rsa.PrivateKey, error) {
+	if priv.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	attributes, err := priv.exportAttributes([]uint{
+		pkcs11.CKA_MODULUS,
+		pkcs11.CKA_PUBLIC_EXPONENT,
+		pkcs11.CKA_PRIVATE_EXPONENT,
+		pkcs11.CKA_PRIME_1,
+		pkcs11.CKA_PRIME_2,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(attributes[3].Value) == 0 || len(attributes[4].Value) == 0 {
+		return nil, errors.New("token did not return the prime factors of the RSA key")
+	}
+
+	e := new(big.Int).SetBytes(attributes[1].Value)
+	if !e.IsInt64() || e.Int64() > maxPublicExponent {
+		return nil, errors.New("RSA public exponent is too large")
+	}
+
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{
+			N: new(big.Int).SetBytes(attributes[0].Value),
+			E: int(e.Int64()),
+		},
+		D: new(big.Int).SetBytes(attributes[2].Value),
+		Primes: []*big.Int{
+			new(big.Int).SetBytes(attributes[3].Value),
+			new(big.Int).SetBytes(attributes[4].Value),
+		},
+	}
+	if err = key.Validate(); err != nil {
+		return nil, errors.WithMessage(err, "exported RSA key is invalid")
+	}
+	key.Precompute()
+	return key, nil
+}
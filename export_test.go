@@ -0,0 +1,97 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto/aes"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportSecretKey(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		sensitive, extractable := false, true
+		opts := KeyGenOptions{Sensitive: &sensitive, Extractable: &extractable}
+
+		key, err := ctx.GenerateSecretKeyWithOptions(randomBytes(), nil, 128, CipherAES, opts)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		value, err := key.Export()
+		require.NoError(t, err)
+		require.Len(t, value, 16)
+
+		// The exported value must be the key the token uses
+		block, err := aes.NewCipher(value)
+		require.NoError(t, err)
+		plaintext := make([]byte, 16)
+		expected := make([]byte, 16)
+		block.Encrypt(expected, plaintext)
+		actual := make([]byte, 16)
+		key.Encrypt(actual, plaintext)
+		assert.Equal(t, expected, actual)
+
+		protected, err := ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = protected.Delete() }()
+
+		_, err = protected.Export()
+		require.Error(t, err)
+		notExportable, ok := err.(*KeyNotExportableError)
+		require.True(t, ok)
+		assert.True(t, notExportable.Sensitive)
+	})
+}
+
+func TestExportRSAPrivateKey(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN)
+
+		sensitive, extractable := false, true
+		opts := KeyGenOptions{Sensitive: &sensitive, Extractable: &extractable}
+
+		key, err := ctx.GenerateRSAKeyPairWithOptions(randomBytes(), nil, rsaSize, opts)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		priv, err := key.(RSAPrivateKeyExporter).ExportPrivate()
+		require.NoError(t, err)
+		require.True(t, publicKeysEqual(key.Public(), &priv.PublicKey))
+		require.NoError(t, priv.Validate())
+
+		protected, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = protected.Delete() }()
+
+		_, err = protected.(RSAPrivateKeyExporter).ExportPrivate()
+		_, ok := err.(*KeyNotExportableError)
+		require.True(t, ok, "%v", err)
+	})
+}
+
+func TestKeyNotExportableError(t *testing.T) {
+	assert.Contains(t, (&KeyNotExportableError{Sensitive: true}).Error(), "CKA_SENSITIVE")
+	assert.Contains(t, (&KeyNotExportableError{Extractable: false}).Error(), "CKA_EXTRACTABLE")
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+/*
+#include <stdlib.h>
+
+// hkdfParams mirrors CK_HKDF_PARAMS from PKCS#11 v3.0, which the PKCS#11 wrapper does not provide.
+typedef struct {
+	unsigned char bExtract;
+	unsigned char bExpand;
+	unsigned long prfHashMechanism;
+	unsigned long ulSaltType;
+	unsigned char *pSalt;
+	unsigned long ulSaltLen;
+	unsigned long hSaltKey;
+	unsigned char *pInfo;
+	unsigned long ulInfoLen;
+} hkdfParams;
+*/
+import "C"
+
+import (
+	"crypto"
+	"unsafe"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CKM_HKDF_DERIVE is the PKCS#11 v3.0 HKDF key derivation mechanism.
+	CKM_HKDF_DERIVE = 0x0000402a
+
+	// Salt types for CK_HKDF_PARAMS
+	ckfHKDFSaltNull = 0x00000001
+	ckfHKDFSaltData = 0x00000002
+)
+
+// ErrHKDFUnsupported is returned by HKDFDerive if the token does not advertise CKM_HKDF_DERIVE. Callers may fall back
+// to performing HKDF in software, if the base key can be extracted.
+var ErrHKDFUnsupported = errors.New("token does not support CKM_HKDF_DERIVE")
+
+// HKDFDerive derives a new key from the secret key using HKDF (RFC 5869), extracting with salt and expanding with info
+// to length bytes. The hash function selects the underlying HMAC, e.g. crypto.SHA256. The derivation is performed on
+// the token using CKM_HKDF_DERIVE; if the token does not support it, ErrHKDFUnsupported is returned.
+//
+// The secret key must have CKA_DERIVE set, as keys returned by Derive do. The new key is a generic secret session key,
+// which is destroyed when the Context is closed.
+func (key *SecretKey) HKDFDerive(hash crypto.Hash, salt, info []byte, length int) (*SecretKey, error) {
+	if key.context.closed.Get() {
+		return nil, errClosed
+	}
+
+	if length <= 0 {
+		return nil, errors.New("length must be positive")
+	}
+	hashMech, _, hashLen, err := hashToPKCS11(hash)
+	if err != nil {
+		return nil, errors.Errorf("unsupported hash function for HKDF: %v", hash)
+	}
+	if length > 255*int(hashLen) {
+		return nil, errors.Errorf("HKDF output is limited to %d bytes with this hash", 255*hashLen)
+	}
+
+	supported, err := key.context.mechanismSupported(CKM_HKDF_DERIVE)
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		return nil, ErrHKDFUnsupported
+	}
+
+	params, free := newHKDFParams(hashMech, salt, info)
+	defer free()
+	mech := pkcs11.NewMechanism(CKM_HKDF_DERIVE, params)
+
+	return key.context.deriveSecretKey(key.handle, mech, length)
+}
+
+// newHKDFParams returns an encoded CK_HKDF_PARAMS for extract-and-expand HKDF. The salt and info are copied to C
+// memory, which must be released by calling free once the mechanism has been used.
+func newHKDFParams(hashMech uint, salt, info []byte) (params []byte, free func()) {
+	var p C.hkdfParams
+	p.bExtract = C.uchar(1)
+	p.bExpand = C.uchar(1)
+	p.prfHashMechanism = C.ulong(hashMech)
+
+	if len(salt) > 0 {
+		p.ulSaltType = C.ulong(ckfHKDFSaltData)
+		p.pSalt = (*C.uchar)(C.CBytes(salt))
+		p.ulSaltLen = C.ulong(len(salt))
+	} else {
+		p.ulSaltType = C.ulong(ckfHKDFSaltNull)
+	}
+
+	if len(info) > 0 {
+		p.pInfo = (*C.uchar)(C.CBytes(info))
+		p.ulInfoLen = C.ulong(len(info))
+	}
+
+	free = func() {
+		C.free(unsafe.Pointer(p.pSalt))
+		C.free(unsafe.Pointer(p.pInfo))
+	}
+	return C.GoBytes(unsafe.Pointer(&p), C.sizeof_hkdfParams), free
+}
@@ -0,0 +1,90 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHKDFDerive(t *testing.T) {
+	// RFC 5869, test case 1
+	ikm, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	okm, _ := hex.DecodeString("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+
+	withContext(t, func(ctx *Context) {
+		var handle pkcs11.ObjectHandle
+		err := ctx.withRWSession(func(session *pkcs11Session) (err error) {
+			handle, err = session.ctx.CreateObject(session.handle, []*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+				pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+				pkcs11.NewAttribute(pkcs11.CKA_VALUE, ikm),
+			})
+			return
+		})
+		require.NoError(t, err)
+		base := &SecretKey{pkcs11Object{handle, ctx}, CipherGeneric}
+		defer func() { _ = base.Delete() }()
+
+		derived, err := base.HKDFDerive(crypto.SHA256, salt, info, len(okm))
+		if err == ErrHKDFUnsupported {
+			t.Skip(err)
+		}
+		require.NoError(t, err)
+		defer func() { _ = derived.Delete() }()
+
+		// The derived key is not extractable, so compare HMACs made with it and with the expected output
+		message := []byte("HKDF test")
+		h, err := derived.NewHMAC(pkcs11.CKM_SHA256_HMAC, 0)
+		require.NoError(t, err)
+		_, err = h.Write(message)
+		require.NoError(t, err)
+
+		expected := hmac.New(sha256.New, okm)
+		_, err = expected.Write(message)
+		require.NoError(t, err)
+		require.Equal(t, expected.Sum(nil), h.Sum(nil))
+	})
+}
+
+func TestHKDFDeriveArgs(t *testing.T) {
+	key := &SecretKey{pkcs11Object{0, &Context{cfg: &Config{}}}, CipherGeneric}
+
+	_, err := key.HKDFDerive(crypto.SHA256, nil, nil, 0)
+	require.Error(t, err)
+
+	_, err = key.HKDFDerive(crypto.SHA256, nil, nil, 255*32+1)
+	require.Error(t, err)
+
+	_, err = key.HKDFDerive(crypto.MD5, nil, nil, 32)
+	require.Error(t, err)
+}
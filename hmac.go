@@ -22,7 +22,9 @@
 package crypto11
 
 import (
+	"crypto"
 	"errors"
+	"fmt"
 	"hash"
 
 	"github.com/miekg/pkcs11"
@@ -105,11 +107,51 @@ var hmacInfos = map[int]*hmacInfo{
 	pkcs11.CKM_SHA512_256_HMAC_GENERAL: {32, 128, true},
 	pkcs11.CKM_RIPEMD160_HMAC:          {20, 64, false},
 	pkcs11.CKM_RIPEMD160_HMAC_GENERAL:  {20, 64, true},
+
+	// CMAC is computed in the same way as HMAC.
+	pkcs11.CKM_AES_CMAC:         {16, 16, false},
+	pkcs11.CKM_AES_CMAC_GENERAL: {16, 16, true},
 }
 
 // errHmacClosed is called if an HMAC is updated after it has finished.
 var errHmacClosed = errors.New("already called Sum()")
 
+// hmacMechanisms maps hash functions to the corresponding PKCS#11 HMAC mechanism.
+var hmacMechanisms = map[crypto.Hash]int{
+	crypto.MD5:        pkcs11.CKM_MD5_HMAC,
+	crypto.SHA1:       pkcs11.CKM_SHA_1_HMAC,
+	crypto.SHA224:     pkcs11.CKM_SHA224_HMAC,
+	crypto.SHA256:     pkcs11.CKM_SHA256_HMAC,
+	crypto.SHA384:     pkcs11.CKM_SHA384_HMAC,
+	crypto.SHA512:     pkcs11.CKM_SHA512_HMAC,
+	crypto.SHA512_224: pkcs11.CKM_SHA512_224_HMAC,
+	crypto.SHA512_256: pkcs11.CKM_SHA512_256_HMAC,
+	crypto.RIPEMD160:  pkcs11.CKM_RIPEMD160_HMAC,
+}
+
+// NewHMACWithHash returns a new HMAC hash using the given key and the PKCS#11 HMAC mechanism for hashFunction,
+// e.g. CKM_SHA256_HMAC for crypto.SHA256. Data written is streamed to the token. See NewHMAC for limitations.
+func (key *SecretKey) NewHMACWithHash(hashFunction crypto.Hash) (hash.Hash, error) {
+	mech, ok := hmacMechanisms[hashFunction]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function for HMAC: %v", hashFunction)
+	}
+	return key.NewHMAC(mech, 0)
+}
+
+// NewCMAC returns a new AES-CMAC (NIST SP 800-38B) hash using the given key and CKM_AES_CMAC, producing a 16-byte
+// tag. Data written is streamed to the token. As with NewHMAC, Reset() is not implemented and no new data may be
+// added after Sum() is called.
+//
+// The key must be an AES key with CKA_SIGN set. AES keys are generated without it by default, so set CkaSign in the
+// template given to GenerateSecretKeyWithAttributes.
+func (key *SecretKey) NewCMAC() (hash.Hash, error) {
+	if key.Cipher == nil || !isAES(key.Cipher) {
+		return nil, errors.New("CMAC requires an AES key")
+	}
+	return key.NewHMAC(pkcs11.CKM_AES_CMAC, 0)
+}
+
 // NewHMAC returns a new HMAC hash using the given PKCS#11 mechanism
 // and key.
 // length specifies the output size, for _GENERAL mechanisms.
@@ -170,6 +212,11 @@ func (hi *hmacImplementation) Write(p []byte) (n int, err error) {
 		}
 		return
 	}
+	if hi.session == nil || hi.key.context.closed.Get() {
+		// Release the session, so that closing the Context is not blocked by this HMAC.
+		hi.abandon()
+		return 0, errClosed
+	}
 	if err = hi.session.ctx.SignUpdate(hi.session.handle, p); err != nil {
 		return
 	}
@@ -180,6 +227,11 @@ func (hi *hmacImplementation) Write(p []byte) (n int, err error) {
 
 func (hi *hmacImplementation) Sum(b []byte) []byte {
 	if hi.result == nil {
+		if hi.session == nil || hi.key.context.closed.Get() {
+			hi.abandon()
+			panic(errClosed)
+		}
+
 		var err error
 		if hi.updates == 0 {
 			// http://docs.oasis-open.org/pkcs11/pkcs11-base/v2.40/os/pkcs11-base-v2.40-os.html#_Toc322855304
@@ -197,6 +249,13 @@ func (hi *hmacImplementation) Sum(b []byte) []byte {
 	return append(b, hi.result...)
 }
 
+// abandon releases the session, if still held, without finishing the HMAC.
+func (hi *hmacImplementation) abandon() {
+	if hi.session != nil {
+		hi.cleanup()
+	}
+}
+
 func (hi *hmacImplementation) Reset() {
 	hi.Sum(nil) // Clean up
 
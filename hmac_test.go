@@ -22,7 +22,14 @@
 package crypto11
 
 import (
+	"crypto"
+	"crypto/hmac"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
 	"testing"
+	"time"
 
 	"github.com/miekg/pkcs11"
 	"github.com/stretchr/testify/require"
@@ -157,3 +164,136 @@ func testHmac(t *testing.T, ctx *Context, keytype int, mech int, length int, xle
 		})
 	}
 }
+
+func TestHmacWithHashMatchesSoftware(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_SHA256_HMAC)
+
+		knownKey := []byte("0123456789abcdef0123456789abcdef")
+
+		// Import the known key, so the software HMAC can use it too
+		var handle pkcs11.ObjectHandle
+		err := ctx.withRWSession(func(session *pkcs11Session) (err error) {
+			handle, err = session.ctx.CreateObject(session.handle, []*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+				pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+				pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+				pkcs11.NewAttribute(pkcs11.CKA_VALUE, knownKey),
+			})
+			return
+		})
+		require.NoError(t, err)
+		key := &SecretKey{pkcs11Object{handle, ctx}, CipherGeneric}
+		defer func() { _ = key.Delete() }()
+
+		for _, hashFunction := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA512} {
+			h1, err := key.NewHMACWithHash(hashFunction)
+			require.NoError(t, err)
+			h2 := hmac.New(hashFunction.New, knownKey)
+
+			for _, chunk := range []string{"request line\n", "headers\n", "body"} {
+				_, err = h1.Write([]byte(chunk))
+				require.NoError(t, err)
+				_, _ = h2.Write([]byte(chunk))
+			}
+
+			require.Equal(t, h2.Sum(nil), h1.Sum(nil), "%v", hashFunction)
+		}
+
+		_, err = key.NewHMACWithHash(crypto.SHA3_256)
+		require.Error(t, err)
+	})
+}
+
+func TestHmacAfterClose(t *testing.T) {
+	ctx, err := ConfigureFromFile("config")
+	require.NoError(t, err)
+
+	skipIfMechUnsupported(t, ctx, pkcs11.CKM_SHA256_HMAC)
+
+	// A session object is used, as it is destroyed when the Context is closed
+	template, err := NewAttributeSetWithID(randomBytes())
+	require.NoError(t, err)
+	require.NoError(t, template.Set(CkaToken, false))
+
+	key, err := ctx.GenerateSecretKeyWithAttributes(template, 256, CipherHMACSHA256)
+	require.NoError(t, err)
+
+	h, err := key.NewHMACWithHash(crypto.SHA256)
+	require.NoError(t, err)
+
+	_, err = h.Write([]byte("data"))
+	require.NoError(t, err)
+
+	// Close blocks until the HMAC's session is released by the next Write
+	closed := make(chan error)
+	go func() { closed <- ctx.Close() }()
+	for !ctx.closed.Get() {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = h.Write([]byte("more data"))
+	require.Equal(t, errClosed, err)
+	require.NoError(t, <-closed)
+}
+
+func TestCMAC(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_AES_CMAC)
+
+		unhex := func(s string) []byte {
+			b, err := hex.DecodeString(s)
+			require.NoError(t, err)
+			return b
+		}
+
+		// Test vectors for AES-128 from NIST SP 800-38B, appendix D.1
+		knownKey := unhex("2b7e151628aed2a6abf7158809cf4f3c")
+		message := unhex("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e51" +
+			"30c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+		vectors := []struct {
+			length int
+			tag    string
+		}{
+			{0, "bb1d6929e95937287fa37d129b756746"},
+			{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+			{40, "dfa66747de9ae63030ca32611497c827"},
+			{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+		}
+
+		var handle pkcs11.ObjectHandle
+		err := ctx.withRWSession(func(session *pkcs11Session) (err error) {
+			handle, err = session.ctx.CreateObject(session.handle, []*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+				pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+				pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_VALUE, knownKey),
+			})
+			return
+		})
+		require.NoError(t, err)
+		key := &SecretKey{pkcs11Object{handle, ctx}, CipherAES}
+		defer func() { _ = key.Delete() }()
+
+		for _, vector := range vectors {
+			h, err := key.NewCMAC()
+			require.NoError(t, err)
+			require.Equal(t, 16, h.Size())
+
+			// Write in two pieces, to exercise streaming
+			data := message[:vector.length]
+			_, err = h.Write(data[:len(data)/2])
+			require.NoError(t, err)
+			_, err = h.Write(data[len(data)/2:])
+			require.NoError(t, err)
+			require.Equal(t, unhex(vector.tag), h.Sum(nil), "length %d", vector.length)
+		}
+
+		generic := &SecretKey{pkcs11Object{handle, ctx}, CipherGeneric}
+		_, err = generic.NewCMAC()
+		require.Error(t, err)
+	})
+}
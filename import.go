@@ -0,0 +1,329 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// ImportKeyPair imports an existing private key, and its public key, into the token. RSA (*rsa.PrivateKey) and ECDSA
+// (*ecdsa.PrivateKey) keys are supported. The id parameter is used to set CKA_ID and must be non-nil. If label is
+// non-nil, it is used to set CKA_LABEL.
+//
+// The private key is imported as sensitive and non-extractable. Use ImportKeyPairWithAttributes to choose otherwise.
+func (c *Context) ImportKeyPair(priv crypto.PrivateKey, id, label []byte) (Signer, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	var public AttributeSet
+	var err error
+	if label == nil {
+		public, err = NewAttributeSetWithID(id)
+	} else {
+		public, err = NewAttributeSetWithIDAndLabel(id, label)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Copy the AttributeSet to allow modifications.
+	private := public.Copy()
+
+	return c.ImportKeyPairWithAttributes(priv, public, private)
+}
+
+// ImportKeyPairWithAttributes imports an existing private key, and its public key, into the token. RSA
+// (*rsa.PrivateKey) and ECDSA (*ecdsa.PrivateKey) keys are supported. The key material is added to the templates. If
+// other required attributes are missing, they will be set to a default value; in particular, the private key is
+// sensitive and non-extractable unless CKA_SENSITIVE and CKA_EXTRACTABLE are set in private.
+//
+// Some tokens do not permit private keys to be imported in plaintext, in which case an error is returned.
+func (c *Context) ImportKeyPairWithAttributes(priv crypto.PrivateKey, public, private AttributeSet) (Signer, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	var pub crypto.PublicKey
+	var err error
+
+	switch key := priv.(type) {
+	case *rsa.PrivateKey:
+		pub = &key.PublicKey
+		err = rsaImportTemplates(key, public, private)
+	case *ecdsa.PrivateKey:
+		pub = &key.PublicKey
+		err = ecdsaImportTemplates(key, public, private)
+	default:
+		return nil, errors.Errorf("unsupported private key type %T", priv)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	public.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	})
+	private.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	})
+
+	var k Signer
+	err = c.withRWSession(func(session *pkcs11Session) error {
+		pubHandle, err := session.ctx.CreateObject(session.handle, public.ToSlice())
+		if err != nil {
+			return errors.WithMessage(explainImportError(err), "failed to import public key")
+		}
+
+		privHandle, err := session.ctx.CreateObject(session.handle, private.ToSlice())
+		if err != nil {
+			_ = session.ctx.DestroyObject(session.handle, pubHandle)
+			return errors.WithMessage(explainImportError(err), "failed to import private key")
+		}
+
+		key := pkcs11PrivateKey{
+			pkcs11Object: pkcs11Object{
+				handle:  privHandle,
+				context: c,
+			},
+			pubKeyHandle: pubHandle,
+			pubKey:       pub,
+		}
+		switch pub.(type) {
+		case *rsa.PublicKey:
+			key.identity = templateIdentity(private, pkcs11.CKK_RSA)
+			k = &pkcs11PrivateKeyRSA{key}
+		default:
+			key.identity = templateIdentity(private, pkcs11.CKK_ECDSA)
+			k = &pkcs11PrivateKeyECDSA{key}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ImportPublicKey imports a public key into the token, without a private key, for use in verification. RSA
+// (*rsa.PublicKey) and ECDSA (*ecdsa.PublicKey) keys are supported. The id parameter is used to set CKA_ID and must be
+// non-nil. If label is non-nil, it is used to set CKA_LABEL. Use FindPublicKey to retrieve the key.
+func (c *Context) ImportPublicKey(id, label []byte, pub crypto.PublicKey) error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	public, err := newKeyAttributeSet(id, label)
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		rsaPublicImportTemplate(key, public)
+	case *ecdsa.PublicKey:
+		err = ecdsaPublicImportTemplate(key, public)
+	default:
+		return errors.Errorf("unsupported public key type %T", pub)
+	}
+	if err != nil {
+		return err
+	}
+
+	public.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	})
+
+	return c.withRWSession(func(session *pkcs11Session) error {
+		_, err := session.ctx.CreateObject(session.handle, public.ToSlice())
+		return errors.WithMessage(err, "failed to import public key")
+	})
+}
+
+// ImportSecretKey imports the value of a secret key into the token, for example an HMAC key shared with another
+// system. The keyType parameter gives CKA_KEY_TYPE (CKK_...), which must be one of those in Ciphers, such as
+// CKK_GENERIC_SECRET. The id parameter is used to set CKA_ID and must be non-nil. If label is non-nil, it is used to
+// set CKA_LABEL.
+//
+// The key is imported as sensitive and non-extractable. Some tokens do not permit secret keys to be imported in
+// plaintext, in which case an error is returned; use ImportWrappedSecretKey instead.
+func (c *Context) ImportSecretKey(id, label []byte, keyType uint, value []byte) (*SecretKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if len(value) == 0 {
+		return nil, errors.New("secret key value must not be empty")
+	}
+
+	cipher, ok := Ciphers[int(keyType)]
+	if !ok {
+		return nil, errors.Errorf("unsupported key type: %X", keyType)
+	}
+
+	template, err := newKeyAttributeSet(id, label)
+	if err != nil {
+		return nil, err
+	}
+
+	template.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, keyType),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, cipher.MAC),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, cipher.MAC),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, cipher.Encrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, cipher.Encrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, value),
+	})
+
+	var k *SecretKey
+	err = c.withRWSession(func(session *pkcs11Session) error {
+		handle, err := session.ctx.CreateObject(session.handle, template.ToSlice())
+		if err != nil {
+			return errors.WithMessage(explainImportError(err), "failed to import secret key")
+		}
+		k = &SecretKey{pkcs11Object{handle, c}, cipher}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// explainImportError adds an explanation to errors that tokens commonly return when refusing to import private keys.
+func explainImportError(err error) error {
+	if isPKCS11Error(err, pkcs11.CKR_TEMPLATE_INCONSISTENT) {
+		return errors.WithMessage(err, "the token may not permit plaintext key import")
+	}
+	return err
+}
+
+// rsaImportTemplates adds the material of an RSA key to the import templates.
+func rsaImportTemplates(key *rsa.PrivateKey, public, private AttributeSet) error {
+	if len(key.Primes) != 2 {
+		return errors.New("multi-prime RSA keys are not supported")
+	}
+	key.Precompute()
+
+	exponent := big.NewInt(int64(key.E)).Bytes()
+
+	rsaPublicImportTemplate(&key.PublicKey, public)
+	private.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+	})
+
+	setAttributes(private, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, exponent),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE_EXPONENT, key.D.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, key.Primes[0].Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_2, key.Primes[1].Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_1, key.Precomputed.Dp.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_2, key.Precomputed.Dq.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_COEFFICIENT, key.Precomputed.Qinv.Bytes()),
+	})
+	return nil
+}
+
+// rsaPublicImportTemplate adds the material of an RSA public key to the import template.
+func rsaPublicImportTemplate(key *rsa.PublicKey, public AttributeSet) {
+	public.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+	})
+
+	setAttributes(public, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(int64(key.E)).Bytes()),
+	})
+}
+
+// ecdsaImportTemplates adds the material of an ECDSA key to the import templates.
+func ecdsaImportTemplates(key *ecdsa.PrivateKey, public, private AttributeSet) error {
+	if err := ecdsaPublicImportTemplate(&key.PublicKey, public); err != nil {
+		return err
+	}
+
+	parameters, err := marshalEcParams(key.Curve)
+	if err != nil {
+		return err
+	}
+
+	// CKA_VALUE is the private scalar, padded to the size of the curve order
+	value := make([]byte, (key.Curve.Params().N.BitLen()+7)/8)
+	d := key.D.Bytes()
+	copy(value[len(value)-len(d):], d)
+
+	setAttributes(private, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, parameters),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, value),
+	})
+	return nil
+}
+
+// ecdsaPublicImportTemplate adds the material of an ECDSA public key to the import template.
+func ecdsaPublicImportTemplate(key *ecdsa.PublicKey, public AttributeSet) error {
+	parameters, err := marshalEcParams(key.Curve)
+	if err != nil {
+		return err
+	}
+
+	point, err := asn1.Marshal(elliptic.Marshal(key.Curve, key.X, key.Y))
+	if err != nil {
+		return err
+	}
+
+	setAttributes(public, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, parameters),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, point),
+	})
+	return nil
+}
+
+// setAttributes stores the attributes in set, overwriting any existing values.
+func setAttributes(set AttributeSet, attributes []*pkcs11.Attribute) {
+	for _, a := range attributes {
+		set[a.Type] = a
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportRSAKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		priv, err := rsa.GenerateKey(rand.Reader, rsaSize)
+		require.NoError(t, err)
+
+		id := randomBytes()
+		label := randomBytes()
+
+		key, err := ctx.ImportKeyPair(priv, id, label)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		require.Equal(t, &priv.PublicKey, key.Public())
+
+		found, err := ctx.FindKeyPair(id, label)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		testRsaSigning(t, found.(crypto.Signer), false)
+	})
+}
+
+func TestImportECDSAKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		id := randomBytes()
+
+		key, err := ctx.ImportKeyPair(priv, id, nil)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		found, err := ctx.FindKeyPair(id, nil)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		testEcdsaSigning(t, found.(crypto.Signer), crypto.SHA256, "P-256", "SHA-256")
+	})
+}
+
+func TestImportUnsupportedKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		_, err = ctx.ImportKeyPair(priv, randomBytes(), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestImportPublicKey(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, rsaSize)
+		require.NoError(t, err)
+		ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		label := randomBytes()
+		defer func() { _, _ = ctx.DeleteObjectsByLabelPrefix(label) }()
+
+		for _, pub := range []crypto.PublicKey{&rsaKey.PublicKey, &ecdsaKey.PublicKey} {
+			id := randomBytes()
+			require.NoError(t, ctx.ImportPublicKey(id, label, pub))
+
+			found, err := ctx.FindPublicKey(id, nil)
+			require.NoError(t, err)
+			require.NotNil(t, found)
+			require.True(t, publicKeysEqual(pub, found))
+
+			// There is no private key, so there is no key pair.
+			pair, err := ctx.FindKeyPair(id, nil)
+			require.NoError(t, err)
+			require.Nil(t, pair)
+		}
+
+		found, err := ctx.FindPublicKey(randomBytes(), nil)
+		require.NoError(t, err)
+		require.Nil(t, found)
+
+		_, err = ctx.FindPublicKey(nil, nil)
+		require.Error(t, err)
+
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		require.Error(t, ctx.ImportPublicKey(randomBytes(), nil, pub))
+	})
+}
+
+func TestImportSecretKey(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		value := make([]byte, 32)
+		_, err := rand.Read(value)
+		require.NoError(t, err)
+
+		id := randomBytes()
+		key, err := ctx.ImportSecretKey(id, []byte("imported"), pkcs11.CKK_GENERIC_SECRET, value)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		found, err := ctx.FindKey(id, nil)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+
+		_, err = ctx.ImportSecretKey(randomBytes(), nil, pkcs11.CKK_GENERIC_SECRET, nil)
+		require.Error(t, err)
+
+		_, err = ctx.ImportSecretKey(randomBytes(), nil, 0xFFFF, value)
+		require.Error(t, err)
+
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_SHA256_HMAC)
+
+		h1, err := key.NewHMACWithHash(crypto.SHA256)
+		require.NoError(t, err)
+		h2 := hmac.New(crypto.SHA256.New, value)
+
+		_, err = h1.Write([]byte("data"))
+		require.NoError(t, err)
+		_, _ = h2.Write([]byte("data"))
+		require.Equal(t, h2.Sum(nil), h1.Sum(nil))
+	})
+}
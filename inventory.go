@@ -0,0 +1,295 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// TokenInventory describes the objects on a token. It contains metadata only: key material and other object values
+// are never included. It can be serialised with encoding/json.
+type TokenInventory struct {
+	Keys         []KeyInventory         `json:"keys"`
+	Certificates []CertificateInventory `json:"certificates"`
+	DataObjects  []DataObjectInventory  `json:"dataObjects"`
+}
+
+// KeyInventory describes a private, public or secret key.
+type KeyInventory struct {
+	// Class is "private", "public" or "secret".
+	Class string `json:"class"`
+
+	// KeyType is the PKCS#11 key type (CKK_...).
+	KeyType uint `json:"keyType"`
+
+	// ID and Label are the CKA_ID (hex-encoded) and CKA_LABEL of the key.
+	ID    string `json:"id"`
+	Label string `json:"label"`
+
+	// Bits is the size of RSA and secret keys. It is zero if the size is not known.
+	Bits int `json:"bits,omitempty"`
+
+	// Curve is the name of the curve of EC keys, if known.
+	Curve string `json:"curve,omitempty"`
+
+	// Usage lists the operations permitted by the key, e.g. "sign" or "unwrap".
+	Usage []string `json:"usage"`
+
+	// StartDate and EndDate are the CKA_START_DATE and CKA_END_DATE of the key in YYYYMMDD form, if set.
+	StartDate string `json:"startDate,omitempty"`
+	EndDate   string `json:"endDate,omitempty"`
+}
+
+// CertificateInventory describes an X.509 certificate.
+type CertificateInventory struct {
+	ID       string    `json:"id"`
+	Label    string    `json:"label"`
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"notAfter"`
+
+	// Error is set if the certificate could not be parsed, in which case only ID and Label are filled in.
+	Error string `json:"error,omitempty"`
+}
+
+// DataObjectInventory describes a data object. The object's value is not included.
+type DataObjectInventory struct {
+	Label       string `json:"label"`
+	Application string `json:"application"`
+}
+
+var keyClassNames = map[uint]string{
+	pkcs11.CKO_PRIVATE_KEY: "private",
+	pkcs11.CKO_PUBLIC_KEY:  "public",
+	pkcs11.CKO_SECRET_KEY:  "secret",
+}
+
+// keyUsageAttributes maps attributes permitting key operations to the name of the operation.
+var keyUsageAttributes = []struct {
+	attribute uint
+	name      string
+}{
+	{pkcs11.CKA_SIGN, "sign"},
+	{pkcs11.CKA_VERIFY, "verify"},
+	{pkcs11.CKA_ENCRYPT, "encrypt"},
+	{pkcs11.CKA_DECRYPT, "decrypt"},
+	{pkcs11.CKA_WRAP, "wrap"},
+	{pkcs11.CKA_UNWRAP, "unwrap"},
+	{pkcs11.CKA_DERIVE, "derive"},
+}
+
+// Inventory enumerates the keys, certificates and data objects on the token and returns their metadata.
+func (c *Context) Inventory() (*TokenInventory, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	var inventory *TokenInventory
+	err := c.withSession(func(session *pkcs11Session) error {
+		// Start afresh each time, as the function is retried if the session is lost
+		inventory = &TokenInventory{
+			Keys:         []KeyInventory{},
+			Certificates: []CertificateInventory{},
+			DataObjects:  []DataObjectInventory{},
+		}
+
+		for _, class := range []uint{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY, pkcs11.CKO_SECRET_KEY} {
+			handles, err := findKeysWithAttributes(session, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)})
+			if err != nil {
+				return err
+			}
+			for _, handle := range handles {
+				inventory.Keys = append(inventory.Keys, keyInventory(session, handle, class))
+			}
+		}
+
+		handles, err := findKeysWithAttributes(session, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+			pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+		})
+		if err != nil {
+			return err
+		}
+		for _, handle := range handles {
+			inventory.Certificates = append(inventory.Certificates, certificateInventory(session, handle))
+		}
+
+		handles, err = findKeysWithAttributes(session, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_DATA)})
+		if err != nil {
+			return err
+		}
+		for _, handle := range handles {
+			values := readAttributes(session, handle, []uint{pkcs11.CKA_LABEL, pkcs11.CKA_APPLICATION})
+			inventory.DataObjects = append(inventory.DataObjects, DataObjectInventory{
+				Label:       string(values[pkcs11.CKA_LABEL]),
+				Application: string(values[pkcs11.CKA_APPLICATION]),
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inventory, nil
+}
+
+// CountObjects returns the number of objects on the token matching template, for example
+// pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY) to count private keys. An empty template counts all
+// the objects visible to the Context. Unlike Inventory and the Find methods, no attributes of the objects are read.
+func (c *Context) CountObjects(template []*pkcs11.Attribute) (int, error) {
+	if c.closed.Get() {
+		return 0, errClosed
+	}
+
+	var count int
+	err := c.withSession(func(session *pkcs11Session) (err error) {
+		// Start afresh each time, as the function is retried if the session is lost
+		count = 0
+		if err = session.ctx.FindObjectsInit(session.handle, template); err != nil {
+			return err
+		}
+		defer func() {
+			finalErr := session.ctx.FindObjectsFinal(session.handle)
+			if err == nil {
+				err = finalErr
+			}
+		}()
+
+		for {
+			handles, _, err := session.ctx.FindObjects(session.handle, maxHandlePerFind)
+			if err != nil {
+				return err
+			}
+			if len(handles) == 0 {
+				return nil
+			}
+			count += len(handles)
+		}
+	})
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to count objects")
+	}
+	return count, nil
+}
+
+func keyInventory(session *pkcs11Session, handle pkcs11.ObjectHandle, class uint) KeyInventory {
+	types := []uint{pkcs11.CKA_ID, pkcs11.CKA_LABEL, pkcs11.CKA_KEY_TYPE, pkcs11.CKA_START_DATE, pkcs11.CKA_END_DATE}
+	switch class {
+	case pkcs11.CKO_SECRET_KEY:
+		types = append(types, pkcs11.CKA_VALUE_LEN)
+	default:
+		types = append(types, pkcs11.CKA_MODULUS, pkcs11.CKA_EC_PARAMS)
+	}
+	for _, usage := range keyUsageAttributes {
+		types = append(types, usage.attribute)
+	}
+
+	values := readAttributes(session, handle, types)
+
+	key := KeyInventory{
+		Class:     keyClassNames[class],
+		KeyType:   bytesToUlong(values[pkcs11.CKA_KEY_TYPE]),
+		ID:        hex.EncodeToString(values[pkcs11.CKA_ID]),
+		Label:     string(values[pkcs11.CKA_LABEL]),
+		Usage:     []string{},
+		StartDate: string(values[pkcs11.CKA_START_DATE]),
+		EndDate:   string(values[pkcs11.CKA_END_DATE]),
+	}
+
+	if modulus := values[pkcs11.CKA_MODULUS]; len(modulus) > 0 {
+		key.Bits = len(bytes.TrimLeft(modulus, "\x00")) * 8
+	} else if valueLen := values[pkcs11.CKA_VALUE_LEN]; len(valueLen) > 0 {
+		key.Bits = int(bytesToUlong(valueLen)) * 8
+	}
+
+	if params := values[pkcs11.CKA_EC_PARAMS]; len(params) > 0 {
+		for name, ci := range wellKnownCurves {
+			if bytes.Equal(params, ci.oid) {
+				key.Curve = name
+			}
+		}
+	}
+
+	for _, usage := range keyUsageAttributes {
+		if value := values[usage.attribute]; len(value) > 0 && value[0] != 0 {
+			key.Usage = append(key.Usage, usage.name)
+		}
+	}
+
+	return key
+}
+
+func certificateInventory(session *pkcs11Session, handle pkcs11.ObjectHandle) CertificateInventory {
+	values := readAttributes(session, handle, []uint{pkcs11.CKA_ID, pkcs11.CKA_LABEL, pkcs11.CKA_VALUE})
+
+	inventory := CertificateInventory{
+		ID:    hex.EncodeToString(values[pkcs11.CKA_ID]),
+		Label: string(values[pkcs11.CKA_LABEL]),
+	}
+
+	cert, err := x509.ParseCertificate(values[pkcs11.CKA_VALUE])
+	if err != nil {
+		inventory.Error = "failed to parse certificate: " + err.Error()
+		return inventory
+	}
+
+	inventory.Subject = cert.Subject.String()
+	inventory.Issuer = cert.Issuer.String()
+	inventory.Serial = cert.SerialNumber.String()
+	inventory.NotAfter = cert.NotAfter
+	return inventory
+}
+
+// readAttributes reads the given attributes of an object, omitting any that cannot be read. All attributes are
+// requested together; if that fails, because some are not valid for the object or are sensitive, each is requested
+// individually.
+func readAttributes(session *pkcs11Session, handle pkcs11.ObjectHandle, types []uint) map[uint][]byte {
+	template := make([]*pkcs11.Attribute, len(types))
+	for i, t := range types {
+		template[i] = pkcs11.NewAttribute(t, nil)
+	}
+
+	values := make(map[uint][]byte, len(types))
+
+	if attributes, err := session.ctx.GetAttributeValue(session.handle, handle, template); err == nil {
+		for _, a := range attributes {
+			values[a.Type] = a.Value
+		}
+		return values
+	}
+
+	for _, a := range template {
+		attributes, err := session.ctx.GetAttributeValue(session.handle, handle, []*pkcs11.Attribute{a})
+		if err == nil {
+			values[attributes[0].Type] = attributes[0].Value
+		}
+	}
+	return values
+}
@@ -0,0 +1,144 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInventory(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		rsaID := randomBytes()
+		rsaKey, err := ctx.GenerateRSAKeyPair(rsaID, rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = rsaKey.Delete() }()
+
+		aesID := randomBytes()
+		aesKey, err := ctx.GenerateSecretKey(aesID, 256, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = aesKey.Delete() }()
+
+		inventory, err := ctx.Inventory()
+		require.NoError(t, err)
+
+		found := map[string]KeyInventory{}
+		for _, key := range inventory.Keys {
+			if key.ID == hex.EncodeToString(rsaID) || key.ID == hex.EncodeToString(aesID) {
+				found[key.Class] = key
+			}
+		}
+
+		require.Len(t, found, 3)
+		assert.Equal(t, rsaSize, found["private"].Bits)
+		assert.Contains(t, found["private"].Usage, "sign")
+		assert.Equal(t, rsaSize, found["public"].Bits)
+		assert.Contains(t, found["public"].Usage, "verify")
+		assert.Equal(t, 256, found["secret"].Bits)
+		assert.Contains(t, found["secret"].Usage, "encrypt")
+
+		_, err = json.Marshal(inventory)
+		require.NoError(t, err)
+	})
+}
+
+func TestInventoryUnparseableCertificate(t *testing.T) {
+	if shouldSkipTest(skipTestCert) {
+		t.Skip("certificates not supported")
+	}
+
+	withContext(t, func(ctx *Context) {
+		id := randomBytes()
+		var handle pkcs11.ObjectHandle
+		err := ctx.withRWSession(func(session *pkcs11Session) (err error) {
+			handle, err = session.ctx.CreateObject(session.handle, []*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+				pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+				pkcs11.NewAttribute(pkcs11.CKA_SUBJECT, []byte{0x30, 0x00}),
+				pkcs11.NewAttribute(pkcs11.CKA_VALUE, []byte("not a certificate")),
+			})
+			return err
+		})
+		require.NoError(t, err)
+		defer func() { _ = (&pkcs11Object{handle, ctx}).Delete() }()
+
+		inventory, err := ctx.Inventory()
+		require.NoError(t, err)
+
+		var found *CertificateInventory
+		for i, cert := range inventory.Certificates {
+			if cert.ID == hex.EncodeToString(id) {
+				found = &inventory.Certificates[i]
+			}
+		}
+		require.NotNil(t, found)
+		assert.NotEmpty(t, found.Error)
+	})
+}
+
+func TestCountObjects(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		classes := []uint{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY, pkcs11.CKO_SECRET_KEY, pkcs11.CKO_CERTIFICATE}
+		count := func() (counts []int) {
+			for _, class := range classes {
+				n, err := ctx.CountObjects([]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)})
+				require.NoError(t, err)
+				counts = append(counts, n)
+			}
+			n, err := ctx.CountObjects(nil)
+			require.NoError(t, err)
+			return append(counts, n)
+		}
+		before := count()
+
+		id := randomBytes()
+		key, err := ctx.GenerateECDSAKeyPair(id, elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		secret, err := ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = secret.Delete() }()
+
+		expected := []int{before[0] + 1, before[1] + 1, before[2] + 1, before[3], before[4] + 3}
+		if !shouldSkipTest(skipTestCert) {
+			require.NoError(t, ctx.ImportCertificate(id, generateRandomCert(t, nil, "Count", nil, nil)))
+			defer func() { _ = ctx.DeleteCertificate(id, nil, nil) }()
+			expected[3]++
+			expected[4]++
+		}
+
+		assert.Equal(t, expected, count())
+
+		n, err := ctx.CountObjects([]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, id)})
+		require.NoError(t, err)
+		assert.Equal(t, expected[3]-before[3]+2, n)
+	})
+}
@@ -22,8 +22,13 @@
 package crypto11
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/rand"
 	"crypto/x509"
+	"fmt"
+	"sync"
+
 	"github.com/miekg/pkcs11"
 	"github.com/pkg/errors"
 )
@@ -36,6 +41,222 @@ var errNoCkaId = errors.New("private key has no CKA_ID")
 // errNoPublicHalf is returned if a public half cannot be found to match a given private key
 var errNoPublicHalf = errors.New("could not find public key to match private key")
 
+// unsupportedKeyTypeError is returned if a private key has a type (CKK_...) that cannot be used as a Signer.
+type unsupportedKeyTypeError uint
+
+func (e unsupportedKeyTypeError) Error() string {
+	return fmt.Sprintf("unsupported key type: %X", uint(e))
+}
+
+// randomIDLength is the length in bytes of the CKA_ID values chosen by withRandomID.
+const randomIDLength = 16
+
+// maxRandomIDAttempts limits how many random CKA_ID values withRandomID tries before giving up.
+const maxRandomIDAttempts = 10
+
+// errNoKeyUsage is returned if a KeyUsage permits no operations
+var errNoKeyUsage = errors.New("key usage must permit at least one operation")
+
+// KeyUsage selects the operations permitted on a generated asymmetric key pair. Each operation sets the relevant
+// attribute on the private half, and its counterpart on the public half.
+type KeyUsage struct {
+	// Sign sets CKA_SIGN on the private key and CKA_VERIFY on the public key.
+	Sign bool
+
+	// Decrypt sets CKA_DECRYPT on the private key and CKA_ENCRYPT on the public key.
+	Decrypt bool
+
+	// Wrap sets CKA_UNWRAP on the private key and CKA_WRAP on the public key.
+	Wrap bool
+}
+
+// validate returns an error if the KeyUsage permits no operations.
+func (u KeyUsage) validate() error {
+	if !u.Sign && !u.Decrypt && !u.Wrap {
+		return errNoKeyUsage
+	}
+	return nil
+}
+
+// apply sets the usage attributes on the public and private templates, overwriting any existing values.
+func (u KeyUsage) apply(public, private AttributeSet) {
+	_ = public.Set(CkaVerify, u.Sign) // error not possible for bool
+	_ = public.Set(CkaEncrypt, u.Decrypt)
+	_ = public.Set(CkaWrap, u.Wrap)
+	_ = private.Set(CkaSign, u.Sign)
+	_ = private.Set(CkaDecrypt, u.Decrypt)
+	_ = private.Set(CkaUnwrap, u.Wrap)
+}
+
+// SecretKeyUsage is a set of operations permitted on a generated secret key, combined with bitwise or. Each operation
+// sets the corresponding attribute; the attributes of operations not in the set are set to false. The zero value
+// selects DefaultSecretKeyUsage.
+//
+// Tokens differ in the combinations they accept. SoftHSM accepts any combination. Tokens enforcing key separation
+// (for instance in a FIPS mode) may refuse to combine wrapping with encryption or derivation, returning
+// CKR_TEMPLATE_INCONSISTENT or CKR_ATTRIBUTE_VALUE_INVALID. AWS CloudHSM does not accept CKA_ENCRYPT or CKA_DECRYPT on
+// generic secret keys, so generation of such keys is retried without them.
+type SecretKeyUsage uint
+
+const (
+	// SecretKeyEncrypt sets CKA_ENCRYPT.
+	SecretKeyEncrypt SecretKeyUsage = 1 << iota
+
+	// SecretKeyDecrypt sets CKA_DECRYPT.
+	SecretKeyDecrypt
+
+	// SecretKeyWrap sets CKA_WRAP, permitting the key to wrap other keys.
+	SecretKeyWrap
+
+	// SecretKeyUnwrap sets CKA_UNWRAP, permitting the key to unwrap other keys.
+	SecretKeyUnwrap
+
+	// SecretKeyDerive sets CKA_DERIVE, permitting other keys to be derived from the key.
+	SecretKeyDerive
+
+	// allSecretKeyUsages is every valid SecretKeyUsage bit.
+	allSecretKeyUsages = SecretKeyEncrypt | SecretKeyDecrypt | SecretKeyWrap | SecretKeyUnwrap | SecretKeyDerive
+)
+
+// DefaultSecretKeyUsage is the usage of secret keys generated without a SecretKeyUsage, such as by
+// GenerateSecretKey.
+const DefaultSecretKeyUsage = SecretKeyEncrypt | SecretKeyDecrypt
+
+// validate returns an error if the SecretKeyUsage contains unknown operations.
+func (u SecretKeyUsage) validate() error {
+	if u&^allSecretKeyUsages != 0 {
+		return errors.Errorf("unknown secret key usage %#x", uint(u&^allSecretKeyUsages))
+	}
+	return nil
+}
+
+// apply sets the usage attributes on the template, overwriting any existing values.
+func (u SecretKeyUsage) apply(template AttributeSet) {
+	if u == 0 {
+		u = DefaultSecretKeyUsage
+	}
+	_ = template.Set(CkaEncrypt, u&SecretKeyEncrypt != 0) // error not possible for bool
+	_ = template.Set(CkaDecrypt, u&SecretKeyDecrypt != 0)
+	_ = template.Set(CkaWrap, u&SecretKeyWrap != 0)
+	_ = template.Set(CkaUnwrap, u&SecretKeyUnwrap != 0)
+	_ = template.Set(CkaDerive, u&SecretKeyDerive != 0)
+}
+
+// KeyGenOptions controls the protection and lifetime of a generated private or secret key. The zero value gives the
+// default: a token object that is sensitive and not extractable.
+type KeyGenOptions struct {
+	// Sensitive sets CKA_SENSITIVE, which prevents the key value being read from the token. If nil, the key is
+	// sensitive.
+	Sensitive *bool
+
+	// Extractable sets CKA_EXTRACTABLE, which permits the key to be wrapped. If nil, the key is not extractable.
+	Extractable *bool
+
+	// NeverExtractable requires that the key is never extractable, so that the token sets CKA_NEVER_EXTRACTABLE.
+	// It conflicts with Extractable set to true.
+	NeverExtractable bool
+
+	// AlwaysSensitive requires that the key is always sensitive, so that the token sets CKA_ALWAYS_SENSITIVE.
+	// It conflicts with Sensitive set to false.
+	AlwaysSensitive bool
+
+	// Ephemeral creates the key (both halves, for a key pair) as a session object, with CKA_TOKEN false. Session
+	// objects are not stored on the token and are destroyed when the Context is closed.
+	Ephemeral bool
+
+	// PublicExponent sets CKA_PUBLIC_EXPONENT for an RSA key pair. It must be odd and between 3 and 2^31-1. If zero,
+	// the default of 65537 is used. It must not be set for other types of key.
+	PublicExponent int
+
+	// AlwaysAuthenticate sets CKA_ALWAYS_AUTHENTICATE on the private or secret key, so that the user must log in
+	// again before each operation with it. Enable Config.ContextSpecificLogin to use such keys.
+	AlwaysAuthenticate bool
+}
+
+// maxPublicExponent is the largest RSA public exponent accepted, matching the limit of crypto/rsa.
+const maxPublicExponent = 1<<31 - 1
+
+// validate returns an error if the options conflict.
+func (o KeyGenOptions) validate() error {
+	if o.NeverExtractable && o.Extractable != nil && *o.Extractable {
+		return errors.New("a key cannot be both extractable and never extractable")
+	}
+	if o.AlwaysSensitive && o.Sensitive != nil && !*o.Sensitive {
+		return errors.New("a key cannot be both not sensitive and always sensitive")
+	}
+	if o.PublicExponent != 0 && (o.PublicExponent < 3 || o.PublicExponent > maxPublicExponent ||
+		o.PublicExponent%2 == 0) {
+		return errors.Errorf("public exponent %d must be odd and between 3 and %d", o.PublicExponent,
+			maxPublicExponent)
+	}
+	return nil
+}
+
+// validateNonRSA is like validate, for options used to generate a key that is not an RSA key.
+func (o KeyGenOptions) validateNonRSA() error {
+	if o.PublicExponent != 0 {
+		return errors.New("a public exponent can only be set for RSA keys")
+	}
+	return o.validate()
+}
+
+// apply sets the protection attributes on the template for a private or secret key, overwriting any existing values.
+func (o KeyGenOptions) apply(template AttributeSet) {
+	if o.Sensitive != nil {
+		_ = template.Set(CkaSensitive, *o.Sensitive) // error not possible for bool
+	}
+	if o.Extractable != nil {
+		_ = template.Set(CkaExtractable, *o.Extractable)
+	}
+	if o.NeverExtractable {
+		_ = template.Set(CkaExtractable, false)
+	}
+	if o.AlwaysSensitive {
+		_ = template.Set(CkaSensitive, true)
+	}
+	if o.AlwaysAuthenticate {
+		_ = template.Set(CkaAlwaysAuthenticate, true)
+	}
+	o.applyPublic(template)
+}
+
+// applyPublic sets the attributes that apply to the public half of a key pair, as well as the private half.
+func (o KeyGenOptions) applyPublic(template AttributeSet) {
+	if o.Ephemeral {
+		_ = template.Set(CkaToken, false)
+	}
+}
+
+// newKeyAttributeSet returns an AttributeSet with CKA_ID set to id, which must be non-nil, and CKA_LABEL set to
+// label, if non-nil.
+func newKeyAttributeSet(id, label []byte) (AttributeSet, error) {
+	if label == nil {
+		return NewAttributeSetWithID(id)
+	}
+	return NewAttributeSetWithIDAndLabel(id, label)
+}
+
+// checkKeySize checks bits against the key sizes the token reports for mech, so that an unsupported size gives a
+// clear error rather than a bare CKR_KEY_SIZE_RANGE or CKR_TEMPLATE_INCONSISTENT. The check is skipped if the token
+// does not report the sizes. The what parameter describes the size, e.g. "RSA modulus".
+func (c *Context) checkKeySize(session *pkcs11Session, mech uint, what string, bits int) error {
+	info, err := session.ctx.GetMechanismInfo(c.SlotID(), []*pkcs11.Mechanism{pkcs11.NewMechanism(mech, nil)})
+	if err != nil {
+		c.logf("crypto11: cannot check key size for %s: %v", mechanismString(mech), err)
+		return nil
+	}
+	return keySizeError(info, mech, what, bits)
+}
+
+// keySizeError returns an error if bits lies outside the range in info. A maximum of zero is treated as unbounded.
+func keySizeError(info pkcs11.MechanismInfo, mech uint, what string, bits int) error {
+	if bits < 0 || uint(bits) < info.MinKeySize || (info.MaxKeySize != 0 && uint(bits) > info.MaxKeySize) {
+		return errors.Errorf("%s of %d bits is not supported by the token, which allows %d-%d bits for %s", what,
+			bits, info.MinKeySize, info.MaxKeySize, mechanismString(mech))
+	}
+	return nil
+}
+
 func findKeysWithAttributes(session *pkcs11Session, template []*pkcs11.Attribute) (handles []pkcs11.ObjectHandle, err error) {
 	if err = session.ctx.FindObjectsInit(session.handle, template); err != nil {
 		return nil, err
@@ -103,6 +324,116 @@ func findKey(session *pkcs11Session, id []byte, label []byte, keyclass *uint, ke
 	return &handles[0], nil
 }
 
+// keyIdentity records how to find a key pair on the token again, should its object handles become invalid, for
+// example after Reinitialize. Values of pkcs11PrivateKey for the same key share the keyIdentity.
+type keyIdentity struct {
+	id      []byte
+	label   []byte
+	keyType uint
+
+	// mutex is held for reading by operations on the key, and for writing while its handles are replaced.
+	mutex sync.RWMutex
+}
+
+// newKeyIdentity returns the keyIdentity for a key pair, or nil if id is empty, since the key could not be found again.
+func newKeyIdentity(id, label []byte, keyType uint) *keyIdentity {
+	if len(id) == 0 {
+		return nil
+	}
+	if len(label) == 0 {
+		label = nil
+	}
+	return &keyIdentity{id: id, label: label, keyType: keyType}
+}
+
+// templateIdentity returns the keyIdentity for a key pair created with the given private key template.
+func templateIdentity(private AttributeSet, keyType uint) *keyIdentity {
+	var id, label []byte
+	if attribute, ok := private[CkaId]; ok {
+		id = attribute.Value
+	}
+	if attribute, ok := private[CkaLabel]; ok {
+		label = attribute.Value
+	}
+	return newKeyIdentity(id, label, keyType)
+}
+
+// refreshHandles finds the key pair on the token again by its CKA_ID, CKA_LABEL and key type, and replaces its
+// object handles.
+func (k *pkcs11PrivateKey) refreshHandles() error {
+	identity := k.identity
+	identity.mutex.Lock()
+	defer identity.mutex.Unlock()
+
+	return k.context.withSession(func(session *pkcs11Session) error {
+		privHandles, err := findKeys(session, identity.id, identity.label, uintPtr(pkcs11.CKO_PRIVATE_KEY),
+			&identity.keyType)
+		if err != nil {
+			return err
+		}
+		if len(privHandles) != 1 {
+			return errors.Errorf("found %d private keys with the key's CKA_ID and CKA_LABEL, expected one",
+				len(privHandles))
+		}
+
+		pubHandle := k.pubKeyHandle
+		if pubHandle != 0 {
+			handle, err := findKey(session, identity.id, identity.label, uintPtr(pkcs11.CKO_PUBLIC_KEY),
+				&identity.keyType)
+			if err == nil && handle == nil {
+				handle, err = findKey(session, identity.id, nil, uintPtr(pkcs11.CKO_PUBLIC_KEY), &identity.keyType)
+			}
+			if err != nil {
+				return err
+			}
+			if handle == nil {
+				return errNoPublicHalf
+			}
+			pubHandle = *handle
+		}
+
+		k.context.logf("crypto11: found key again, handle %d is now %d", k.handle, privHandles[0])
+		k.handle, k.pubKeyHandle = privHandles[0], pubHandle
+		return nil
+	})
+}
+
+// withRandomID chooses a random CKA_ID that no object on the token has, and passes it to generate, which creates
+// a key with it. The search for existing objects is made in a session, and randomIDMutex is held until generate
+// returns, so that concurrent callers on the Context cannot choose the same CKA_ID. The chosen CKA_ID is returned.
+func (c *Context) withRandomID(generate func(id []byte) error) ([]byte, error) {
+	c.randomIDMutex.Lock()
+	defer c.randomIDMutex.Unlock()
+
+	var id []byte
+	err := c.withSession(func(session *pkcs11Session) error {
+		for attempt := 0; attempt < maxRandomIDAttempts; attempt++ {
+			candidate := make([]byte, randomIDLength)
+			if _, err := rand.Read(candidate); err != nil {
+				return err
+			}
+
+			handles, err := findKeys(session, candidate, nil, nil, nil)
+			if err != nil {
+				return err
+			}
+			if len(handles) == 0 {
+				id = candidate
+				return nil
+			}
+		}
+		return errors.New("failed to choose an unused CKA_ID")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = generate(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
 // Takes a handles to the private half of a keypair, locates the public half with the matching CKA_ID and CKA_LABEL
 // values and constructs a keypair object from them both.
 func (c *Context) makeKeyPair(session *pkcs11Session, privHandle *pkcs11.ObjectHandle) (signer Signer, certificate *x509.Certificate, err error) {
@@ -166,6 +497,7 @@ func (c *Context) makeKeyPair(session *pkcs11Session, privHandle *pkcs11.ObjectH
 			handle:  *privHandle,
 			context: c,
 		},
+		identity: newKeyIdentity(id, label, keyType),
 	}
 
 	var pub crypto.PublicKey
@@ -217,7 +549,7 @@ func (c *Context) makeKeyPair(session *pkcs11Session, privHandle *pkcs11.ObjectH
 		return result, certificate, nil
 
 	default:
-		return nil, nil, errors.Errorf("unsupported key type: %X", keyType)
+		return nil, nil, unsupportedKeyTypeError(keyType)
 	}
 }
 
@@ -244,6 +576,53 @@ func (c *Context) FindKeyPair(id []byte, label []byte) (Signer, error) {
 	return result[0], nil
 }
 
+// FindPublicKey retrieves a public key object (CKO_PUBLIC_KEY), or nil if none can be found. Unlike FindKeyPair, the
+// key is found whether or not the token holds the matching private key, which suits keys stored only for
+// verification, such as those imported with ImportPublicKey. RSA, ECDSA and DSA keys are supported.
+//
+// At least one of id and label must be specified. If several keys match, the first found is returned.
+func (c *Context) FindPublicKey(id, label []byte) (crypto.PublicKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if id == nil && label == nil {
+		return nil, errors.New("id and label cannot both be nil")
+	}
+
+	var pub crypto.PublicKey
+	err := c.withSession(func(session *pkcs11Session) error {
+		handle, err := findKey(session, id, label, uintPtr(pkcs11.CKO_PUBLIC_KEY), nil)
+		if err != nil || handle == nil {
+			return err
+		}
+
+		pub, err = exportPublicKey(session, *handle)
+		return err
+	})
+	return pub, err
+}
+
+// exportPublicKey exports the public key object with the given handle, according to its CKA_KEY_TYPE.
+func exportPublicKey(session *pkcs11Session, handle pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)}
+	template, err := session.ctx.GetAttributeValue(session.handle, handle, template)
+	if err != nil {
+		return nil, err
+	}
+
+	switch keyType := bytesToUlong(template[0].Value); keyType {
+	case pkcs11.CKK_RSA:
+		return exportRSAPublicKey(session, handle)
+	case pkcs11.CKK_ECDSA:
+		return exportECDSAPublicKey(session, handle)
+	case pkcs11.CKK_DSA:
+		return exportDSAPublicKey(session, handle)
+	default:
+		return nil, unsupportedKeyTypeError(keyType)
+	}
+}
+
 // FindKeyPairs retrieves all matching asymmetric key pairs, or a nil slice if none can be found.
 //
 // At least one of id and label must be specified.
@@ -338,6 +717,10 @@ func (c *Context) FindKeyPairsWithAttributes(attributes AttributeSet) (signer []
 			if err == errNoCkaId || err == errNoPublicHalf {
 				continue
 			}
+			if _, ok := err.(unsupportedKeyTypeError); ok {
+				c.logf("crypto11: skipping private key %d: %v", privHandle, err)
+				continue
+			}
 			if err != nil {
 				return err
 			}
@@ -355,10 +738,34 @@ func (c *Context) FindKeyPairsWithAttributes(attributes AttributeSet) (signer []
 	return keys, nil
 }
 
+// FindKeyPairsWithTemplate retrieves all asymmetric key pairs whose private keys match the find template, or a nil
+// slice if none can be found. It behaves like FindKeyPairsWithAttributes, but takes a PKCS#11 template directly.
+//
+// The template must not be empty, to avoid unintentionally retrieving every key pair; use FindAllKeyPairs for that.
+// It must not contain CKA_CLASS.
+func (c *Context) FindKeyPairsWithTemplate(template []*pkcs11.Attribute) ([]Signer, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if len(template) == 0 {
+		return nil, errors.New("template must not be empty")
+	}
+
+	attributes := NewAttributeSet()
+	attributes.AddIfNotPresent(template)
+	if len(attributes) != len(template) {
+		return nil, errors.New("template must not contain duplicate attributes")
+	}
+
+	return c.FindKeyPairsWithAttributes(attributes)
+}
+
 // FindAllKeyPairs retrieves all existing asymmetric key pairs, or a nil slice if none can be found.
 //
 // If a private key is found, but the corresponding public key is not, the key is not returned because we cannot
-// implement crypto.Signer without the public key.
+// implement crypto.Signer without the public key. Private keys of unsupported types are skipped, and the reason
+// logged.
 func (c *Context) FindAllKeyPairs() ([]Signer, error) {
 	if c.closed.Get() {
 		return nil, errClosed
@@ -367,12 +774,149 @@ func (c *Context) FindAllKeyPairs() ([]Signer, error) {
 	return c.FindKeyPairsWithAttributes(NewAttributeSet())
 }
 
+// KeyPairIterator iterates over the asymmetric key pairs on a token without loading them all into memory at once.
+// Create one with IterateKeyPairs and call Close when finished with it.
+//
+// A KeyPairIterator is not safe for concurrent use.
+type KeyPairIterator struct {
+	context *Context
+
+	// session holds the active find operation, or nil once the iterator is closed.
+	session *pkcs11Session
+
+	// handles holds the remaining private key handles from the last batch returned by C_FindObjects.
+	handles []pkcs11.ObjectHandle
+
+	key Signer
+	err error
+}
+
+// IterateKeyPairs returns an iterator over all existing asymmetric key pairs. The underlying find operation is kept
+// open between calls to Next, and private keys are fetched from the token in batches, so very large tokens can be
+// processed without holding every key in memory:
+//
+//	it, err := ctx.IterateKeyPairs()
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//	    key := it.Key()
+//	    ...
+//	}
+//	if err := it.Err(); err != nil {
+//	    ...
+//	}
+//
+// As with FindAllKeyPairs, private keys without a CKA_ID or a corresponding public key are skipped. The iterator
+// holds one session for the find operation and borrows another while loading each key, so MaxSessions must be at
+// least 3.
+func (c *Context) IterateKeyPairs() (*KeyPairIterator, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if c.pool.Capacity() < 2 {
+		return nil, errors.New("iterating key pairs requires MaxSessions of at least 3")
+	}
+
+	session, err := c.getSession()
+	if err != nil {
+		return nil, err
+	}
+
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY)}
+	if err = session.ctx.FindObjectsInit(session.handle, template); err != nil {
+		c.pool.Put(session)
+		return nil, err
+	}
+
+	return &KeyPairIterator{context: c, session: session}, nil
+}
+
+// Next advances the iterator to the next key pair, which is then available via Key. It returns false when there are
+// no more key pairs or an error occurs, in which case the error is available via Err.
+func (it *KeyPairIterator) Next() bool {
+	it.key = nil
+
+	for it.session != nil {
+		if len(it.handles) == 0 {
+			handles, _, err := it.session.ctx.FindObjects(it.session.handle, maxHandlePerFind)
+			if err != nil {
+				it.err = err
+				_ = it.Close()
+				return false
+			}
+
+			if len(handles) == 0 {
+				it.err = it.Close()
+				return false
+			}
+
+			it.handles = handles
+		}
+
+		privHandle := it.handles[0]
+		it.handles = it.handles[1:]
+
+		// The find operation is active on our own session, so the key must be loaded using another one.
+		var k Signer
+		err := it.context.withSession(func(session *pkcs11Session) (err error) {
+			k, _, err = it.context.makeKeyPair(session, &privHandle)
+			return err
+		})
+
+		if err == errNoCkaId || err == errNoPublicHalf {
+			continue
+		}
+		if _, ok := err.(unsupportedKeyTypeError); ok {
+			it.context.logf("crypto11: skipping private key %d: %v", privHandle, err)
+			continue
+		}
+		if err != nil {
+			it.err = err
+			_ = it.Close()
+			return false
+		}
+
+		it.key = k
+		return true
+	}
+
+	return false
+}
+
+// Key returns the key pair at the current position of the iterator.
+func (it *KeyPairIterator) Key() Signer {
+	return it.key
+}
+
+// Err returns the first error encountered during iteration, if any.
+func (it *KeyPairIterator) Err() error {
+	return it.err
+}
+
+// Close finishes the find operation and returns the iterator's session to the pool. It is safe to call Close more
+// than once, and after Next has returned false.
+func (it *KeyPairIterator) Close() error {
+	if it.session == nil {
+		return nil
+	}
+
+	err := it.session.ctx.FindObjectsFinal(it.session.handle)
+	it.context.pool.Put(it.session)
+	it.session = nil
+	it.handles = nil
+
+	return err
+}
+
 // Public returns the public half of a private key.
 //
 // This partially implements the go.crypto.Signer and go.crypto.Decrypter interfaces for
 // pkcs11PrivateKey. (The remains of the implementation is in the
 // key-specific types.)
-func (k pkcs11PrivateKey) Public() crypto.PublicKey {
+func (k *pkcs11PrivateKey) Public() crypto.PublicKey {
+	k.context.pubKeyMutex.RLock()
+	defer k.context.pubKeyMutex.RUnlock()
 	return k.pubKey
 }
 
@@ -469,24 +1013,8 @@ func (c *Context) FindKeysWithAttributes(attributes AttributeSet) ([]*SecretKey,
 			return err
 		}
 
-		for _, privHandle := range privHandles {
-			attributes := []*pkcs11.Attribute{
-				pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, 0),
-			}
-			if attributes, err = session.ctx.GetAttributeValue(session.handle, privHandle, attributes); err != nil {
-				return err
-			}
-			keyType := bytesToUlong(attributes[0].Value)
-
-			if cipher, ok := Ciphers[int(keyType)]; ok {
-				k := &SecretKey{pkcs11Object{privHandle, c}, cipher}
-				keys = append(keys, k)
-			} else {
-				return errors.Errorf("unsupported key type: %X", keyType)
-			}
-		}
-
-		return nil
+		keys, err = c.makeSecretKeys(session, privHandles, false)
+		return err
 	})
 
 	if err != nil {
@@ -495,6 +1023,31 @@ func (c *Context) FindKeysWithAttributes(attributes AttributeSet) ([]*SecretKey,
 	return keys, nil
 }
 
+// makeSecretKeys returns SecretKey values for the handles of secret key objects. Keys whose CKA_KEY_TYPE is not in
+// Ciphers cause an error, unless skipUnsupported is true, in which case they are left out.
+func (c *Context) makeSecretKeys(session *pkcs11Session, handles []pkcs11.ObjectHandle,
+	skipUnsupported bool) ([]*SecretKey, error) {
+
+	var keys []*SecretKey
+	for _, handle := range handles {
+		attributes := []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, 0),
+		}
+		attributes, err := session.ctx.GetAttributeValue(session.handle, handle, attributes)
+		if err != nil {
+			return nil, err
+		}
+		keyType := bytesToUlong(attributes[0].Value)
+
+		if cipher, ok := Ciphers[int(keyType)]; ok {
+			keys = append(keys, &SecretKey{pkcs11Object{handle, c}, cipher})
+		} else if !skipUnsupported {
+			return nil, errors.Errorf("unsupported key type: %X", keyType)
+		}
+	}
+	return keys, nil
+}
+
 // FindAllKeyPairs retrieves all existing symmetric keys, or a nil slice if none can be found.
 func (c *Context) FindAllKeys() ([]*SecretKey, error) {
 	if c.closed.Get() {
@@ -504,6 +1057,32 @@ func (c *Context) FindAllKeys() ([]*SecretKey, error) {
 	return c.FindKeysWithAttributes(NewAttributeSet())
 }
 
+// FindAllSecretKeys retrieves all secret keys on the token, or a nil slice if none can be found. Unlike FindAllKeys,
+// keys with a CKA_KEY_TYPE that has no entry in Ciphers are skipped rather than causing an error. Use
+// SecretKey.KeyType and SecretKey.KeyLength to filter the results.
+func (c *Context) FindAllSecretKeys() ([]*SecretKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	var keys []*SecretKey
+	err := c.withSession(func(session *pkcs11Session) error {
+		template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY)}
+		handles, err := findKeysWithAttributes(session, template)
+		if err != nil {
+			return err
+		}
+
+		keys, err = c.makeSecretKeys(session, handles, true)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 func uintPtr(i uint) *uint { return &i }
 
 func (c *Context) getAttributes(handle pkcs11.ObjectHandle, attributes []AttributeType) (a AttributeSet, err error) {
@@ -528,6 +1107,75 @@ func (c *Context) getAttributes(handle pkcs11.ObjectHandle, attributes []Attribu
 	return values, err
 }
 
+// CopyKeyPair copies both halves of the key pair src on the token, giving the copies CKA_ID newID and, if newLabel is
+// not nil, CKA_LABEL newLabel. The original key pair is unchanged. The newID parameter must be non-nil, so that the
+// copy can be found again with FindKeyPair. If src has no public key object on the token, only the private key is
+// copied.
+//
+// If the object is not a crypto11 keypair then an error is returned.
+func (c *Context) CopyKeyPair(src Signer, newID, newLabel []byte) (Signer, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if newID == nil {
+		return nil, errors.New("id must not be nil")
+	}
+
+	var original *pkcs11PrivateKey
+	switch k := src.(type) {
+	case *pkcs11PrivateKeyDSA:
+		original = &k.pkcs11PrivateKey
+	case *pkcs11PrivateKeyRSA:
+		original = &k.pkcs11PrivateKey
+	case *pkcs11PrivateKeyECDSA:
+		original = &k.pkcs11PrivateKey
+	default:
+		return nil, errors.Errorf("not an asymmetric PKCS#11 key")
+	}
+
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_ID, newID)}
+	if newLabel != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, newLabel))
+	}
+
+	privHandle, err := original.Copy(template)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to copy private key")
+	}
+
+	var pubHandle pkcs11.ObjectHandle
+	if handle := original.PublicHandle(); handle != 0 {
+		pubHandle, err = (&pkcs11Object{handle, c}).Copy(template)
+		if err != nil {
+			_ = (&pkcs11Object{privHandle, c}).Delete()
+			return nil, errors.WithMessage(err, "failed to copy public key")
+		}
+	}
+
+	copied := pkcs11PrivateKey{
+		pkcs11Object: pkcs11Object{privHandle, c},
+		pubKeyHandle: pubHandle,
+		pubKey:       original.Public(),
+	}
+	if original.identity != nil {
+		label := newLabel
+		if label == nil {
+			label = original.identity.label
+		}
+		copied.identity = newKeyIdentity(newID, label, original.identity.keyType)
+	}
+
+	switch src.(type) {
+	case *pkcs11PrivateKeyDSA:
+		return &pkcs11PrivateKeyDSA{copied}, nil
+	case *pkcs11PrivateKeyRSA:
+		return &pkcs11PrivateKeyRSA{copied}, nil
+	default:
+		return &pkcs11PrivateKeyECDSA{copied}, nil
+	}
+}
+
 // GetAttributes gets the values of the specified attributes on the given key or keypair.
 // If the key is asymmetric, then the attributes are retrieved from the private half.
 //
@@ -584,11 +1232,11 @@ func (c *Context) GetPubAttributes(key interface{}, attributes []AttributeType)
 
 	switch k := (key).(type) {
 	case *pkcs11PrivateKeyDSA:
-		handle = k.pubKeyHandle
+		handle = k.PublicHandle()
 	case *pkcs11PrivateKeyRSA:
-		handle = k.pubKeyHandle
+		handle = k.PublicHandle()
 	case *pkcs11PrivateKeyECDSA:
-		handle = k.pubKeyHandle
+		handle = k.PublicHandle()
 	default:
 		return nil, errors.Errorf("not an asymmetric PKCS#11 key")
 	}
@@ -611,3 +1259,76 @@ func (c *Context) GetPubAttribute(key interface{}, attribute AttributeType) (a *
 
 	return set[attribute], nil
 }
+
+// DeleteObjectsByLabelPrefix destroys every object whose CKA_LABEL starts with prefix, and returns the number of
+// objects destroyed. This includes keys, certificates and data objects. When a private key is destroyed, so are the
+// unlabelled public keys with the same CKA_ID, which form the other half of the key pair. Public keys labelled with
+// the prefix are destroyed in any case, and those with another label are left alone.
+//
+// The prefix must not be empty. If an object cannot be destroyed, an error is returned along with the number of
+// objects destroyed so far.
+func (c *Context) DeleteObjectsByLabelPrefix(prefix []byte) (int, error) {
+	if c.closed.Get() {
+		return 0, errClosed
+	}
+
+	if len(prefix) == 0 {
+		return 0, errors.New("prefix must not be empty")
+	}
+
+	deleted := 0
+	err := c.withRWSession(func(session *pkcs11Session) error {
+		handles, err := findKeysWithAttributes(session, nil)
+		if err != nil {
+			return err
+		}
+
+		var targets []pkcs11.ObjectHandle
+		isTarget := map[pkcs11.ObjectHandle]bool{}
+		var pairIDs [][]byte
+
+		for _, handle := range handles {
+			values := readAttributes(session, handle, []uint{pkcs11.CKA_LABEL, pkcs11.CKA_CLASS, pkcs11.CKA_ID})
+			if !bytes.HasPrefix(values[pkcs11.CKA_LABEL], prefix) {
+				continue
+			}
+			targets = append(targets, handle)
+			isTarget[handle] = true
+
+			if class, ok := values[pkcs11.CKA_CLASS]; ok && bytesToUlong(class) == pkcs11.CKO_PRIVATE_KEY &&
+				len(values[pkcs11.CKA_ID]) > 0 {
+				pairIDs = append(pairIDs, values[pkcs11.CKA_ID])
+			}
+		}
+
+		for _, id := range pairIDs {
+			publicHandles, err := findKeysWithAttributes(session, []*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+				pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+			})
+			if err != nil {
+				return err
+			}
+			for _, handle := range publicHandles {
+				if isTarget[handle] {
+					continue
+				}
+				// Public keys labelled with the prefix are already targets; others belong to someone else
+				if len(readAttributes(session, handle, []uint{pkcs11.CKA_LABEL})[pkcs11.CKA_LABEL]) > 0 {
+					continue
+				}
+				targets = append(targets, handle)
+				isTarget[handle] = true
+			}
+		}
+
+		for _, handle := range targets {
+			if err := session.ctx.DestroyObject(session.handle, handle); err != nil {
+				return errors.WithMessagef(err, "failed to destroy object %d", handle)
+			}
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
+}
@@ -1,8 +1,13 @@
 package crypto11
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/hex"
+	"errors"
 	"testing"
 
 	"github.com/miekg/pkcs11"
@@ -125,6 +130,36 @@ func TestFindingKeyPairsWithAttributes(t *testing.T) {
 	})
 }
 
+func TestFindingKeyPairsWithTemplate(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		label := randomBytes()
+
+		key, err := ctx.GenerateRSAKeyPairWithLabel(randomBytes(), label, rsaSize)
+		require.NoError(t, err)
+		defer func(k Signer) { _ = k.Delete() }(key)
+
+		key2, err := ctx.GenerateECDSAKeyPairWithLabel(randomBytes(), label, elliptic.P256())
+		require.NoError(t, err)
+		defer func(k Signer) { _ = k.Delete() }(key2)
+
+		keys, err := ctx.FindKeyPairsWithTemplate([]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		})
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+
+		keys, err = ctx.FindKeyPairsWithTemplate([]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		})
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+
+		_, err = ctx.FindKeyPairsWithTemplate(nil)
+		require.Error(t, err)
+	})
+}
+
 func TestFindingAllKeys(t *testing.T) {
 	withContext(t, func(ctx *Context) {
 		for i := 0; i < 10; i++ {
@@ -143,6 +178,58 @@ func TestFindingAllKeys(t *testing.T) {
 	})
 }
 
+func TestFindingAllSecretKeys(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		lengths := map[string]int{}
+		for _, bits := range []int{128, 256} {
+			id := randomBytes()
+			key, err := ctx.GenerateSecretKey(id, bits, CipherAES)
+			require.NoError(t, err)
+			defer func(k *SecretKey) { _ = k.Delete() }(key)
+			lengths[string(id)] = bits
+		}
+
+		if supported, _ := ctx.mechanismSupported(pkcs11.CKM_DES_KEY_GEN); supported {
+			// CKK_DES is not in Ciphers, so must be skipped
+			var handle pkcs11.ObjectHandle
+			err := ctx.withRWSession(func(session *pkcs11Session) (err error) {
+				handle, err = session.ctx.GenerateKey(session.handle,
+					[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_DES_KEY_GEN, nil)},
+					[]*pkcs11.Attribute{
+						pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+						pkcs11.NewAttribute(pkcs11.CKA_ID, randomBytes()),
+					})
+				return
+			})
+			require.NoError(t, err)
+			defer func() { _ = (&pkcs11Object{handle, ctx}).Delete() }()
+		}
+
+		keys, err := ctx.FindAllSecretKeys()
+		require.NoError(t, err)
+
+		found := 0
+		for _, key := range keys {
+			id, _, err := key.Identifier()
+			require.NoError(t, err)
+			bits, ok := lengths[string(id)]
+			if !ok {
+				continue
+			}
+			found++
+
+			keyType, err := key.KeyType()
+			require.NoError(t, err)
+			assert.Equal(t, uint(pkcs11.CKK_AES), keyType)
+
+			length, err := key.KeyLength()
+			require.NoError(t, err)
+			assert.Equal(t, bits, length)
+		}
+		assert.Equal(t, len(lengths), found)
+	})
+}
+
 func TestFindingAllKeyPairs(t *testing.T) {
 	withContext(t, func(ctx *Context) {
 		for i := 1; i <= 5; i++ {
@@ -161,6 +248,32 @@ func TestFindingAllKeyPairs(t *testing.T) {
 	})
 }
 
+func TestKeyIdentifiers(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		id := randomBytes()
+		label := randomBytes()
+
+		key, err := ctx.GenerateRSAKeyPairWithLabel(id, label, rsaSize)
+		require.NoError(t, err)
+		defer func(k Signer) { _ = k.Delete() }(key)
+
+		gotID, gotLabel, err := key.(*pkcs11PrivateKeyRSA).Identifier()
+		require.NoError(t, err)
+		require.Equal(t, id, gotID)
+		require.Equal(t, label, gotLabel)
+
+		secretID := randomBytes()
+		secret, err := ctx.GenerateSecretKey(secretID, 128, CipherAES)
+		require.NoError(t, err)
+		defer func(k *SecretKey) { _ = k.Delete() }(secret)
+
+		gotID, gotLabel, err = secret.Identifier()
+		require.NoError(t, err)
+		require.Equal(t, secretID, gotID)
+		require.Empty(t, gotLabel)
+	})
+}
+
 func TestGettingPrivateKeyAttributes(t *testing.T) {
 	withContext(t, func(ctx *Context) {
 		id := randomBytes()
@@ -221,3 +334,501 @@ func TestGettingUnsupportedKeyTypeAttributes(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestIteratingKeyPairs(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		for i := 1; i <= maxHandlePerFind+5; i++ {
+			key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+			require.NoError(t, err)
+
+			defer func(k Signer) { _ = k.Delete() }(key)
+		}
+
+		it, err := ctx.IterateKeyPairs()
+		require.NoError(t, err)
+		defer func() { require.NoError(t, it.Close()) }()
+
+		count := 0
+		for it.Next() {
+			require.NotNil(t, it.Key())
+			count++
+		}
+		require.NoError(t, it.Err())
+		require.Equal(t, maxHandlePerFind+5, count)
+
+		// Exhausted iterators stay exhausted
+		require.False(t, it.Next())
+	})
+}
+
+func TestRotatingKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		label := randomBytes()
+
+		oldKey, err := ctx.GenerateECDSAKeyPairWithLabel(randomBytes(), label, elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = oldKey.Delete() }()
+
+		old, newKey, err := ctx.RotateKeyPair(label, func() (Signer, error) {
+			return ctx.GenerateECDSAKeyPairWithLabel(randomBytes(), randomBytes(), elliptic.P256())
+		})
+		require.NoError(t, err)
+		defer func() { _ = newKey.Delete() }()
+
+		require.Equal(t, oldKey.Public(), old.Public())
+
+		found, err := ctx.FindKeyPair(nil, label)
+		require.NoError(t, err)
+		require.Equal(t, newKey.Public(), found.Public())
+
+		attr, err := ctx.GetAttribute(old, CkaLabel)
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(attr.Value, append(label, archiveLabelSuffix...)))
+	})
+}
+
+func TestRotatingMissingKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		_, _, err := ctx.RotateKeyPair(randomBytes(), func() (Signer, error) {
+			t.Fatal("factory called for missing key")
+			return nil, nil
+		})
+		require.Error(t, err)
+	})
+}
+
+// oakleyGroup2Prime is the 1024-bit MODP prime from RFC 2409, used to create key pairs of a type that is not
+// supported as a Signer.
+const oakleyGroup2Prime = "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74020BBEA63B139B22514A08798E3404DDEF" +
+	"9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F" +
+	"24117C4B1FE649286651ECE65381FFFFFFFFFFFFFFFF"
+
+func TestFindingAllKeyPairsSkipsUnsupportedTypes(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_DH_PKCS_KEY_PAIR_GEN)
+
+		prime, err := hex.DecodeString(oakleyGroup2Prime)
+		require.NoError(t, err)
+
+		id := randomBytes()
+		var pubHandle, privHandle pkcs11.ObjectHandle
+		err = ctx.withRWSession(func(session *pkcs11Session) (err error) {
+			pubHandle, privHandle, err = session.ctx.GenerateKeyPair(session.handle,
+				[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_DH_PKCS_KEY_PAIR_GEN, nil)},
+				[]*pkcs11.Attribute{
+					pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+					pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+					pkcs11.NewAttribute(pkcs11.CKA_PRIME, prime),
+					pkcs11.NewAttribute(pkcs11.CKA_BASE, []byte{2}),
+				},
+				[]*pkcs11.Attribute{
+					pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+					pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+					pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+				})
+			return
+		})
+		require.NoError(t, err)
+		defer func() {
+			_ = (&pkcs11Object{privHandle, ctx}).Delete()
+			_ = (&pkcs11Object{pubHandle, ctx}).Delete()
+		}()
+
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		keys, err := ctx.FindAllKeyPairs()
+		require.NoError(t, err)
+		require.NotEmpty(t, keys)
+	})
+}
+
+func TestCopyKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		id := randomBytes()
+		key, err := ctx.GenerateECDSAKeyPair(id, elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		newID := randomBytes()
+		newLabel := randomBytes()
+		copied, err := ctx.CopyKeyPair(key, newID, newLabel)
+		require.NoError(t, err)
+		defer func() { _ = copied.Delete() }()
+
+		found, err := ctx.FindKeyPair(newID, newLabel)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, key.Public(), found.Public())
+
+		original, err := ctx.FindKeyPair(id, nil)
+		require.NoError(t, err)
+		require.NotNil(t, original)
+
+		_, err = ctx.CopyKeyPair(key, nil, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestCopyUncopyableKey(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		require.NoError(t, template.Set(CkaCopyable, false))
+
+		key, err := ctx.GenerateSecretKeyWithAttributes(template, 128, CipherAES)
+		if isPKCS11Error(err, pkcs11.CKR_ATTRIBUTE_TYPE_INVALID) {
+			t.Skip("token does not support CKA_COPYABLE")
+		}
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		_, err = key.Copy(nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "CKA_COPYABLE")
+	})
+}
+
+func TestSetLabelAndID(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPairWithLabel(randomBytes(), randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		newID := randomBytes()
+		newLabel := randomBytes()
+		setter := key.(IdentifierSetter)
+		require.NoError(t, setter.SetLabel(newLabel))
+		require.NoError(t, setter.SetID(newID))
+
+		found, err := ctx.FindKeyPair(newID, newLabel)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, key.Public(), found.Public())
+
+		// The public key object is updated too
+		public, err := ctx.FindPublicKey(newID, newLabel)
+		require.NoError(t, err)
+		assert.Equal(t, key.Public(), public)
+
+		secret, err := ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = secret.Delete() }()
+
+		require.NoError(t, secret.SetLabel(newLabel))
+		_, gotLabel, err := secret.Identifier()
+		require.NoError(t, err)
+		assert.Equal(t, newLabel, gotLabel)
+	})
+}
+
+func TestSetLabelNotModifiable(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		require.NoError(t, template.Set(CkaModifiable, false))
+
+		key, err := ctx.GenerateSecretKeyWithAttributes(template, 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		err = key.SetLabel(randomBytes())
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrNotModifiable))
+	})
+}
+
+func TestEphemeralKeys(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		isToken, err := key.(*pkcs11PrivateKeyECDSA).IsToken()
+		require.NoError(t, err)
+		assert.True(t, isToken)
+
+		ephemeral, err := ctx.GenerateECDSAKeyPairEphemeral(elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = ephemeral.Delete() }()
+
+		isToken, err = ephemeral.(*pkcs11PrivateKeyECDSA).IsToken()
+		require.NoError(t, err)
+		assert.False(t, isToken)
+		testEcdsaSigning(t, ephemeral, crypto.SHA256, "P-256", "SHA-256")
+
+		secret, err := ctx.GenerateSecretKeyWithOptions(randomBytes(), nil, 128, CipherAES,
+			KeyGenOptions{Ephemeral: true})
+		require.NoError(t, err)
+		defer func() { _ = secret.Delete() }()
+
+		isToken, err = secret.IsToken()
+		require.NoError(t, err)
+		assert.False(t, isToken)
+	})
+}
+
+func TestIsSessionObject(t *testing.T) {
+	template := NewAttributeSet()
+	assert.False(t, isSessionObject(template))
+
+	require.NoError(t, template.Set(CkaToken, true))
+	assert.False(t, isSessionObject(template))
+
+	require.NoError(t, template.Set(CkaToken, false))
+	assert.True(t, isSessionObject(template))
+}
+
+func TestKeyGenOptionsValidation(t *testing.T) {
+	yes, no := true, false
+
+	require.NoError(t, KeyGenOptions{}.validate())
+	require.NoError(t, KeyGenOptions{Extractable: &no, NeverExtractable: true}.validate())
+	require.NoError(t, KeyGenOptions{Sensitive: &yes, AlwaysSensitive: true}.validate())
+	require.Error(t, KeyGenOptions{Extractable: &yes, NeverExtractable: true}.validate())
+	require.Error(t, KeyGenOptions{Sensitive: &no, AlwaysSensitive: true}.validate())
+	require.NoError(t, KeyGenOptions{PublicExponent: 3}.validate())
+	require.Error(t, KeyGenOptions{PublicExponent: 65536}.validate())
+	require.Error(t, KeyGenOptions{PublicExponent: 1}.validate())
+	require.Error(t, KeyGenOptions{PublicExponent: -3}.validate())
+	require.Error(t, KeyGenOptions{PublicExponent: 3}.validateNonRSA())
+
+	template := NewAttributeSet()
+	KeyGenOptions{Sensitive: &no, Extractable: &yes}.apply(template)
+	require.Equal(t, []byte{0}, template[CkaSensitive].Value)
+	require.Equal(t, []byte{1}, template[CkaExtractable].Value)
+}
+
+func TestGeneratingKeysWithOptions(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		yes, no := true, false
+
+		key, err := ctx.GenerateRSAKeyPairWithOptions(randomBytes(), nil, rsaSize,
+			KeyGenOptions{Sensitive: &no, Extractable: &yes})
+		require.NoError(t, err)
+		defer func(k Signer) { _ = k.Delete() }(key)
+
+		attrs, err := ctx.GetAttributes(key, []AttributeType{CkaSensitive, CkaExtractable})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0}, attrs[CkaSensitive].Value)
+		require.Equal(t, []byte{1}, attrs[CkaExtractable].Value)
+
+		secret, err := ctx.GenerateSecretKeyWithOptions(randomBytes(), randomBytes(), 128, CipherAES,
+			KeyGenOptions{NeverExtractable: true, AlwaysSensitive: true})
+		require.NoError(t, err)
+		defer func(k *SecretKey) { _ = k.Delete() }(secret)
+
+		attrs, err = ctx.GetAttributes(secret, []AttributeType{CkaNeverExtractable, CkaAlwaysSensitive})
+		require.NoError(t, err)
+		require.Equal(t, []byte{1}, attrs[CkaNeverExtractable].Value)
+		require.Equal(t, []byte{1}, attrs[CkaAlwaysSensitive].Value)
+
+		_, err = ctx.GenerateECDSAKeyPairWithOptions(randomBytes(), nil, elliptic.P256(),
+			KeyGenOptions{Extractable: &yes, NeverExtractable: true})
+		require.Error(t, err)
+	})
+}
+
+func TestDeleteObjectsByLabelPrefix(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		prefix := append(randomBytes(), '-')
+
+		// The public half is deliberately unlabelled, so it must be found by CKA_ID.
+		id := randomBytes()
+		public, err := NewAttributeSetWithID(id)
+		require.NoError(t, err)
+		private, err := NewAttributeSetWithIDAndLabel(id, []byte(string(prefix)+"key"))
+		require.NoError(t, err)
+		_, err = ctx.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P256())
+		require.NoError(t, err)
+
+		// A public half with another label is outside the prefix, so must be left alone.
+		labelledID := randomBytes()
+		otherLabel := randomBytes()
+		public, err = NewAttributeSetWithIDAndLabel(labelledID, otherLabel)
+		require.NoError(t, err)
+		private, err = NewAttributeSetWithIDAndLabel(labelledID, []byte(string(prefix)+"labelled"))
+		require.NoError(t, err)
+		_, err = ctx.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P256())
+		require.NoError(t, err)
+
+		cert := generateRandomCert(t, nil, "Test", nil, nil)
+		err = ctx.ImportCertificateWithLabel(randomBytes(), []byte(string(prefix)+"cert"), cert)
+		require.NoError(t, err)
+
+		otherID := randomBytes()
+		other, err := ctx.GenerateECDSAKeyPairWithLabel(otherID, randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = other.Delete() }()
+
+		n, err := ctx.DeleteObjectsByLabelPrefix(prefix)
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+
+		found, err := ctx.FindKeyPair(id, nil)
+		require.NoError(t, err)
+		assert.Nil(t, found)
+
+		remaining, err := ctx.FindPublicKey(labelledID, otherLabel)
+		require.NoError(t, err)
+		assert.NotNil(t, remaining)
+		_, err = ctx.DeleteObjectsByLabelPrefix(otherLabel)
+		require.NoError(t, err)
+
+		foundCert, err := ctx.FindCertificate(nil, []byte(string(prefix)+"cert"), nil)
+		require.NoError(t, err)
+		assert.Nil(t, foundCert)
+
+		found, err = ctx.FindKeyPair(otherID, nil)
+		require.NoError(t, err)
+		assert.NotNil(t, found)
+
+		_, err = ctx.DeleteObjectsByLabelPrefix(nil)
+		require.Error(t, err)
+	})
+}
+
+func TestSecretKeyUsage(t *testing.T) {
+	require.NoError(t, SecretKeyUsage(0).validate())
+	require.NoError(t, (SecretKeyWrap | SecretKeyDerive).validate())
+	require.Error(t, SecretKeyUsage(1<<10).validate())
+
+	template := NewAttributeSet()
+	SecretKeyUsage(0).apply(template)
+	require.Equal(t, []byte{1}, template[CkaEncrypt].Value)
+	require.Equal(t, []byte{1}, template[CkaDecrypt].Value)
+	require.Equal(t, []byte{0}, template[CkaWrap].Value)
+
+	template = NewAttributeSet()
+	(SecretKeyWrap | SecretKeyUnwrap | SecretKeyDerive).apply(template)
+	require.Equal(t, []byte{0}, template[CkaEncrypt].Value)
+	require.Equal(t, []byte{1}, template[CkaWrap].Value)
+	require.Equal(t, []byte{1}, template[CkaUnwrap].Value)
+	require.Equal(t, []byte{1}, template[CkaDerive].Value)
+}
+
+func TestGeneratingSecretKeyWithUsage(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateSecretKeyWithUsage(randomBytes(), nil, 128, CipherAES,
+			SecretKeyWrap|SecretKeyUnwrap|SecretKeyDerive)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		attrs, err := ctx.GetAttributes(key, []AttributeType{CkaEncrypt, CkaWrap, CkaUnwrap, CkaDerive})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0}, attrs[CkaEncrypt].Value)
+		require.Equal(t, []byte{1}, attrs[CkaWrap].Value)
+		require.Equal(t, []byte{1}, attrs[CkaUnwrap].Value)
+		require.Equal(t, []byte{1}, attrs[CkaDerive].Value)
+	})
+}
+
+func TestRefreshPublic(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		refresher := key.(PublicKeyRefresher)
+		handle := refresher.PublicHandle()
+		require.NotZero(t, handle)
+
+		label := randomBytes()
+		err = ctx.withRWSession(func(session *pkcs11Session) error {
+			return session.ctx.SetAttributeValue(session.handle, handle,
+				[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, label)})
+		})
+		require.NoError(t, err)
+
+		public := key.Public()
+		require.NoError(t, refresher.RefreshPublic())
+		assert.True(t, publicKeysEqual(public, key.Public()))
+
+		attr, err := ctx.GetPubAttribute(key, CkaLabel)
+		require.NoError(t, err)
+		assert.Equal(t, label, attr.Value)
+	})
+}
+
+func TestAlwaysAuthenticate(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateECDSAKeyPairWithOptions(randomBytes(), nil, elliptic.P256(),
+			KeyGenOptions{AlwaysAuthenticate: true})
+		require.NoError(t, err)
+		defer func(k Signer) { _ = k.Delete() }(key)
+
+		always, err := key.(AlwaysAuthenticator).AlwaysAuthenticate()
+		require.NoError(t, err)
+		assert.True(t, always)
+
+		other, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func(k Signer) { _ = k.Delete() }(other)
+
+		always, err = other.(AlwaysAuthenticator).AlwaysAuthenticate()
+		require.NoError(t, err)
+		assert.False(t, always)
+	})
+}
+
+func TestKeySizeError(t *testing.T) {
+	info := pkcs11.MechanismInfo{MinKeySize: 1024, MaxKeySize: 4096}
+	require.NoError(t, keySizeError(info, pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, "RSA modulus", 2048))
+
+	err := keySizeError(info, pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, "RSA modulus", 512)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1024-4096 bits")
+	assert.Contains(t, err.Error(), "CKM_RSA_PKCS_KEY_PAIR_GEN")
+
+	require.Error(t, keySizeError(info, pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, "RSA modulus", 8192))
+
+	unbounded := pkcs11.MechanismInfo{MinKeySize: 1024}
+	require.NoError(t, keySizeError(unbounded, pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, "RSA modulus", 8192))
+}
+
+func TestGenerateRSAKeyPairOutsideTokenRange(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		info, err := ctx.MechanismInfo(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN)
+		require.NoError(t, err)
+		if info.MinKeySize <= 256 {
+			t.Skip("token allows very small RSA keys")
+		}
+
+		_, err = ctx.GenerateRSAKeyPair(randomBytes(), 256)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "RSA modulus of 256 bits")
+	})
+}
+
+func TestGenerateWithRandomID(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key1, id1, err := ctx.GenerateECDSAKeyPairWithRandomID(nil, elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key1.Delete() }()
+		assert.Len(t, id1, randomIDLength)
+
+		label := randomBytes()
+		key2, id2, err := ctx.GenerateRSAKeyPairWithRandomID(label, rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = key2.Delete() }()
+		assert.NotEqual(t, id1, id2)
+
+		found, err := ctx.FindKeyPair(id1, nil)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, key1.Public(), found.Public())
+
+		found, err = ctx.FindKeyPair(id2, label)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+
+		secret, id3, err := ctx.GenerateSecretKeyWithRandomID(nil, 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = secret.Delete() }()
+
+		foundSecret, err := ctx.FindKey(id3, nil)
+		require.NoError(t, err)
+		require.NotNil(t, foundSecret)
+	})
+}
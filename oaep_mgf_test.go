@@ -0,0 +1,57 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build go1.20
+// +build go1.20
+
+package crypto11
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAEPWithMGFHash(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		plaintext := []byte("encrypt me with OAEP")
+		label := []byte("envelope")
+		ciphertext, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, key.Public().(*rsa.PublicKey), plaintext,
+			label)
+		require.NoError(t, err)
+
+		decrypted, err := key.Decrypt(rand.Reader, ciphertext,
+			&rsa.OAEPOptions{Hash: crypto.SHA256, MGFHash: crypto.SHA256, Label: label})
+		require.NoError(t, err)
+		require.Equal(t, plaintext, decrypted)
+
+		_, err = key.Decrypt(rand.Reader, ciphertext,
+			&rsa.OAEPOptions{Hash: crypto.SHA256, MGFHash: crypto.MD5, Label: label})
+		require.Equal(t, errUnsupportedRSAOptions, err)
+	})
+}
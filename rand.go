@@ -22,9 +22,35 @@
 package crypto11
 
 import (
+	"errors"
 	"io"
 )
 
+// GenerateRandom returns length random bytes from the random number generator on the token. A zero length returns an
+// empty slice without using the token.
+func (c *Context) GenerateRandom(length int) ([]byte, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if length < 0 {
+		return nil, errors.New("length must not be negative")
+	}
+	if length == 0 {
+		return []byte{}, nil
+	}
+
+	var result []byte
+	err := c.withSession(func(session *pkcs11Session) (err error) {
+		result, err = session.ctx.GenerateRandom(session.handle, length)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // NewRandomReader returns a reader for the random number generator on the token.
 func (c *Context) NewRandomReader() (io.Reader, error) {
 	if c.closed.Get() {
@@ -41,14 +67,10 @@ type pkcs11RandReader struct {
 
 // This implements the Reader interface for pkcs11RandReader.
 func (r pkcs11RandReader) Read(data []byte) (n int, err error) {
-	var result []byte
-
-	if err = r.context.withSession(func(session *pkcs11Session) error {
-		result, err = r.context.ctx.GenerateRandom(session.handle, len(data))
-		return err
-	}); err != nil {
+	result, err := r.context.GenerateRandom(len(data))
+	if err != nil {
 		return 0, err
 	}
 	copy(data, result)
-	return len(result), err
+	return len(result), nil
 }
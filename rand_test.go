@@ -46,3 +46,31 @@ func TestRandomReader(t *testing.T) {
 		require.Equal(t, size, n)
 	}
 }
+
+func TestGenerateRandom(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		for _, size := range []int{1, 16, 347} {
+			result, err := ctx.GenerateRandom(size)
+			require.NoError(t, err)
+			require.Len(t, result, size)
+		}
+	})
+}
+
+func TestGenerateRandomEdgeCases(t *testing.T) {
+	ctx := newTestContext(&Config{}, 1)
+	defer ctx.pool.Close()
+
+	// A zero length must not use the token, which a test context cannot do
+	result, err := ctx.GenerateRandom(0)
+	require.NoError(t, err)
+	require.NotNil(t, result)
+	require.Empty(t, result)
+
+	_, err = ctx.GenerateRandom(-1)
+	require.Error(t, err)
+
+	ctx.closed.Set(true)
+	_, err = ctx.GenerateRandom(16)
+	require.Equal(t, errClosed, err)
+}
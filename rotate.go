@@ -0,0 +1,110 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"time"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// archiveLabelSuffix is appended to the label of a key pair that has been replaced by RotateKeyPair, followed by
+// the UTC time of the rotation.
+const archiveLabelSuffix = "-archived-"
+
+// RotateKeyPair replaces the key pair labelled currentLabel with a newly generated one.
+//
+// newKeyFactory must generate the new key pair, typically with a temporary label. The new key pair is then relabelled
+// to currentLabel, after which the old key pair is relabelled to currentLabel followed by "-archived-" and the UTC
+// time of the rotation. Because the new key takes the current label before the old one gives it up,
+// FindKeyPair(nil, currentLabel) always finds one of the two keys during the rotation.
+//
+// If the new key pair cannot be relabelled, or the old key pair cannot be archived, the new key pair is deleted and
+// the old key pair keeps currentLabel. The new key pair is given back its temporary label first, where possible, so
+// that it can be identified should deletion also fail. The old and new key pairs are returned on success.
+func (c *Context) RotateKeyPair(currentLabel []byte, newKeyFactory func() (Signer, error)) (old, new Signer, err error) {
+	if c.closed.Get() {
+		return nil, nil, errClosed
+	}
+
+	if err = notNilBytes(currentLabel, "currentLabel"); err != nil {
+		return nil, nil, err
+	}
+
+	old, err = c.FindKeyPair(nil, currentLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if old == nil {
+		return nil, nil, errors.Errorf("no key pair found with label %q", currentLabel)
+	}
+
+	oldKey, ok := old.(keyPair)
+	if !ok {
+		return nil, nil, errors.New("not a PKCS#11 key pair")
+	}
+
+	new, err = newKeyFactory()
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed to generate new key pair")
+	}
+
+	newKey, ok := new.(keyPair)
+	if !ok {
+		if new != nil {
+			_ = new.Delete()
+		}
+		return nil, nil, errors.New("new key factory did not return a PKCS#11 key pair")
+	}
+
+	archiveLabel := append(append([]byte(nil), currentLabel...),
+		archiveLabelSuffix+time.Now().UTC().Format("20060102T150405Z")...)
+
+	err = c.withRWSession(func(session *pkcs11Session) error {
+		attributes, err := session.ctx.GetAttributeValue(session.handle, newKey.privateKey().handle,
+			[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil)})
+		if err != nil {
+			return err
+		}
+		tempLabel := attributes[0].Value
+
+		if err = newKey.privateKey().setLabel(session, currentLabel); err != nil {
+			return errors.WithMessage(err, "failed to relabel new key pair")
+		}
+
+		if err = oldKey.privateKey().setLabel(session, archiveLabel); err != nil {
+			// Restore the new key's label, so it can be identified if deletion fails
+			_ = newKey.privateKey().setLabel(session, tempLabel)
+			return errors.WithMessage(err, "failed to relabel old key pair")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		_ = new.Delete()
+		return nil, nil, err
+	}
+
+	return old, new, nil
+}
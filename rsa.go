@@ -22,11 +22,15 @@
 package crypto11
 
 import (
+	"bytes"
+	"context"
 	"crypto"
 	"crypto/rsa"
 	"errors"
+	"fmt"
 	"io"
 	"math/big"
+	"sync"
 
 	"github.com/miekg/pkcs11"
 )
@@ -40,8 +44,8 @@ var errMalformedRSAPublicKey = errors.New("malformed RSA public key")
 // errUnsupportedRSAOptions is returned when an unsupported RSA option is requested.
 //
 // Currently this means a nontrivial SessionKeyLen when decrypting; or
-// an unsupported hash function; or crypto.rsa.PSSSaltLengthAuto was
-// requested.
+// an unsupported hash function; or a negative PSS salt length other
+// than crypto.rsa.PSSSaltLengthAuto or PSSSaltLengthEqualsHash.
 var errUnsupportedRSAOptions = errors.New("unsupported RSA option value")
 
 // pkcs11PrivateKeyRSA contains a reference to a loaded PKCS#11 RSA private key object.
@@ -116,6 +120,167 @@ func (c *Context) GenerateRSAKeyPairWithLabel(id, label []byte, bits int) (Signe
 	return c.GenerateRSAKeyPairWithAttributes(public, private, bits)
 }
 
+// GenerateRSAKeyPairWithRandomID creates an RSA key pair on the token, like GenerateRSAKeyPair, with a random CKA_ID
+// that no other object on the token has. The CKA_ID is returned along with the key. If label is non-nil, it is used
+// to set CKA_LABEL.
+func (c *Context) GenerateRSAKeyPairWithRandomID(label []byte, bits int) (SignerDecrypter, []byte, error) {
+	if c.closed.Get() {
+		return nil, nil, errClosed
+	}
+
+	var k SignerDecrypter
+	id, err := c.withRandomID(func(id []byte) (err error) {
+		k, err = c.GenerateRSAKeyPairWithOptions(id, label, bits, KeyGenOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, id, nil
+}
+
+// GenerateRSAKeyPairWithUsage creates an RSA key pair on the token, permitting only the operations selected in usage.
+// The id parameter is used to set CKA_ID and must be non-nil. If label is non-nil, it is used to set CKA_LABEL.
+// The public exponent is 65537.
+//
+// For example, a key pair used solely to wrap and unwrap other keys can be created with KeyUsage{Wrap: true}.
+func (c *Context) GenerateRSAKeyPairWithUsage(id, label []byte, bits int, usage KeyUsage) (SignerDecrypter, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if err := usage.validate(); err != nil {
+		return nil, err
+	}
+
+	var public AttributeSet
+	var err error
+	if label == nil {
+		public, err = NewAttributeSetWithID(id)
+	} else {
+		public, err = NewAttributeSetWithIDAndLabel(id, label)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Copy the AttributeSet to allow modifications.
+	private := public.Copy()
+
+	usage.apply(public, private)
+
+	return c.GenerateRSAKeyPairWithAttributes(public, private, bits)
+}
+
+// GenerateRSAKeyPairWithOptions creates an RSA key pair on the token, protecting the private key as selected in
+// opts. The id parameter is used to set CKA_ID and must be non-nil. If label is non-nil, it is used to set CKA_LABEL.
+func (c *Context) GenerateRSAKeyPairWithOptions(id, label []byte, bits int, opts KeyGenOptions) (SignerDecrypter,
+	error) {
+
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	public, err := newKeyAttributeSet(id, label)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the AttributeSet to allow modifications.
+	private := public.Copy()
+
+	opts.apply(private)
+	opts.applyPublic(public)
+	if opts.PublicExponent != 0 {
+		_ = public.Set(CkaPublicExponent, big.NewInt(int64(opts.PublicExponent)).Bytes())
+	}
+
+	return c.GenerateRSAKeyPairWithAttributes(public, private, bits)
+}
+
+// KeySpec describes a key pair to be generated by GenerateManyRSAKeyPairs.
+type KeySpec struct {
+	// ID is used to set CKA_ID and must be non-nil.
+	ID []byte
+
+	// Label is used to set CKA_LABEL, if non-nil.
+	Label []byte
+
+	// Bits is the size of the key.
+	Bits int
+}
+
+// GenResult is the outcome of generating the key pair described by a KeySpec.
+type GenResult struct {
+	// ID is the ID from the KeySpec.
+	ID []byte
+
+	// Key is the generated key pair, or nil if generation failed.
+	Key SignerDecrypter
+
+	// Err is the error encountered generating the key pair, if any.
+	Err error
+}
+
+// GenerateManyRSAKeyPairs generates an RSA key pair for each of specs, running up to parallelism generations
+// concurrently. Parallelism is further limited by the number of sessions in the pool, and to one if
+// Config.SingleThreaded is set. Key pairs are generated as by GenerateRSAKeyPair.
+//
+// A result is sent on the returned channel as each generation completes, in no particular order. The channel is
+// closed once all key pairs have been generated. It is buffered to hold every result, so callers may stop receiving
+// early without leaking goroutines.
+func (c *Context) GenerateManyRSAKeyPairs(specs []KeySpec, parallelism int) (<-chan GenResult, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if parallelism < 1 {
+		return nil, errors.New("parallelism must be at least 1")
+	}
+	parallelism = min(parallelism, int(c.pool.Capacity()))
+	if c.cfg.SingleThreaded {
+		// The single session cannot be shared between goroutines
+		parallelism = 1
+	}
+
+	results := make(chan GenResult, len(specs))
+	work := make(chan KeySpec)
+
+	go func() {
+		defer close(work)
+		for _, spec := range specs {
+			work <- spec
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(parallelism)
+	for i := 0; i < parallelism; i++ {
+		go func() {
+			defer wg.Done()
+			for spec := range work {
+				var key SignerDecrypter
+				var err error
+				if spec.Label == nil {
+					key, err = c.GenerateRSAKeyPair(spec.ID, spec.Bits)
+				} else {
+					key, err = c.GenerateRSAKeyPairWithLabel(spec.ID, spec.Label, spec.Bits)
+				}
+				results <- GenResult{ID: spec.ID, Key: key, Err: err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results, nil
+}
+
 // GenerateRSAKeyPairWithAttributes generates an RSA key pair on the token. After this function returns, public and
 // private will contain the attributes applied to the key pair. If required attributes are missing, they will be set to
 // a default value.
@@ -126,7 +291,7 @@ func (c *Context) GenerateRSAKeyPairWithAttributes(public, private AttributeSet,
 
 	var k SignerDecrypter
 
-	err := c.withSession(func(session *pkcs11Session) error {
+	err := c.withObjectSession(private, func(session *pkcs11Session) error {
 
 		public.AddIfNotPresent([]*pkcs11.Attribute{
 			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
@@ -145,13 +310,17 @@ func (c *Context) GenerateRSAKeyPairWithAttributes(public, private AttributeSet,
 			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
 		})
 
+		if err := c.checkKeySize(session, pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, "RSA modulus", bits); err != nil {
+			return err
+		}
+
 		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)}
 		pubHandle, privHandle, err := session.ctx.GenerateKeyPair(session.handle,
 			mech,
 			public.ToSlice(),
 			private.ToSlice())
 		if err != nil {
-			return err
+			return publicExponentError(err, public)
 		}
 
 		pub, err := exportRSAPublicKey(session, pubHandle)
@@ -166,34 +335,60 @@ func (c *Context) GenerateRSAKeyPairWithAttributes(public, private AttributeSet,
 				},
 				pubKeyHandle: pubHandle,
 				pubKey:       pub,
+				identity:     templateIdentity(private, pkcs11.CKK_RSA),
 			}}
 		return nil
 	})
 	return k, err
 }
 
+// publicExponentError explains err, from generating an RSA key pair with the public template, if the token may have
+// rejected a public exponent other than 65537. Some tokens accept only a few exponents.
+func publicExponentError(err error, public AttributeSet) error {
+	attribute, ok := public[CkaPublicExponent]
+	if !ok || bytes.Equal(attribute.Value, []byte{1, 0, 1}) {
+		return err
+	}
+	if !isPKCS11Error(err, pkcs11.CKR_ATTRIBUTE_VALUE_INVALID) && !isPKCS11Error(err, pkcs11.CKR_TEMPLATE_INCONSISTENT) {
+		return err
+	}
+	return fmt.Errorf("token rejected CKA_PUBLIC_EXPONENT %s: %w", new(big.Int).SetBytes(attribute.Value), err)
+}
+
 // Decrypt decrypts a message using a RSA key.
 //
 // This completes the implemention of crypto.Decrypter for pkcs11PrivateKeyRSA.
 //
 // Note that the SessionKeyLen option (for PKCS#1v1.5 decryption) is not supported.
 //
+// For OAEP, the Hash, MGFHash (from Go 1.20) and Label fields of rsa.OAEPOptions are passed to the token. Some tokens
+// only support an MGF1 hash equal to Hash.
+//
 // The underlying PKCS#11 implementation may impose further restrictions.
 func (priv *pkcs11PrivateKeyRSA) Decrypt(rand io.Reader, ciphertext []byte, options crypto.DecrypterOpts) (plaintext []byte, err error) {
-	err = priv.context.withSession(func(session *pkcs11Session) error {
-		if options == nil {
-			plaintext, err = decryptPKCS1v15(session, priv, ciphertext, 0)
-		} else {
-			switch o := options.(type) {
-			case *rsa.PKCS1v15DecryptOptions:
-				plaintext, err = decryptPKCS1v15(session, priv, ciphertext, o.SessionKeyLen)
-			case *rsa.OAEPOptions:
-				plaintext, err = decryptOAEP(session, priv, ciphertext, o.Hash, o.Label)
-			default:
-				err = errUnsupportedRSAOptions
+	return priv.DecryptContext(context.Background(), rand, ciphertext, options)
+}
+
+// DecryptContext is like Decrypt, but gives up when ctx is done.
+func (priv *pkcs11PrivateKeyRSA) DecryptContext(ctx context.Context, rand io.Reader, ciphertext []byte,
+	options crypto.DecrypterOpts) (plaintext []byte, err error) {
+
+	err = priv.withSessionContext(ctx, func(session *pkcs11Session) error {
+		return priv.context.withContextLogin(session, func() error {
+			if options == nil {
+				plaintext, err = decryptPKCS1v15(session, priv, ciphertext, 0)
+			} else {
+				switch o := options.(type) {
+				case *rsa.PKCS1v15DecryptOptions:
+					plaintext, err = decryptPKCS1v15(session, priv, ciphertext, o.SessionKeyLen)
+				case *rsa.OAEPOptions:
+					plaintext, err = decryptOAEP(session, priv, ciphertext, o.Hash, oaepMGFHash(o), o.Label)
+				default:
+					err = errUnsupportedRSAOptions
+				}
 			}
-		}
-		return err
+			return err
+		})
 	})
 	return plaintext, err
 }
@@ -204,27 +399,159 @@ func decryptPKCS1v15(session *pkcs11Session, key *pkcs11PrivateKeyRSA, ciphertex
 	}
 	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
 	if err := session.ctx.DecryptInit(session.handle, mech, key.handle); err != nil {
-		return nil, err
+		return nil, newOperationError(session, key.handle, "decrypt", pkcs11.CKM_RSA_PKCS, err)
+	}
+	if err := key.context.contextSpecificLogin(session); err != nil {
+		return nil, newOperationError(session, key.handle, "decrypt", pkcs11.CKM_RSA_PKCS, err)
+	}
+	plaintext, err := session.ctx.Decrypt(session.handle, ciphertext)
+	if err != nil {
+		return nil, newOperationError(session, key.handle, "decrypt", pkcs11.CKM_RSA_PKCS, err)
 	}
-	return session.ctx.Decrypt(session.handle, ciphertext)
+	key.context.traceMechanism("decrypt", pkcs11.CKM_RSA_PKCS)
+	return plaintext, nil
 }
 
-func decryptOAEP(session *pkcs11Session, key *pkcs11PrivateKeyRSA, ciphertext []byte, hashFunction crypto.Hash,
+// decryptOAEP decrypts using CKM_RSA_PKCS_OAEP. The MGF1 hash defaults to hashFunction if mgfHash is zero.
+func decryptOAEP(session *pkcs11Session, key *pkcs11PrivateKeyRSA, ciphertext []byte, hashFunction, mgfHash crypto.Hash,
 	label []byte) ([]byte, error) {
 
 	hashAlg, mgfAlg, _, err := hashToPKCS11(hashFunction)
 	if err != nil {
 		return nil, err
 	}
+	if mgfHash == 0 {
+		mgfHash = hashFunction
+	}
+	if mgfHash != hashFunction {
+		if _, mgfAlg, _, err = hashToPKCS11(mgfHash); err != nil {
+			return nil, err
+		}
+	}
 
 	mech := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP,
 		pkcs11.NewOAEPParams(hashAlg, mgfAlg, pkcs11.CKZ_DATA_SPECIFIED, label))
 
 	err = session.ctx.DecryptInit(session.handle, []*pkcs11.Mechanism{mech}, key.handle)
+	if err == nil {
+		err = key.context.contextSpecificLogin(session)
+	}
 	if err != nil {
+		err = newOperationError(session, key.handle, "decrypt", pkcs11.CKM_RSA_PKCS_OAEP, err)
+		if mgfHash != hashFunction && isPKCS11Error(err, pkcs11.CKR_MECHANISM_PARAM_INVALID) {
+			return nil, fmt.Errorf("token rejected OAEP with MGF1 hash %v and digest %v, it may require them to "+
+				"be the same: %w", mgfHash, hashFunction, err)
+		}
 		return nil, err
 	}
-	return session.ctx.Decrypt(session.handle, ciphertext)
+	plaintext, err := session.ctx.Decrypt(session.handle, ciphertext)
+	if err != nil {
+		return nil, newOperationError(session, key.handle, "decrypt", pkcs11.CKM_RSA_PKCS_OAEP, err)
+	}
+	key.context.traceMechanism("decrypt", pkcs11.CKM_RSA_PKCS_OAEP)
+	return plaintext, nil
+}
+
+// EncryptRSA encrypts plaintext with an RSA public key on the token, using C_Encrypt. The key pub may be an RSA key
+// pair from this package, or an *rsa.PublicKey, in which case a public key object with the same modulus and exponent
+// must exist on the token (see ImportPublicKey).
+//
+// If opts is nil or *rsa.PKCS1v15DecryptOptions, PKCS#1 v1.5 padding is used. If it is *rsa.OAEPOptions, OAEP is
+// used with its Hash and Label (and MGFHash, from Go 1.20). The plaintext must be short enough for the modulus and
+// padding.
+func (c *Context) EncryptRSA(pub crypto.PublicKey, opts crypto.DecrypterOpts, plaintext []byte) ([]byte, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	var rsaPub *rsa.PublicKey
+	var priv *pkcs11PrivateKeyRSA
+	switch key := pub.(type) {
+	case *pkcs11PrivateKeyRSA:
+		priv = key
+		rsaPub = key.Public().(*rsa.PublicKey)
+	case *rsa.PublicKey:
+		rsaPub = key
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+
+	mech, maxLen, err := rsaEncryptMechanism(rsaPub, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) > maxLen {
+		return nil, fmt.Errorf("plaintext is %d bytes, but at most %d bytes can be encrypted with this key and "+
+			"padding", len(plaintext), maxLen)
+	}
+
+	var ciphertext []byte
+	encrypt := func(session *pkcs11Session, pubHandle pkcs11.ObjectHandle) error {
+		if err := session.ctx.EncryptInit(session.handle, []*pkcs11.Mechanism{mech}, pubHandle); err != nil {
+			return newOperationError(session, pubHandle, "encrypt", mech.Mechanism, err)
+		}
+		if ciphertext, err = session.ctx.Encrypt(session.handle, plaintext); err != nil {
+			return newOperationError(session, pubHandle, "encrypt", mech.Mechanism, err)
+		}
+		c.traceMechanism("encrypt", mech.Mechanism)
+		return nil
+	}
+
+	if priv != nil {
+		// The key's handles may be replaced if they become invalid, so read the public handle within the session
+		err = priv.withSessionContext(context.Background(), func(session *pkcs11Session) error {
+			if priv.pubKeyHandle == 0 {
+				return errors.New("key has no public key object on the token")
+			}
+			return encrypt(session, priv.pubKeyHandle)
+		})
+	} else {
+		err = c.withSession(func(session *pkcs11Session) error {
+			handles, err := findKeysWithAttributes(session, []*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+				pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+				pkcs11.NewAttribute(pkcs11.CKA_MODULUS, rsaPub.N.Bytes()),
+				pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(int64(rsaPub.E)).Bytes()),
+			})
+			if err != nil {
+				return err
+			}
+			if len(handles) == 0 {
+				return errors.New("public key not found on the token")
+			}
+			return encrypt(session, handles[0])
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ciphertext, nil
+}
+
+// rsaEncryptMechanism returns the mechanism for RSA encryption with the given options, and the longest plaintext it
+// can encrypt with key.
+func rsaEncryptMechanism(key *rsa.PublicKey, opts crypto.DecrypterOpts) (*pkcs11.Mechanism, int, error) {
+	k := (key.N.BitLen() + 7) / 8
+
+	switch o := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+		return pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), k - 11, nil
+	case *rsa.OAEPOptions:
+		hashAlg, mgfAlg, hashLen, err := hashToPKCS11(o.Hash)
+		if err != nil {
+			return nil, 0, err
+		}
+		if mgfHash := oaepMGFHash(o); mgfHash != 0 && mgfHash != o.Hash {
+			if _, mgfAlg, _, err = hashToPKCS11(mgfHash); err != nil {
+				return nil, 0, err
+			}
+		}
+		mech := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP,
+			pkcs11.NewOAEPParams(hashAlg, mgfAlg, pkcs11.CKZ_DATA_SPECIFIED, o.Label))
+		return mech, k - 2*int(hashLen) - 2, nil
+	default:
+		return nil, 0, errUnsupportedRSAOptions
+	}
 }
 
 func hashToPKCS11(hashFunction crypto.Hash) (hashAlg uint, mgfAlg uint, hashLen uint, err error) {
@@ -244,23 +571,38 @@ func hashToPKCS11(hashFunction crypto.Hash) (hashAlg uint, mgfAlg uint, hashLen
 	}
 }
 
+// pssMaxSaltLength returns the largest PSS salt length usable with the given key and hash length (RFC 8017
+// section 9.1.1). The result is negative if the key is too small for the hash.
+func pssMaxSaltLength(pub *rsa.PublicKey, hashLen uint) int {
+	emLen := (pub.N.BitLen() - 1 + 7) / 8
+	return emLen - int(hashLen) - 2
+}
+
 func signPSS(session *pkcs11Session, key *pkcs11PrivateKeyRSA, digest []byte, opts *rsa.PSSOptions) ([]byte, error) {
 	var hMech, mgf, hLen, sLen uint
 	var err error
 	if hMech, mgf, hLen, err = hashToPKCS11(opts.Hash); err != nil {
 		return nil, err
 	}
+	maxSaltLength := pssMaxSaltLength(key.Public().(*rsa.PublicKey), hLen)
 	switch opts.SaltLength {
-	case rsa.PSSSaltLengthAuto: // parseltongue constant
-		// TODO we could (in principle) work out the biggest
-		// possible size from the key, but until someone has
-		// the effort to do that...
-		return nil, errUnsupportedRSAOptions
+	case rsa.PSSSaltLengthAuto:
+		// As crypto/rsa does, use the largest salt the modulus allows
+		if maxSaltLength < 0 {
+			return nil, errors.New("RSA key is too small for PSS with this hash")
+		}
+		sLen = uint(maxSaltLength)
 	case rsa.PSSSaltLengthEqualsHash:
 		sLen = hLen
 	default:
+		if opts.SaltLength < 0 {
+			return nil, errUnsupportedRSAOptions
+		}
 		sLen = uint(opts.SaltLength)
 	}
+	if int(sLen) > maxSaltLength {
+		return nil, fmt.Errorf("PSS salt length %d exceeds the maximum of %d for this key and hash", sLen, maxSaltLength)
+	}
 	// TODO this is pretty horrible, maybe the PKCS#11 wrapper
 	// could be improved to help us out here
 	parameters := concat(ulongToBytes(hMech),
@@ -268,9 +610,17 @@ func signPSS(session *pkcs11Session, key *pkcs11PrivateKeyRSA, digest []byte, op
 		ulongToBytes(sLen))
 	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, parameters)}
 	if err = session.ctx.SignInit(session.handle, mech, key.handle); err != nil {
-		return nil, err
+		return nil, newOperationError(session, key.handle, "sign", pkcs11.CKM_RSA_PKCS_PSS, err)
+	}
+	if err = key.context.contextSpecificLogin(session); err != nil {
+		return nil, newOperationError(session, key.handle, "sign", pkcs11.CKM_RSA_PKCS_PSS, err)
 	}
-	return session.ctx.Sign(session.handle, digest)
+	signature, err := session.ctx.Sign(session.handle, digest)
+	if err != nil {
+		return nil, newOperationError(session, key.handle, "sign", pkcs11.CKM_RSA_PKCS_PSS, err)
+	}
+	key.context.traceMechanism("sign", pkcs11.CKM_RSA_PKCS_PSS)
+	return signature, nil
 }
 
 var pkcs1Prefix = map[crypto.Hash][]byte{
@@ -289,32 +639,148 @@ func signPKCS1v15(session *pkcs11Session, key *pkcs11PrivateKeyRSA, digest []byt
 	copy(T[len(oid):], digest)
 	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
 	err = session.ctx.SignInit(session.handle, mech, key.handle)
+	if err == nil {
+		err = key.context.contextSpecificLogin(session)
+	}
 	if err == nil {
 		signature, err = session.ctx.Sign(session.handle, T)
 	}
+	if err != nil {
+		return nil, newOperationError(session, key.handle, "sign", pkcs11.CKM_RSA_PKCS, err)
+	}
+	key.context.traceMechanism("sign", pkcs11.CKM_RSA_PKCS)
 	return
 }
 
+// CombinedRSASignerOpts selects PKCS#1 v1.5 signing with a combined hash-and-sign mechanism such as
+// CKM_SHA256_RSA_PKCS, for use as the opts argument to Sign. The whole message, rather than its digest, is passed to
+// Sign, and the token hashes it with Hash. If the token does not advertise the combined mechanism, the message is
+// hashed here and signed with CKM_RSA_PKCS, as for other PKCS#1 v1.5 signatures.
+type CombinedRSASignerOpts struct {
+	Hash crypto.Hash
+}
+
+// HashFunc returns the hash function applied to the message.
+func (o CombinedRSASignerOpts) HashFunc() crypto.Hash {
+	return o.Hash
+}
+
+// combinedRSAMechanisms maps hash functions to the PKCS#1 v1.5 mechanisms that hash with them before signing.
+var combinedRSAMechanisms = map[crypto.Hash]uint{
+	crypto.SHA1:   pkcs11.CKM_SHA1_RSA_PKCS,
+	crypto.SHA224: pkcs11.CKM_SHA224_RSA_PKCS,
+	crypto.SHA256: pkcs11.CKM_SHA256_RSA_PKCS,
+	crypto.SHA384: pkcs11.CKM_SHA384_RSA_PKCS,
+	crypto.SHA512: pkcs11.CKM_SHA512_RSA_PKCS,
+}
+
+func signCombined(session *pkcs11Session, key *pkcs11PrivateKeyRSA, message []byte, hash crypto.Hash) ([]byte, error) {
+	mechanism, ok := combinedRSAMechanisms[hash]
+	if !ok {
+		return nil, fmt.Errorf("no combined RSA signing mechanism for hash function %v", hash)
+	}
+
+	supported, err := key.context.mechanismSupported(mechanism)
+	if err != nil || !supported {
+		if !hash.Available() {
+			return nil, fmt.Errorf("hash function %v is not available", hash)
+		}
+		h := hash.New()
+		h.Write(message)
+		return signPKCS1v15(session, key, h.Sum(nil), hash)
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)}
+	err = session.ctx.SignInit(session.handle, mech, key.handle)
+	if err == nil {
+		err = key.context.contextSpecificLogin(session)
+	}
+	var signature []byte
+	if err == nil {
+		signature, err = session.ctx.Sign(session.handle, message)
+	}
+	if err != nil {
+		return nil, newOperationError(session, key.handle, "sign", mechanism, err)
+	}
+	key.context.traceMechanism("sign", mechanism)
+	return signature, nil
+}
+
+// RawRSASignerOpts selects raw RSA signing with CKM_RSA_X_509, for use as the opts argument to Sign. The data to be
+// signed must already be padded, and its length must match the size of the key's modulus; it is passed to the token
+// unmodified.
+type RawRSASignerOpts struct{}
+
+// HashFunc returns zero, as raw signing does not hash its input.
+func (RawRSASignerOpts) HashFunc() crypto.Hash {
+	return 0
+}
+
+func signRaw(session *pkcs11Session, key *pkcs11PrivateKeyRSA, data []byte) ([]byte, error) {
+	modulusLen := (key.Public().(*rsa.PublicKey).N.BitLen() + 7) / 8
+	if len(data) != modulusLen {
+		return nil, fmt.Errorf("raw RSA signing requires input of exactly %d bytes (the modulus size), got %d",
+			modulusLen, len(data))
+	}
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_X_509, nil)}
+	if err := session.ctx.SignInit(session.handle, mech, key.handle); err != nil {
+		return nil, newOperationError(session, key.handle, "sign", pkcs11.CKM_RSA_X_509, err)
+	}
+	if err := key.context.contextSpecificLogin(session); err != nil {
+		return nil, newOperationError(session, key.handle, "sign", pkcs11.CKM_RSA_X_509, err)
+	}
+	signature, err := session.ctx.Sign(session.handle, data)
+	if err != nil {
+		return nil, newOperationError(session, key.handle, "sign", pkcs11.CKM_RSA_X_509, err)
+	}
+	key.context.traceMechanism("sign", pkcs11.CKM_RSA_X_509)
+	return signature, nil
+}
+
 // Sign signs a message using a RSA key.
 //
 // This completes the implemention of crypto.Signer for pkcs11PrivateKeyRSA.
 //
 // PKCS#11 expects to pick its own random data where necessary for signatures, so the rand argument is ignored.
 //
-// Note that (at present) the crypto.rsa.PSSSaltLengthAuto option is
-// not supported. The caller must either use
-// crypto.rsa.PSSSaltLengthEqualsHash (recommended) or pass an
-// explicit salt length. Moreover the underlying PKCS#11
-// implementation may impose further restrictions.
+// If opts is a RawRSASignerOpts, digest is signed as-is using CKM_RSA_X_509.
+//
+// If opts is a CombinedRSASignerOpts, digest is the whole message, which the token hashes and signs.
+//
+// For PSS, crypto.rsa.PSSSaltLengthAuto selects the largest salt the
+// modulus allows, as crypto/rsa does. crypto.rsa.PSSSaltLengthEqualsHash
+// (recommended) or an explicit salt length may also be used. The
+// underlying PKCS#11 implementation may impose further restrictions.
 func (priv *pkcs11PrivateKeyRSA) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
-	err = priv.context.withSession(func(session *pkcs11Session) error {
-		switch opts.(type) {
-		case *rsa.PSSOptions:
-			signature, err = signPSS(session, priv, digest, opts.(*rsa.PSSOptions))
-		default: /* PKCS1-v1_5 */
-			signature, err = signPKCS1v15(session, priv, digest, opts.HashFunc())
-		}
-		return err
+	return priv.SignContext(context.Background(), rand, digest, opts)
+}
+
+// SignBatch implements BatchSigner.
+func (priv *pkcs11PrivateKeyRSA) SignBatch(rand io.Reader, digests [][]byte,
+	opts crypto.SignerOpts) ([][]byte, error) {
+
+	return signBatch(priv, &priv.pkcs11PrivateKey, rand, digests, opts)
+}
+
+// SignContext is like Sign, but gives up when ctx is done.
+func (priv *pkcs11PrivateKeyRSA) SignContext(ctx context.Context, rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) (signature []byte, err error) {
+
+	err = priv.withSessionContext(ctx, func(session *pkcs11Session) error {
+		return priv.context.withContextLogin(session, func() error {
+			switch opts.(type) {
+			case *rsa.PSSOptions:
+				signature, err = signPSS(session, priv, digest, opts.(*rsa.PSSOptions))
+			case RawRSASignerOpts, *RawRSASignerOpts:
+				signature, err = signRaw(session, priv, digest)
+			case CombinedRSASignerOpts, *CombinedRSASignerOpts:
+				signature, err = signCombined(session, priv, digest, opts.HashFunc())
+			default: /* PKCS1-v1_5 */
+				signature, err = signPKCS1v15(session, priv, digest, opts.HashFunc())
+			}
+			return err
+		})
 	})
 
 	if err != nil {
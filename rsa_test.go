@@ -23,12 +23,15 @@ package crypto11
 
 import (
 	"bytes"
+	"context"
 	"crypto"
+	"crypto/aes"
 	"crypto/rand"
 	"crypto/rsa"
-	_ "crypto/sha1"
+	"crypto/sha1"
 	_ "crypto/sha256"
 	_ "crypto/sha512"
+	"errors"
 	"testing"
 
 	"github.com/miekg/pkcs11"
@@ -262,3 +265,403 @@ func TestRsaRequiredArgs(t *testing.T) {
 	_, err = ctx.GenerateRSAKeyPairWithLabel(val, nil, 2048)
 	require.Error(t, err)
 }
+
+func TestRsaKeyUsage(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		_, err := ctx.GenerateRSAKeyPairWithUsage(randomBytes(), nil, rsaSize, KeyUsage{})
+		require.Equal(t, errNoKeyUsage, err)
+
+		key, err := ctx.GenerateRSAKeyPairWithUsage(randomBytes(), randomBytes(), rsaSize, KeyUsage{Wrap: true})
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		attrs, err := ctx.GetAttributes(key, []AttributeType{CkaSign, CkaDecrypt, CkaUnwrap})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0}, attrs[CkaSign].Value)
+		require.Equal(t, []byte{0}, attrs[CkaDecrypt].Value)
+		require.Equal(t, []byte{1}, attrs[CkaUnwrap].Value)
+
+		pubAttrs, err := ctx.GetPubAttributes(key, []AttributeType{CkaVerify, CkaEncrypt, CkaWrap})
+		require.NoError(t, err)
+		require.Equal(t, []byte{0}, pubAttrs[CkaVerify].Value)
+		require.Equal(t, []byte{0}, pubAttrs[CkaEncrypt].Value)
+		require.Equal(t, []byte{1}, pubAttrs[CkaWrap].Value)
+
+		_, err = key.Sign(rand.Reader, make([]byte, 32), crypto.SHA256)
+		require.Error(t, err)
+	})
+}
+
+func TestGenerationWithReadOnlySessions(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		// The pool is filled lazily, so all pooled sessions will now be read-only
+		ctx.readOnlySessions = true
+
+		key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+		require.NoError(t, err)
+
+		testRsaSigningPKCS1v15(t, key, crypto.SHA256)
+
+		require.NoError(t, key.Delete())
+	})
+}
+
+func TestImportWrappedSecretKey(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		unwrappingKey, err := ctx.GenerateRSAKeyPairWithUsage(randomBytes(), nil, rsaSize, KeyUsage{Wrap: true})
+		require.NoError(t, err)
+		defer func() { _ = unwrappingKey.Delete() }()
+
+		aesKey := make([]byte, 32)
+		_, err = rand.Read(aesKey)
+		require.NoError(t, err)
+
+		wrapped, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, unwrappingKey.Public().(*rsa.PublicKey), aesKey, nil)
+		require.NoError(t, err)
+
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		_ = template.Set(CkaKeyType, pkcs11.CKK_AES)
+
+		mech := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP,
+			pkcs11.NewOAEPParams(pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1, pkcs11.CKZ_DATA_SPECIFIED, nil))
+
+		key, err := ctx.ImportWrappedSecretKey(unwrappingKey, mech, wrapped, template)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		// The imported key must encrypt exactly as the original does
+		block, err := aes.NewCipher(aesKey)
+		require.NoError(t, err)
+
+		plaintext := randomBytes()
+		expected := make([]byte, aes.BlockSize)
+		block.Encrypt(expected, plaintext)
+
+		actual := make([]byte, aes.BlockSize)
+		key.Encrypt(actual, plaintext)
+		require.Equal(t, expected, actual)
+
+		_, err = ctx.ImportWrappedSecretKey(unwrappingKey, mech, wrapped, NewAttributeSet())
+		require.Error(t, err)
+	})
+}
+
+func TestMechanismTracer(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	var traced []uint
+	cfg.MechanismTracer = func(operation string, mechanism uint) {
+		require.Equal(t, "sign", operation)
+		traced = append(traced, mechanism)
+	}
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+	require.NoError(t, err)
+	defer func() { _ = key.Delete() }()
+
+	digest := crypto.SHA256.New().Sum(nil)
+	_, err = key.Sign(rand.Reader, digest, crypto.SHA256)
+	require.NoError(t, err)
+	_, err = key.Sign(rand.Reader, digest, &rsa.PSSOptions{Hash: crypto.SHA256, SaltLength: rsa.PSSSaltLengthEqualsHash})
+	require.NoError(t, err)
+
+	require.Equal(t, []uint{pkcs11.CKM_RSA_PKCS, pkcs11.CKM_RSA_PKCS_PSS}, traced)
+}
+
+func TestGenerateManyRSAKeyPairs(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		specs := []KeySpec{
+			{ID: randomBytes(), Bits: rsaSize},
+			{ID: randomBytes(), Label: randomBytes(), Bits: rsaSize},
+			{ID: randomBytes(), Bits: rsaSize},
+			{ID: nil, Bits: rsaSize},
+		}
+
+		results, err := ctx.GenerateManyRSAKeyPairs(specs, 2)
+		require.NoError(t, err)
+
+		generated := 0
+		failed := 0
+		for result := range results {
+			if result.Err != nil {
+				require.Nil(t, result.ID)
+				failed++
+				continue
+			}
+
+			defer func(k Signer) { _ = k.Delete() }(result.Key)
+			generated++
+
+			found, err := ctx.FindKeyPair(result.ID, nil)
+			require.NoError(t, err)
+			require.NotNil(t, found)
+		}
+
+		require.Equal(t, 3, generated)
+		require.Equal(t, 1, failed)
+
+		_, err = ctx.GenerateManyRSAKeyPairs(specs, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestGenerationRecoversFromReadOnlySession(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+	cfg.MaxSessions = 2
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	// Replace the only pooled session with a read-only one
+	session, err := ctx.getSession()
+	require.NoError(t, err)
+	session.Close()
+	session.handle, err = ctx.ctx.OpenSession(ctx.slot, pkcs11.CKF_SERIAL_SESSION)
+	require.NoError(t, err)
+	ctx.pool.Put(session)
+
+	key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+	require.NoError(t, err)
+	defer func() { _ = key.Delete() }()
+
+	// The read-only session must have been replaced by a read-write one
+	err = ctx.withSession(func(session *pkcs11Session) error {
+		info, err := session.ctx.GetSessionInfo(session.handle)
+		if err != nil {
+			return err
+		}
+		require.NotZero(t, info.Flags&pkcs11.CKF_RW_SESSION)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPSSSaltLengths(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_RSA_PKCS_PSS)
+
+		for _, bits := range []int{2048, 3072, 4096} {
+			key, err := ctx.GenerateRSAKeyPair(randomBytes(), bits)
+			require.NoError(t, err)
+			defer func(k Signer) { _ = k.Delete() }(key)
+
+			pub := key.Public().(*rsa.PublicKey)
+
+			for _, hashFunction := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+				h := hashFunction.New()
+				_, err = h.Write([]byte("sign me with PSS"))
+				require.NoError(t, err)
+				digest := h.Sum(nil)
+
+				opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: hashFunction}
+				sig, err := key.Sign(rand.Reader, digest, opts)
+				require.NoError(t, err, "%d-bit key with %v", bits, hashFunction)
+
+				// The salt must be the largest possible, as crypto/rsa would use
+				maxSalt := pssMaxSaltLength(pub, uint(hashFunction.Size()))
+				err = rsa.VerifyPSS(pub, hashFunction, digest, sig, &rsa.PSSOptions{SaltLength: maxSalt})
+				require.NoError(t, err, "%d-bit key with %v", bits, hashFunction)
+
+				opts.SaltLength = maxSalt + 1
+				_, err = key.Sign(rand.Reader, digest, opts)
+				require.Error(t, err)
+			}
+		}
+	})
+}
+
+func TestPSSMaxSaltLength(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// 2048-bit modulus gives a 256-byte encoded message
+	require.Equal(t, 256-32-2, pssMaxSaltLength(&key.PublicKey, 32))
+	require.Equal(t, 256-64-2, pssMaxSaltLength(&key.PublicKey, 64))
+}
+
+func TestSignContext(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		signer, ok := key.(ContextSigner)
+		require.True(t, ok)
+
+		digest := crypto.SHA256.New().Sum(nil)
+		_, err = signer.SignContext(context.Background(), rand.Reader, digest, crypto.SHA256)
+		require.NoError(t, err)
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = signer.SignContext(cancelled, rand.Reader, digest, crypto.SHA256)
+		require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+		decrypter, ok := key.(ContextDecrypter)
+		require.True(t, ok)
+		_, err = decrypter.DecryptContext(cancelled, rand.Reader, make([]byte, rsaSize/8), nil)
+		require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	})
+}
+
+func TestRawRSASigning(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_RSA_X_509)
+
+		// Pad a SHA-256 digest for PKCS#1 v1.5 ourselves, so the result can be checked with crypto/rsa
+		digest := crypto.SHA256.New().Sum(nil)
+		encoded := append(append([]byte{}, pkcs1Prefix[crypto.SHA256]...), digest...)
+		padded := make([]byte, rsaSize/8)
+		padded[1] = 1
+		for i := 2; i < len(padded)-len(encoded)-1; i++ {
+			padded[i] = 0xff
+		}
+		copy(padded[len(padded)-len(encoded):], encoded)
+
+		sig, err := key.Sign(rand.Reader, padded, RawRSASignerOpts{})
+		require.NoError(t, err)
+		require.NoError(t, rsa.VerifyPKCS1v15(key.Public().(*rsa.PublicKey), crypto.SHA256, digest, sig))
+
+		_, err = key.Sign(rand.Reader, digest, &RawRSASignerOpts{})
+		require.Error(t, err)
+	})
+}
+
+func TestRSAPublicExponent(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateRSAKeyPairWithOptions(randomBytes(), nil, rsaSize, KeyGenOptions{PublicExponent: 3})
+		if errors.Is(err, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)) ||
+			errors.Is(err, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)) {
+			require.Contains(t, err.Error(), "CKA_PUBLIC_EXPONENT")
+			t.Skip("token does not accept a public exponent of 3")
+		}
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		require.Equal(t, 3, key.Public().(*rsa.PublicKey).E)
+
+		_, err = ctx.GenerateRSAKeyPairWithOptions(randomBytes(), nil, rsaSize, KeyGenOptions{PublicExponent: 4})
+		require.Error(t, err)
+	})
+}
+
+func TestPublicExponentError(t *testing.T) {
+	public := NewAttributeSet()
+	require.NoError(t, public.Set(CkaPublicExponent, []byte{3}))
+
+	var err error = pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	explained := publicExponentError(err, public)
+	require.Contains(t, explained.Error(), "CKA_PUBLIC_EXPONENT 3")
+	require.True(t, errors.Is(explained, err))
+
+	err = pkcs11.Error(pkcs11.CKR_DEVICE_MEMORY)
+	require.Equal(t, err, publicExponentError(err, public))
+
+	require.NoError(t, public.Set(CkaPublicExponent, []byte{1, 0, 1}))
+	err = pkcs11.Error(pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)
+	require.Equal(t, err, publicExponentError(err, public))
+}
+
+func TestEncryptRSA(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		plaintext := []byte("encrypted on the token")
+		for _, opts := range []crypto.DecrypterOpts{
+			nil,
+			&rsa.PKCS1v15DecryptOptions{},
+			&rsa.OAEPOptions{Hash: crypto.SHA256},
+		} {
+			ciphertext, err := ctx.EncryptRSA(key, opts, plaintext)
+			require.NoError(t, err)
+
+			decrypted, err := key.Decrypt(rand.Reader, ciphertext, opts)
+			require.NoError(t, err)
+			require.Equal(t, plaintext, decrypted)
+		}
+
+		// A bare public key is matched against the public key object on the token
+		ciphertext, err := ctx.EncryptRSA(key.Public(), nil, plaintext)
+		require.NoError(t, err)
+		decrypted, err := key.Decrypt(rand.Reader, ciphertext, nil)
+		require.NoError(t, err)
+		require.Equal(t, plaintext, decrypted)
+
+		_, err = ctx.EncryptRSA(key, &rsa.OAEPOptions{Hash: crypto.SHA256}, make([]byte, rsaSize/8-2*32-1))
+		require.Error(t, err)
+	})
+}
+
+func TestRSAEncryptMechanism(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	mech, maxLen, err := rsaEncryptMechanism(&priv.PublicKey, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint(pkcs11.CKM_RSA_PKCS), mech.Mechanism)
+	require.Equal(t, 128-11, maxLen)
+
+	mech, maxLen, err = rsaEncryptMechanism(&priv.PublicKey, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	require.NoError(t, err)
+	require.Equal(t, uint(pkcs11.CKM_RSA_PKCS_OAEP), mech.Mechanism)
+	require.Equal(t, 128-2*32-2, maxLen)
+
+	_, _, err = rsaEncryptMechanism(&priv.PublicKey, &rsa.PSSOptions{})
+	require.Error(t, err)
+}
+
+func TestCombinedRSASigning(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	var traced []uint
+	cfg.MechanismTracer = func(operation string, mechanism uint) {
+		traced = append(traced, mechanism)
+	}
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	key, err := ctx.GenerateRSAKeyPair(randomBytes(), rsaSize)
+	require.NoError(t, err)
+	defer func() { _ = key.Delete() }()
+
+	message := []byte("hashed by the token")
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA512} {
+		traced = nil
+		sig, err := key.Sign(rand.Reader, message, CombinedRSASignerOpts{Hash: hash})
+		require.NoError(t, err)
+
+		h := hash.New()
+		_, err = h.Write(message)
+		require.NoError(t, err)
+		require.NoError(t, rsa.VerifyPKCS1v15(key.Public().(*rsa.PublicKey), hash, h.Sum(nil), sig))
+
+		// The combined mechanism is used if the token has it, else CKM_RSA_PKCS.
+		supported, err := ctx.mechanismSupported(combinedRSAMechanisms[hash])
+		require.NoError(t, err)
+		if supported {
+			require.Equal(t, []uint{combinedRSAMechanisms[hash]}, traced)
+		} else {
+			require.Equal(t, []uint{pkcs11.CKM_RSA_PKCS}, traced)
+		}
+	}
+
+	_, err = key.Sign(rand.Reader, message, &CombinedRSASignerOpts{Hash: crypto.MD5})
+	require.Error(t, err)
+}
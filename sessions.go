@@ -23,9 +23,12 @@ package crypto11
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
 	"github.com/thales-e-security/pool"
 )
 
@@ -33,43 +36,340 @@ import (
 type pkcs11Session struct {
 	ctx    *pkcs11.Ctx
 	handle pkcs11.SessionHandle
+
+	// contextLogin is set while an operation is retried with a context-specific login. See withContextLogin.
+	contextLogin bool
+
+	// generation points to the Context's count of library reinitializations, and opened holds its value when the
+	// session was opened. A session opened before the latest reinitialization is stale. See Context.Reinitialize.
+	generation *pool.AtomicInt64
+	opened     int64
 }
 
 // Close is required to satisfy the pools.Resource interface. It closes the session, but swallows any
 // errors that occur.
 func (s pkcs11Session) Close() {
+	// A stale handle was invalidated by finalizing the library, and may since have been reused for a new session.
+	if s.stale() {
+		return
+	}
+
 	// We cannot return an error, so we swallow it
 	_ = s.ctx.CloseSession(s.handle)
 }
 
-// withSession executes a function with a session.
+// stale returns true if the session was opened before the library was last reinitialized.
+func (s pkcs11Session) stale() bool {
+	return s.generation != nil && s.generation.Get() != s.opened
+}
+
+// ErrOperationTimeout is returned if an operation on the token takes longer than Config.OperationTimeout.
+var ErrOperationTimeout = errors.New("PKCS#11 operation timed out")
+
+// withSession executes a function with a session. If the session turns out to be invalid, the function is retried
+// with a new session up to Config.MaxSessionRetries times.
 func (c *Context) withSession(f func(session *pkcs11Session) error) error {
-	session, err := c.getSession()
+	return c.withSessionContext(context.Background(), f)
+}
+
+// withSessionContext is like withSession, but gives up when ctx is done, whether waiting for a session or waiting for
+// the function to complete.
+func (c *Context) withSessionContext(ctx context.Context, f func(session *pkcs11Session) error) error {
+	for attempt := 0; ; attempt++ {
+		err := c.withTransientRetries(ctx, f)
+		if attempt >= c.cfg.MaxSessionRetries || !isSessionLost(err) || c.closed.Get() {
+			return mapPKCS11Error(err)
+		}
+
+		c.logf("crypto11: session lost (%v), retrying (attempt %d of %d)", err, attempt+1, c.cfg.MaxSessionRetries)
+		if err = c.relogin(); err != nil {
+			return mapPKCS11Error(errors.WithMessage(err, "failed to log in again after losing session"))
+		}
+	}
+}
+
+// RetryPolicy controls the retrying of operations that fail with a transient error. See Config.RetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times an operation is attempted, including the first. Zero or one means
+	// operations are not retried.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. The delay doubles for each further retry.
+	Backoff time.Duration
+
+	// MaxBackoff limits the delay between retries. Zero means there is no limit.
+	MaxBackoff time.Duration
+
+	// Errors lists the PKCS#11 return values (CKR_...) that are treated as transient. If empty,
+	// DefaultTransientErrors is used. CKR_FUNCTION_FAILED may be added for tokens that document it as transient.
+	Errors []uint
+}
+
+// DefaultTransientErrors are the PKCS#11 return values retried by a RetryPolicy that does not list its own.
+var DefaultTransientErrors = []uint{pkcs11.CKR_DEVICE_ERROR, pkcs11.CKR_DEVICE_MEMORY}
+
+// validate returns an error if the policy is invalid.
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 {
+		return errors.New("RetryPolicy.MaxAttempts must not be negative")
+	}
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return errors.New("RetryPolicy backoff must not be negative")
+	}
+	return nil
+}
+
+// transient returns true if err is one of the errors the policy retries.
+func (p RetryPolicy) transient(err error) bool {
+	codes := p.Errors
+	if len(codes) == 0 {
+		codes = DefaultTransientErrors
+	}
+	for _, code := range codes {
+		if isPKCS11Error(err, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry, counting from one.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < retry && delay > 0; i++ {
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// withTransientRetries executes a function with a session from the pool, retrying it as set by Config.RetryPolicy if
+// it fails with a transient error.
+func (c *Context) withTransientRetries(ctx context.Context, f func(session *pkcs11Session) error) error {
+	policy := c.cfg.RetryPolicy
+	for attempt := 1; ; attempt++ {
+		err := c.withPooledSession(ctx, f)
+		if attempt >= policy.MaxAttempts || !policy.transient(err) || c.closed.Get() {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		c.logf("crypto11: transient error (%v), retrying in %v (attempt %d of %d)", err, delay, attempt+1,
+			policy.MaxAttempts)
+		if delay <= 0 {
+			continue
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return contextError(ctx.Err(), "gave up retrying")
+		}
+	}
+}
+
+// withPooledSession executes a function with a session from the pool.
+func (c *Context) withPooledSession(ctx context.Context, f func(session *pkcs11Session) error) error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	if c.cfg.SingleThreaded {
+		return c.withSingleSession(ctx, f)
+	}
+
+	session, err := c.getSessionContext(ctx)
 	if err != nil {
 		return err
 	}
-	defer c.pool.Put(session)
 
-	return f(session)
+	// Close may have begun while we waited for the session. It cannot finish until the session is returned.
+	if c.closed.Get() {
+		c.pool.Put(session)
+		return errClosed
+	}
+
+	if c.cfg.OperationTimeout <= 0 && ctx.Done() == nil {
+		err = f(session)
+		c.putSession(session, err)
+		return err
+	}
+
+	return c.withSessionTimeout(ctx, session, c.cfg.OperationTimeout, f)
+}
+
+// withSingleSession executes a function with the Context's only session, when Config.SingleThreaded is set. The
+// session is taken from the pool on first use, and replaced if it is lost. There is no locking and no timeout.
+func (c *Context) withSingleSession(ctx context.Context, f func(session *pkcs11Session) error) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err, "operation abandoned")
+	}
+
+	session := c.singleSession
+	if session != nil && session.stale() {
+		c.logf("crypto11: discarding session %d opened before reinitialization", session.handle)
+		c.singleSession = nil
+		c.pool.Put(nil)
+		session = nil
+	}
+	if session == nil {
+		var err error
+		if session, err = c.getSessionContext(ctx); err != nil {
+			return err
+		}
+		c.singleSession = session
+	}
+
+	err := f(session)
+	if isSessionLost(err) || (!c.readOnlySessions && isPKCS11Error(err, pkcs11.CKR_SESSION_READ_ONLY)) {
+		c.singleSession = nil
+		c.putSession(session, err)
+	}
+	return err
+}
+
+// withSessionTimeout executes a function with a session, giving up if it does not complete within timeout (if
+// positive) or before ctx is done.
+//
+// A PKCS#11 call cannot be cancelled, so on timeout the function is left running in its own goroutine. The session
+// is treated as broken: it is closed once the function eventually returns and is replaced in the pool by a new one.
+func (c *Context) withSessionTimeout(ctx context.Context, session *pkcs11Session, timeout time.Duration,
+	f func(session *pkcs11Session) error) error {
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f(session)
+	}()
+
+	var timeoutC <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+
+	select {
+	case err := <-done:
+		c.putSession(session, err)
+		return err
+	case <-timeoutC:
+		c.abandonSession(session, done)
+		return ErrOperationTimeout
+	case <-ctx.Done():
+		c.abandonSession(session, done)
+		return contextError(ctx.Err(), "operation abandoned")
+	}
+}
+
+// abandonSession replaces session in the pool with a new one, closing it once the operation using it (which reports
+// on done) returns.
+func (c *Context) abandonSession(session *pkcs11Session, done <-chan error) {
+	c.logf("crypto11: abandoning session %d", session.handle)
+	go func() {
+		<-done
+		c.logf("crypto11: closing abandoned session %d", session.handle)
+		session.Close()
+	}()
+	c.pool.Put(nil)
+}
+
+// contextError wraps err, the error from a context.Context, so that errors.Is(err, context.Canceled) and
+// errors.Is(err, context.DeadlineExceeded) still work.
+func contextError(err error, message string) error {
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+// putSession returns a session to the pool after use, unless err shows the session to be unsuitable for the pool. Such
+// sessions are closed and replaced in the pool by new ones.
+func (c *Context) putSession(session *pkcs11Session, err error) {
+	if isSessionLost(err) || session.stale() {
+		c.logf("crypto11: discarding lost session %d: %v", session.handle, err)
+		session.Close()
+		c.pool.Put(nil)
+		return
+	}
+
+	// A read-only session in a read-write pool cannot have been opened with the pool's flags.
+	if !c.readOnlySessions && isPKCS11Error(err, pkcs11.CKR_SESSION_READ_ONLY) {
+		c.logf("crypto11: discarding read-only session %d", session.handle)
+		session.Close()
+		c.pool.Put(nil)
+		return
+	}
+
+	c.pool.Put(session)
+}
+
+// isSessionLost returns true if err shows that the session used is no longer valid, e.g. because the connection to
+// the token was lost.
+func isSessionLost(err error) bool {
+	return isPKCS11Error(err, pkcs11.CKR_SESSION_HANDLE_INVALID) || isPKCS11Error(err, pkcs11.CKR_SESSION_CLOSED)
+}
+
+// relogin restores the state of the Context after sessions were lost, opening a new long-term session if the
+// existing one is no longer valid and logging in again if login is enabled.
+func (c *Context) relogin() error {
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+
+	_, err := c.ctx.GetSessionInfo(c.persistentSession)
+	if isSessionLost(err) {
+		c.logf("crypto11: reopening long term session")
+		session, err := c.ctx.OpenSession(c.SlotID(), pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return errors.WithMessage(err, "failed to create long term session")
+		}
+		c.persistentSession = session
+	} else if err != nil {
+		return errors.WithMessage(err, "failed to get session info")
+	}
+
+	if !c.loginEnabled() {
+		return nil
+	}
+	return c.login(c.persistentSession)
 }
 
 // getSession retrieves a session from the pool, respecting the timeout defined in the Context config.
 // Callers are responsible for putting this session back in the pool.
 func (c *Context) getSession() (*pkcs11Session, error) {
-	ctx := context.Background()
+	return c.getSessionContext(context.Background())
+}
+
+// getSessionContext is like getSession, but also gives up waiting for a session when ctx is done.
+func (c *Context) getSessionContext(ctx context.Context) (*pkcs11Session, error) {
+	waitCtx := ctx
 
 	if c.cfg.PoolWaitTimeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(context.Background(), c.cfg.PoolWaitTimeout)
+		waitCtx, cancel = context.WithTimeout(ctx, c.cfg.PoolWaitTimeout)
 		defer cancel()
 	}
 
-	resource, err := c.pool.Get(ctx)
+	resource, err := c.pool.Get(waitCtx)
+	for err == nil && resource.(*pkcs11Session).stale() {
+		c.logf("crypto11: discarding session %d opened before reinitialization", resource.(*pkcs11Session).handle)
+		c.pool.Put(nil)
+		resource, err = c.pool.Get(waitCtx)
+	}
+	if err == pool.ErrTimeout && ctx.Err() != nil {
+		// The caller's context, rather than PoolWaitTimeout, ended the wait.
+		return nil, contextError(ctx.Err(), "gave up waiting for a session")
+	}
+	if err == pool.ErrTimeout {
+		c.logf("crypto11: timed out waiting for a session")
+		c.waitTimeouts.Add(1)
+	}
 	if err == pool.ErrClosed {
-		// Our Context must have been closed, return a nicer error.
-		// We don't use errClosed to ensure our tests identify functions that aren't checking for closure
-		// correctly.
-		return nil, errors.New("context is closed")
+		// Our Context was closed while we waited.
+		return nil, errClosed
 	}
 	if err != nil {
 		return nil, err
@@ -78,11 +378,341 @@ func (c *Context) getSession() (*pkcs11Session, error) {
 	return resource.(*pkcs11Session), nil
 }
 
+// PoolStats describes the state of a Context's session pool.
+type PoolStats struct {
+	// InUse is the number of sessions currently being used by operations.
+	InUse int64
+
+	// Idle is the number of open sessions waiting in the pool.
+	Idle int64
+
+	// MaxSessions is the maximum number of sessions the pool may hold. The Context's long-term session is not
+	// included.
+	MaxSessions int64
+
+	// WaitTimeouts is the number of times an operation failed because no session became available within
+	// Config.PoolWaitTimeout.
+	WaitTimeouts int64
+}
+
+// PoolStats returns statistics about the Context's session pool, for monitoring whether it is saturated.
+func (c *Context) PoolStats() PoolStats {
+	inUse := c.pool.InUse()
+	return PoolStats{
+		InUse:        inUse,
+		Idle:         c.pool.Active() - inUse,
+		MaxSessions:  c.pool.Capacity(),
+		WaitTimeouts: c.waitTimeouts.Get(),
+	}
+}
+
+// WithRawSession runs fn with a session from the pool, for calling PKCS#11 functions that this package does not wrap,
+// such as vendor-specific mechanisms. The session is returned to the pool when fn returns, so neither ctx nor session
+// may be retained or used after that. fn must leave the session as it found it, without active operations, and
+// must not log in or out or close the session.
+//
+// If Config.ReadOnlySessions is set, the session is read-only. If fn returns an error showing the session was lost,
+// it is called again with a new session, up to Config.MaxSessionRetries times.
+func (c *Context) WithRawSession(fn func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) error) error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	return c.withSession(func(session *pkcs11Session) error {
+		return fn(session.ctx, session.handle)
+	})
+}
+
+// SetMaxSessions changes the maximum number of concurrent sessions, as set by Config.MaxSessions, while the Context
+// is in use. As with Config.MaxSessions, one session is reserved for the Context's long-term session.
+//
+// The pool can grow up to the larger of Config.MaxSessions and DefaultMaxSessions, and never beyond the number of
+// sessions the token supports. When the pool shrinks, SetMaxSessions blocks until enough sessions have been returned
+// to the pool, including those pinned by Signer.WithSession.
+func (c *Context) SetMaxSessions(n int) error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	if n <= 1 {
+		return errors.New("MaxSessions must be larger than 1")
+	}
+	if err := checkSessionLimits(c.cfg.MinSessions, n); err != nil {
+		return err
+	}
+	if limit := int(c.pool.MaxCap()) + 1; n > limit {
+		return errors.Errorf("MaxSessions (%d) exceeds the limit of %d sessions", n, limit)
+	}
+
+	err := c.pool.SetCapacity(n - 1)
+	if err == pool.ErrClosed {
+		return errClosed
+	}
+	return err
+}
+
+// withRWSession executes a function with a read-write session, for operations that modify the token. If the pool
+// holds read-only sessions, a one-off read-write session is opened for the duration of the call. One-off sessions
+// are not counted against MaxSessions.
+//
+// Should a pooled session nevertheless turn out to be read-only (CKR_SESSION_READ_ONLY), it is discarded from the
+// pool and the function is retried on a one-off read-write session.
+func (c *Context) withRWSession(f func(session *pkcs11Session) error) error {
+	if !c.readOnlySessions {
+		err := c.withSession(f)
+		if !isPKCS11Error(err, pkcs11.CKR_SESSION_READ_ONLY) {
+			return err
+		}
+	}
+
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	session, err := c.openSession(pkcs11.CKF_SERIAL_SESSION | pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		if c.cfg.ReadOnlySessions {
+			return mapPKCS11Error(errors.WithMessage(err, "operation modifies the token and needs a read-write "+
+				"session, which the token refused; disable Config.ReadOnlySessions or free read-write sessions"))
+		}
+		return mapPKCS11Error(errors.WithMessage(err, "failed to open read-write session"))
+	}
+	defer session.Close()
+
+	return mapPKCS11Error(f(session))
+}
+
+// withObjectSession executes a function with a read-write session suitable for creating the object described by
+// template. Session objects (CKA_TOKEN false) are destroyed when the session that created them is closed, which for a
+// pooled session could happen at any time. They are therefore created on the long-term session, which lasts as long
+// as the Context.
+func (c *Context) withObjectSession(template AttributeSet, f func(session *pkcs11Session) error) error {
+	if !isSessionObject(template) {
+		return c.withRWSession(f)
+	}
+
+	c.reloginMutex.Lock()
+	defer c.reloginMutex.Unlock()
+	if c.closed.Get() {
+		return errClosed
+	}
+	return mapPKCS11Error(f(&pkcs11Session{ctx: &c.ctx.Ctx, handle: c.persistentSession}))
+}
+
+// sessionPin holds a session dedicated to a private key for the duration of WithSession. Values of
+// pkcs11PrivateKey for the same key share the sessionPin.
+type sessionPin struct {
+	// holder is held for the duration of WithSession, so only one session at a time is pinned to a key.
+	holder sync.Mutex
+
+	// users counts the calls to WithSession using the sessionPin, including those waiting for holder. The sessionPin
+	// is removed from Context.pins when it reaches zero. Protected by Context.pinsMutex.
+	users int
+
+	// mutex protects the fields below, and is held while an operation uses the pinned session.
+	mutex   sync.Mutex
+	session *pkcs11Session
+
+	// err is the error showing the pinned session to have been lost, if any.
+	err error
+}
+
+// WithSession runs fn with a session from the pool pinned to the key. Operations on the key made while fn runs use
+// the pinned session instead of borrowing one from the pool each time, which reduces overhead in tight loops. The
+// session is returned to the pool when fn returns. Obtaining the session is subject to the pool's limits in the same
+// way as any other operation.
+//
+// Operations on the key from other goroutines while fn runs also use the pinned session, one at a time. Operations on
+// the pinned session do not observe Config.OperationTimeout, or the context given to SignContext or DecryptContext. If
+// the pinned session is lost, later operations use the pool as usual. Calls to WithSession on the same key must not
+// be nested.
+func (k *pkcs11PrivateKey) WithSession(fn func() error) error {
+	if k.context.closed.Get() {
+		return errClosed
+	}
+
+	unlock := k.lockHandles()
+	handle := k.handle
+	unlock()
+
+	pin := k.context.acquirePin(handle)
+	defer k.context.releasePin(handle, pin)
+
+	pin.holder.Lock()
+	defer pin.holder.Unlock()
+
+	session, err := k.context.getSession()
+	if err != nil {
+		return err
+	}
+
+	pin.mutex.Lock()
+	pin.session = session
+	pin.err = nil
+	pin.mutex.Unlock()
+
+	defer func() {
+		pin.mutex.Lock()
+		k.context.putSession(session, pin.err)
+		pin.session = nil
+		pin.mutex.Unlock()
+	}()
+
+	return fn()
+}
+
+// acquirePin returns the sessionPin for the key with the given handle, creating it if WithSession is not already in
+// progress on the key. It must be released with releasePin.
+func (c *Context) acquirePin(handle pkcs11.ObjectHandle) *sessionPin {
+	c.pinsMutex.Lock()
+	defer c.pinsMutex.Unlock()
+
+	pin, ok := c.pins[handle]
+	if !ok {
+		if c.pins == nil {
+			c.pins = map[pkcs11.ObjectHandle]*sessionPin{}
+		}
+		pin = &sessionPin{}
+		c.pins[handle] = pin
+	}
+	pin.users++
+	return pin
+}
+
+// releasePin releases a sessionPin obtained from acquirePin, removing it once no call to WithSession is using it.
+func (c *Context) releasePin(handle pkcs11.ObjectHandle, pin *sessionPin) {
+	c.pinsMutex.Lock()
+	defer c.pinsMutex.Unlock()
+
+	pin.users--
+	if pin.users == 0 {
+		delete(c.pins, handle)
+	}
+}
+
+// findPin returns the sessionPin for the key with the given handle, if WithSession is in progress on the key.
+func (c *Context) findPin(handle pkcs11.ObjectHandle) (*sessionPin, bool) {
+	c.pinsMutex.Lock()
+	defer c.pinsMutex.Unlock()
+
+	pin, ok := c.pins[handle]
+	return pin, ok
+}
+
+// sessionPinned returns true if WithSession is in progress on the key, in any goroutine.
+func (k *pkcs11PrivateKey) sessionPinned() bool {
+	unlock := k.lockHandles()
+	defer unlock()
+	_, ok := k.context.findPin(k.handle)
+	return ok
+}
+
+// withSessionContext is like Context.withSessionContext, but uses the session pinned to the key by WithSession, if
+// any. If f fails because the key's handle is invalid, for example because the library was reinitialized, the key is
+// found again by its CKA_ID and CKA_LABEL and f is retried once with the new handles.
+func (k *pkcs11PrivateKey) withSessionContext(ctx context.Context, f func(session *pkcs11Session) error) error {
+	err := k.withSessionContextOnce(ctx, f)
+	if k.identity == nil || !isHandleInvalid(err) {
+		return err
+	}
+
+	if refreshErr := k.refreshHandles(); refreshErr != nil {
+		k.context.logf("crypto11: cannot find key again after its handle became invalid: %v", refreshErr)
+		return err
+	}
+	return k.withSessionContextOnce(ctx, f)
+}
+
+// isHandleInvalid returns true if err shows an object handle to be invalid. Operations that start with a key, such as
+// C_SignInit, report CKR_KEY_HANDLE_INVALID rather than CKR_OBJECT_HANDLE_INVALID.
+func isHandleInvalid(err error) bool {
+	return isPKCS11Error(err, pkcs11.CKR_OBJECT_HANDLE_INVALID) || isPKCS11Error(err, pkcs11.CKR_KEY_HANDLE_INVALID)
+}
+
+// withSessionContextOnce performs f for withSessionContext, while preventing the key's handles being replaced.
+func (k *pkcs11PrivateKey) withSessionContextOnce(ctx context.Context, f func(session *pkcs11Session) error) error {
+	if k.identity != nil {
+		k.identity.mutex.RLock()
+		defer k.identity.mutex.RUnlock()
+	}
+
+	pin, ok := k.context.findPin(k.handle)
+	if !ok {
+		return k.context.withSessionContext(ctx, f)
+	}
+
+	pin.mutex.Lock()
+	if pin.session == nil || pin.err != nil {
+		pin.mutex.Unlock()
+		return k.context.withSessionContext(ctx, f)
+	}
+	defer pin.mutex.Unlock()
+
+	if k.context.closed.Get() {
+		return errClosed
+	}
+
+	err := f(pin.session)
+	if isSessionLost(err) {
+		pin.err = err
+	}
+	return mapPKCS11Error(err)
+}
+
+// isSessionObject returns true if template has CKA_TOKEN set to false.
+func isSessionObject(template AttributeSet) bool {
+	attribute, ok := template[CkaToken]
+	return ok && len(attribute.Value) == 1 && attribute.Value[0] == 0
+}
+
+// sessionFlags returns the flags used to open pooled sessions.
+func (c *Context) sessionFlags() uint {
+	if c.readOnlySessions {
+		return pkcs11.CKF_SERIAL_SESSION
+	}
+	return pkcs11.CKF_SERIAL_SESSION | pkcs11.CKF_RW_SESSION
+}
+
+// prefillSessions opens n sessions in the pool.
+func (c *Context) prefillSessions(n int) error {
+	sessions := make([]pool.Resource, 0, n)
+	defer func() {
+		for _, session := range sessions {
+			c.pool.Put(session)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		session, err := c.pool.Get(context.Background())
+		if err != nil {
+			return errors.WithMessage(err, "failed to open minimum sessions")
+		}
+		sessions = append(sessions, session)
+	}
+	return nil
+}
+
 // resourcePoolFactoryFunc is called by the resource pool when a new session is needed.
 func (c *Context) resourcePoolFactoryFunc() (pool.Resource, error) {
-	session, err := c.ctx.OpenSession(c.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	c.logf("crypto11: expanding session pool")
+	return c.openSession(c.sessionFlags())
+}
+
+// openSession opens a session for use by operations, running the Config.OnSessionOpen hook if set.
+func (c *Context) openSession(flags uint) (*pkcs11Session, error) {
+	handle, err := c.ctx.OpenSession(c.SlotID(), flags)
 	if err != nil {
+		c.logf("crypto11: failed to open session: %v", err)
 		return nil, err
 	}
-	return &pkcs11Session{&c.ctx.Ctx, session}, nil
+	c.logf("crypto11: opened session %d with flags %#x", handle, flags)
+	session := &pkcs11Session{ctx: &c.ctx.Ctx, handle: handle, generation: &c.generation, opened: c.generation.Get()}
+
+	if c.cfg.OnSessionOpen != nil {
+		if err = c.cfg.OnSessionOpen(session.ctx, handle); err != nil {
+			session.Close()
+			return nil, errors.WithMessage(err, "OnSessionOpen failed")
+		}
+	}
+	return session, nil
 }
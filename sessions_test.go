@@ -0,0 +1,545 @@
+// Copyright 2016, 2017 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"context"
+	"crypto"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thales-e-security/pool"
+)
+
+// newTestContext returns a Context backed by a pool of dummy sessions, for testing session handling
+// without a token.
+func newTestContext(cfg *Config, maxSessions int) *Context {
+	return &Context{
+		cfg: cfg,
+		pool: pool.NewResourcePool(func() (pool.Resource, error) {
+			return &pkcs11Session{ctx: &pkcs11.Ctx{}}, nil
+		}, maxSessions, maxSessions, 0, 0),
+	}
+}
+
+func TestOperationTimeout(t *testing.T) {
+	ctx := newTestContext(&Config{OperationTimeout: 50 * time.Millisecond}, 1)
+	defer ctx.pool.Close()
+
+	release := make(chan struct{})
+	err := ctx.withSession(func(session *pkcs11Session) error {
+		<-release
+		return nil
+	})
+	require.Equal(t, ErrOperationTimeout, err)
+	close(release)
+
+	// The abandoned session must not prevent further use of the pool
+	err = ctx.withSession(func(session *pkcs11Session) error {
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), ctx.pool.InUse())
+}
+
+func TestPrefillSessions(t *testing.T) {
+	ctx := newTestContext(&Config{}, 4)
+	defer ctx.pool.Close()
+
+	require.NoError(t, ctx.prefillSessions(3))
+	require.Equal(t, int64(3), ctx.pool.Active())
+	require.Equal(t, int64(0), ctx.pool.InUse())
+}
+
+func TestPoolStats(t *testing.T) {
+	ctx := newTestContext(&Config{PoolWaitTimeout: 10 * time.Millisecond}, 2)
+	defer ctx.pool.Close()
+
+	require.NoError(t, ctx.prefillSessions(2))
+	assert.Equal(t, PoolStats{Idle: 2, MaxSessions: 2}, ctx.PoolStats())
+
+	err := ctx.withSession(func(session *pkcs11Session) error {
+		return ctx.withSession(func(session *pkcs11Session) error {
+			assert.Equal(t, PoolStats{InUse: 2, MaxSessions: 2}, ctx.PoolStats())
+
+			// The pool is exhausted, so this must time out
+			return ctx.withSession(func(session *pkcs11Session) error {
+				return nil
+			})
+		})
+	})
+	require.Equal(t, pool.ErrTimeout, err)
+	assert.Equal(t, PoolStats{Idle: 2, MaxSessions: 2, WaitTimeouts: 1}, ctx.PoolStats())
+}
+
+func TestSetMaxSessions(t *testing.T) {
+	ctx := &Context{cfg: &Config{MinSessions: 3}}
+	ctx.pool = pool.NewResourcePool(func() (pool.Resource, error) {
+		return &pkcs11Session{ctx: &pkcs11.Ctx{}}, nil
+	}, 4, 7, 0, 0)
+	defer ctx.pool.Close()
+
+	require.Error(t, ctx.SetMaxSessions(1))
+	require.Error(t, ctx.SetMaxSessions(3))
+	require.Error(t, ctx.SetMaxSessions(9))
+
+	require.NoError(t, ctx.SetMaxSessions(8))
+	assert.Equal(t, int64(7), ctx.PoolStats().MaxSessions)
+
+	ctx.cfg.MinSessions = 0
+	require.NoError(t, ctx.SetMaxSessions(4))
+	assert.Equal(t, int64(3), ctx.PoolStats().MaxSessions)
+
+	// Shrinking waits for sessions in use to be returned
+	shrunk := make(chan error, 1)
+	err := ctx.withSession(func(session *pkcs11Session) error {
+		return ctx.withSession(func(session *pkcs11Session) error {
+			go func() { shrunk <- ctx.SetMaxSessions(2) }()
+			time.Sleep(50 * time.Millisecond)
+
+			select {
+			case <-shrunk:
+				t.Error("SetMaxSessions returned while sessions were in use")
+			default:
+			}
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	require.NoError(t, <-shrunk)
+	assert.Equal(t, int64(1), ctx.PoolStats().MaxSessions)
+}
+
+func TestSingleThreaded(t *testing.T) {
+	ctx := newTestContext(&Config{SingleThreaded: true, LoginNotSupported: true}, 2)
+	defer func() {
+		if ctx.singleSession != nil {
+			ctx.pool.Put(ctx.singleSession)
+		}
+		ctx.pool.Close()
+	}()
+
+	var sessions []*pkcs11Session
+	record := func(session *pkcs11Session) error {
+		sessions = append(sessions, session)
+		return nil
+	}
+
+	require.NoError(t, ctx.withSession(record))
+	require.NoError(t, ctx.withSession(record))
+	require.Len(t, sessions, 2)
+	assert.True(t, sessions[0] == sessions[1])
+
+	// The session is held by the Context between operations
+	assert.Equal(t, int64(1), ctx.PoolStats().InUse)
+
+	// A lost session is replaced
+	err := ctx.withSession(func(session *pkcs11Session) error {
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	})
+	require.True(t, isSessionLost(err))
+	assert.Nil(t, ctx.singleSession)
+
+	require.NoError(t, ctx.withSession(record))
+	require.Len(t, sessions, 3)
+	assert.True(t, sessions[0] != sessions[2])
+	assert.Equal(t, int64(1), ctx.PoolStats().InUse)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ctx.withSessionContext(cancelled, record)
+	require.True(t, errors.Is(err, context.Canceled))
+	require.Len(t, sessions, 3)
+
+	config := &Config{TokenLabel: "token", OperationTimeout: time.Second}
+	require.NoError(t, config.validateSettings())
+	config.SingleThreaded = true
+	require.Error(t, config.validateSettings())
+}
+
+func TestWithRawSession(t *testing.T) {
+	ctx := newTestContext(&Config{}, 2)
+	defer ctx.pool.Close()
+
+	err := ctx.WithRawSession(func(p11 *pkcs11.Ctx, session pkcs11.SessionHandle) error {
+		assert.NotNil(t, p11)
+		assert.Equal(t, int64(1), ctx.PoolStats().InUse)
+		return errors.New("from fn")
+	})
+	require.EqualError(t, err, "from fn")
+	assert.Equal(t, int64(0), ctx.PoolStats().InUse)
+}
+
+func TestWithRawSessionToken(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		err := ctx.WithRawSession(func(p11 *pkcs11.Ctx, session pkcs11.SessionHandle) error {
+			info, err := p11.GetSessionInfo(session)
+			require.NoError(t, err)
+			assert.Equal(t, ctx.slot, info.SlotID)
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	ctx := newTestContext(&Config{RetryPolicy: policy}, 2)
+	defer ctx.pool.Close()
+
+	failing := func(failures int, code uint) (func(session *pkcs11Session) error, *int) {
+		attempts := 0
+		return func(session *pkcs11Session) error {
+			attempts++
+			if attempts <= failures {
+				return pkcs11.Error(code)
+			}
+			return nil
+		}, &attempts
+	}
+
+	f, attempts := failing(2, pkcs11.CKR_DEVICE_ERROR)
+	require.NoError(t, ctx.withSession(f))
+	assert.Equal(t, 3, *attempts)
+
+	f, attempts = failing(3, pkcs11.CKR_DEVICE_MEMORY)
+	require.True(t, isPKCS11Error(ctx.withSession(f), pkcs11.CKR_DEVICE_MEMORY))
+	assert.Equal(t, 3, *attempts)
+
+	// Other errors are not retried
+	f, attempts = failing(1, pkcs11.CKR_FUNCTION_FAILED)
+	require.Error(t, ctx.withSession(f))
+	assert.Equal(t, 1, *attempts)
+
+	ctx.cfg.RetryPolicy.Errors = []uint{pkcs11.CKR_FUNCTION_FAILED}
+	f, attempts = failing(1, pkcs11.CKR_FUNCTION_FAILED)
+	require.NoError(t, ctx.withSession(f))
+	assert.Equal(t, 2, *attempts)
+
+	// Waiting between attempts gives up when the context expires
+	ctx.cfg.RetryPolicy = RetryPolicy{MaxAttempts: 2, Backoff: time.Minute}
+	deadline, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	f, attempts = failing(1, pkcs11.CKR_DEVICE_ERROR)
+	err := ctx.withSessionContext(deadline, f)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, 1, *attempts)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(4))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(100))
+
+	policy.MaxBackoff = 0
+	assert.Equal(t, 80*time.Millisecond, policy.backoff(4))
+
+	require.NoError(t, policy.validate())
+	require.Error(t, RetryPolicy{MaxAttempts: -1}.validate())
+	require.Error(t, RetryPolicy{Backoff: -time.Second}.validate())
+}
+
+func TestSessionContext(t *testing.T) {
+	ctx := newTestContext(&Config{PoolWaitTimeout: time.Minute}, 1)
+	defer ctx.pool.Close()
+
+	// Waiting for a session gives up when the context expires, without counting against PoolWaitTimeout
+	err := ctx.withSession(func(session *pkcs11Session) error {
+		deadline, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		return ctx.withSessionContext(deadline, func(session *pkcs11Session) error {
+			return nil
+		})
+	})
+	require.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.Equal(t, int64(0), ctx.PoolStats().WaitTimeouts)
+
+	// A slow operation is abandoned when the context is cancelled
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	release := make(chan struct{})
+	err = ctx.withSessionContext(cancelCtx, func(session *pkcs11Session) error {
+		<-release
+		return nil
+	})
+	require.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	close(release)
+
+	err = ctx.withSessionContext(context.Background(), func(session *pkcs11Session) error {
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), ctx.pool.InUse())
+}
+
+// recordingLogger is a Logger that records messages for inspection.
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	ctx := newTestContext(&Config{Logger: logger, RetryPolicy: RetryPolicy{MaxAttempts: 2}}, 2)
+	defer ctx.pool.Close()
+
+	attempts := 0
+	err := ctx.withSession(func(session *pkcs11Session) error {
+		attempts++
+		if attempts == 1 {
+			return pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)
+		}
+		return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	})
+	require.True(t, isSessionLost(err))
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	require.Len(t, logger.messages, 2)
+	assert.Contains(t, logger.messages[0], "retrying")
+	assert.Contains(t, logger.messages[1], "discarding lost session")
+}
+
+func TestNoLoggerIsSilent(t *testing.T) {
+	var output strings.Builder
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := &Context{cfg: &Config{}}
+	ctx.logf("crypto11: warning")
+	assert.Empty(t, output.String())
+}
+
+func TestSessionRetries(t *testing.T) {
+	for _, retries := range []int{0, 1, 2} {
+		t.Run(fmt.Sprintf("retries_%d", retries), func(t *testing.T) {
+			cfg, err := getConfig("config")
+			require.NoError(t, err)
+			cfg.MaxSessionRetries = retries
+
+			ctx, err := Configure(cfg)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, ctx.Close()) }()
+
+			var sessions []*pkcs11Session
+			err = ctx.withSession(func(session *pkcs11Session) error {
+				sessions = append(sessions, session)
+				if len(sessions) < 2 {
+					return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+				}
+				return nil
+			})
+
+			if retries == 0 {
+				require.True(t, isSessionLost(err))
+				require.Len(t, sessions, 1)
+			} else {
+				require.NoError(t, err)
+				require.Len(t, sessions, 2)
+
+				// The dead session must have been replaced by a new one
+				assert.True(t, sessions[0] != sessions[1])
+			}
+			assert.Equal(t, int64(0), ctx.pool.InUse())
+		})
+	}
+}
+
+func TestIsSessionLost(t *testing.T) {
+	assert.True(t, isSessionLost(pkcs11.Error(pkcs11.CKR_SESSION_CLOSED)))
+	assert.True(t, isSessionLost(&OperationError{Err: pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)}))
+	assert.False(t, isSessionLost(pkcs11.Error(pkcs11.CKR_DEVICE_ERROR)))
+	assert.False(t, isSessionLost(nil))
+}
+
+func TestSessionLimits(t *testing.T) {
+	require.NoError(t, checkSessionLimits(0, 2))
+	require.NoError(t, checkSessionLimits(3, 4))
+	require.Error(t, checkSessionLimits(4, 4))
+	require.Error(t, checkSessionLimits(-1, 4))
+}
+
+func TestOnSessionOpen(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	var opened []pkcs11.SessionHandle
+	cfg.OnSessionOpen = func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) error {
+		opened = append(opened, session)
+		return nil
+	}
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	err = ctx.withSession(func(session *pkcs11Session) error {
+		require.Equal(t, []pkcs11.SessionHandle{session.handle}, opened)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestOnSessionOpenFailure(t *testing.T) {
+	cfg, err := getConfig("config")
+	require.NoError(t, err)
+
+	cfg.OnSessionOpen = func(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) error {
+		return errors.New("vendor initialisation failed")
+	}
+
+	ctx, err := Configure(cfg)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ctx.Close()) }()
+
+	_, err = ctx.FindKey(randomBytes(), nil)
+	require.Error(t, err)
+}
+
+func TestStaleSessionsDiscarded(t *testing.T) {
+	ctx := &Context{cfg: &Config{}}
+	opened := 0
+	ctx.pool = pool.NewResourcePool(func() (pool.Resource, error) {
+		opened++
+		return &pkcs11Session{ctx: &pkcs11.Ctx{}, generation: &ctx.generation, opened: ctx.generation.Get()}, nil
+	}, 1, 1, 0, 0)
+	defer ctx.pool.Close()
+
+	var first, second *pkcs11Session
+	require.NoError(t, ctx.withSession(func(session *pkcs11Session) error {
+		first = session
+		return nil
+	}))
+	assert.False(t, first.stale())
+
+	// Simulate Reinitialize.
+	ctx.generation.Add(1)
+	assert.True(t, first.stale())
+
+	require.NoError(t, ctx.withSession(func(session *pkcs11Session) error {
+		second = session
+		return nil
+	}))
+	assert.False(t, second.stale())
+	assert.True(t, first != second)
+	assert.Equal(t, 2, opened)
+}
+
+func TestPinnedSession(t *testing.T) {
+	ctx := newTestContext(&Config{}, 2)
+	defer ctx.pool.Close()
+	key := &pkcs11PrivateKey{pkcs11Object: pkcs11Object{handle: 1, context: ctx}}
+
+	var sessions []*pkcs11Session
+	record := func(session *pkcs11Session) error {
+		sessions = append(sessions, session)
+		return nil
+	}
+
+	err := key.WithSession(func() error {
+		assert.Equal(t, int64(1), ctx.PoolStats().InUse)
+		require.NoError(t, key.withSessionContext(context.Background(), record))
+		require.NoError(t, key.withSessionContext(context.Background(), record))
+		assert.Equal(t, int64(1), ctx.PoolStats().InUse)
+		return errors.New("from fn")
+	})
+	require.EqualError(t, err, "from fn")
+	assert.Equal(t, int64(0), ctx.PoolStats().InUse)
+	require.Len(t, sessions, 2)
+	assert.True(t, sessions[0] == sessions[1])
+
+	// A lost pinned session is not used again.
+	err = key.WithSession(func() error {
+		err := key.withSessionContext(context.Background(), func(session *pkcs11Session) error {
+			return pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+		})
+		require.Error(t, err)
+		assert.Equal(t, int64(1), ctx.PoolStats().InUse)
+		return key.withSessionContext(context.Background(), func(session *pkcs11Session) error {
+			assert.Equal(t, int64(2), ctx.PoolStats().InUse)
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), ctx.PoolStats().InUse)
+
+	// The pin is forgotten once WithSession returns.
+	assert.Empty(t, ctx.pins)
+}
+
+func TestPinnedSessionAfterClose(t *testing.T) {
+	ctx := newTestContext(&Config{}, 2)
+	defer ctx.pool.Close()
+	key := &pkcs11PrivateKey{pkcs11Object: pkcs11Object{handle: 1, context: ctx}}
+
+	err := key.WithSession(func() error {
+		ctx.closed.Set(true)
+		defer ctx.closed.Set(false)
+		return key.withSessionContext(context.Background(), func(session *pkcs11Session) error {
+			t.Error("operation ran on a closed Context")
+			return nil
+		})
+	})
+	assert.Equal(t, errClosed, err)
+}
+
+func TestInvalidKeyHandleRefreshed(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		signer, err := ctx.GenerateECDSAKeyPairWithLabel(randomBytes(), randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = signer.Delete() }()
+
+		key := signer.(*pkcs11PrivateKeyECDSA)
+		handle, pubHandle := key.handle, key.pubKeyHandle
+
+		// Simulate the handles changing, as they may when the library is reinitialized.
+		key.handle, key.pubKeyHandle = handle+1000, pubHandle+1000
+
+		digest := sha256.Sum256([]byte("refresh"))
+		_, err = signer.Sign(nil, digest[:], crypto.SHA256)
+		require.NoError(t, err)
+		assert.Equal(t, handle, key.handle)
+		assert.Equal(t, pubHandle, key.pubKeyHandle)
+	})
+}
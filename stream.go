@@ -0,0 +1,194 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"errors"
+	"io"
+)
+
+// streamChunkSize is the amount of ciphertext a decrypting reader reads from its source at a time.
+const streamChunkSize = 4096
+
+// errStreamClosed is returned by the Write and Read methods of a closed stream.
+var errStreamClosed = errors.New("stream is closed")
+
+// NewEncryptWriter returns a writer that encrypts data written to it in cipher block chaining mode with PKCS#7
+// padding, using the given key, and writes the ciphertext to w. The length of iv must be the same as the key's block
+// size.
+//
+// Data is passed to the token in whole blocks. Close must be called to encrypt the final block, including the
+// padding, and to release the session used by the writer; it does not close w.
+func (key *SecretKey) NewEncryptWriter(w io.Writer, iv []byte) (io.WriteCloser, error) {
+	pbmc, err := key.newPaddedBlockModeCloser(modeEncrypt, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, pbmc: pbmc, blockSize: key.Cipher.BlockSize}, nil
+}
+
+// encryptWriter is the io.WriteCloser returned by NewEncryptWriter.
+type encryptWriter struct {
+	w         io.Writer
+	pbmc      *paddedBlockModeCloser
+	blockSize int
+
+	// buf holds plaintext that does not yet fill a block.
+	buf []byte
+
+	// err is the first error encountered, which is returned by all later calls.
+	err error
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	if ew.err != nil {
+		return 0, ew.err
+	}
+
+	ew.buf = append(ew.buf, p...)
+	whole := len(ew.buf) - len(ew.buf)%ew.blockSize
+	if whole == 0 {
+		return len(p), nil
+	}
+
+	result, err := ew.pbmc.Update(ew.buf[:whole])
+	if err == nil {
+		_, err = ew.w.Write(result)
+	}
+	if err != nil {
+		ew.fail(err)
+		return 0, err
+	}
+	ew.buf = append(ew.buf[:0], ew.buf[whole:]...)
+	return len(p), nil
+}
+
+// Close encrypts any buffered data, adds the padding and writes the remaining ciphertext.
+func (ew *encryptWriter) Close() error {
+	if ew.err != nil {
+		if ew.err == errStreamClosed {
+			return nil
+		}
+		return ew.err
+	}
+
+	var result []byte
+	var err error
+	if len(ew.buf) > 0 {
+		result, err = ew.pbmc.Update(ew.buf)
+	}
+	if err == nil {
+		var final []byte
+		final, err = ew.pbmc.Final()
+		result = append(result, final...)
+	}
+	if err == nil {
+		_, err = ew.w.Write(result)
+	}
+	if err != nil {
+		ew.fail(err)
+		return err
+	}
+
+	ew.err = errStreamClosed
+	return nil
+}
+
+// fail records err and abandons the token operation.
+func (ew *encryptWriter) fail(err error) {
+	ew.err = err
+	ew.pbmc.Close()
+}
+
+// NewDecryptReader returns a reader that decrypts the ciphertext read from r, which must have been encrypted in
+// cipher block chaining mode with PKCS#7 padding, for example by NewEncryptWriter. The length of iv must be the same
+// as the key's block size and must match the iv used to encrypt the data.
+//
+// The padding is removed when r reaches the end of the ciphertext. If it is malformed, Read returns an error instead
+// of io.EOF. Close must be called to release the session used by the reader if it is not read to the end; it does
+// not close r.
+func (key *SecretKey) NewDecryptReader(r io.Reader, iv []byte) (io.ReadCloser, error) {
+	pbmc, err := key.newPaddedBlockModeCloser(modeDecrypt, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, pbmc: pbmc, chunk: make([]byte, streamChunkSize)}, nil
+}
+
+// decryptReader is the io.ReadCloser returned by NewDecryptReader.
+type decryptReader struct {
+	r    io.Reader
+	pbmc *paddedBlockModeCloser
+
+	// chunk is the buffer into which ciphertext is read.
+	chunk []byte
+
+	// plaintext holds decrypted data not yet returned by Read.
+	plaintext []byte
+
+	// err is returned by Read once plaintext is empty. It is io.EOF after the operation completes successfully.
+	err error
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.plaintext) == 0 && dr.err == nil {
+		n, err := dr.r.Read(dr.chunk)
+		if n > 0 {
+			result, updateErr := dr.pbmc.Update(dr.chunk[:n])
+			if updateErr != nil {
+				dr.err = updateErr
+				break
+			}
+			dr.plaintext = append(dr.plaintext, result...)
+		}
+
+		switch {
+		case err == io.EOF:
+			final, finalErr := dr.pbmc.Final()
+			dr.plaintext = append(dr.plaintext, final...)
+			dr.err = finalErr
+			if finalErr == nil {
+				dr.err = io.EOF
+			}
+		case err != nil:
+			dr.pbmc.Close()
+			dr.err = err
+		}
+	}
+
+	if len(dr.plaintext) > 0 {
+		n := copy(p, dr.plaintext)
+		dr.plaintext = dr.plaintext[n:]
+		return n, nil
+	}
+	return 0, dr.err
+}
+
+// Close releases the session used by the reader, abandoning the decryption if it has not completed.
+func (dr *decryptReader) Close() error {
+	dr.pbmc.Close()
+	dr.plaintext = nil
+	if dr.err == nil || dr.err == io.EOF {
+		dr.err = errStreamClosed
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptWriterDecryptReader(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_AES_CBC_PAD)
+
+		key, err := ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		iv := make([]byte, 16)
+		for _, length := range []int{0, 1, 15, 16, 17, 100, 3 * streamChunkSize} {
+			plaintext := make([]byte, length)
+			for i := range plaintext {
+				plaintext[i] = byte(i)
+			}
+
+			// Write in pieces that do not line up with blocks
+			var ciphertext bytes.Buffer
+			w, err := key.NewEncryptWriter(&ciphertext, iv)
+			require.NoError(t, err)
+			for remaining := plaintext; len(remaining) > 0; {
+				n := 7
+				if n > len(remaining) {
+					n = len(remaining)
+				}
+				written, err := w.Write(remaining[:n])
+				require.NoError(t, err)
+				require.Equal(t, n, written)
+				remaining = remaining[n:]
+			}
+			require.NoError(t, w.Close())
+			require.Len(t, ciphertext.Bytes(), (length/16+1)*16, "length %d", length)
+
+			// The stream must match a one-shot encryption
+			encrypter, err := key.NewCBCPadEncrypterCloser(iv)
+			require.NoError(t, err)
+			expected, err := encrypter.Update(plaintext)
+			require.NoError(t, err)
+			final, err := encrypter.Final()
+			require.NoError(t, err)
+			require.Equal(t, append(expected, final...), ciphertext.Bytes(), "length %d", length)
+
+			r, err := key.NewDecryptReader(iotest.HalfReader(bytes.NewReader(ciphertext.Bytes())), iv)
+			require.NoError(t, err)
+			decrypted, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			require.Equal(t, plaintext, append([]byte{}, decrypted...), "length %d", length)
+		}
+
+		// Writing after Close fails, and closing twice is harmless
+		w, err := key.NewEncryptWriter(ioutil.Discard, iv)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		_, err = w.Write([]byte("data"))
+		require.Error(t, err)
+		require.NoError(t, w.Close())
+
+		// A block ending in a zero byte is not validly padded
+		blockMode, err := key.NewCBCEncrypterCloser(iv)
+		require.NoError(t, err)
+		ciphertext := make([]byte, 16)
+		blockMode.CryptBlocks(ciphertext, make([]byte, 16))
+		blockMode.Close()
+
+		r, err := key.NewDecryptReader(bytes.NewReader(ciphertext), iv)
+		require.NoError(t, err)
+		_, err = ioutil.ReadAll(r)
+		require.Equal(t, errMalformedPadding, err)
+		require.NoError(t, r.Close())
+
+		// Closing a reader before the end abandons the decryption
+		r, err = key.NewDecryptReader(bytes.NewReader(ciphertext), iv)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		_, err = r.Read(make([]byte, 16))
+		require.Error(t, err)
+	})
+}
@@ -23,6 +23,7 @@ package crypto11
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/miekg/pkcs11"
 )
@@ -252,8 +253,34 @@ type SecretKey struct {
 	Cipher *SymmetricCipher
 }
 
+// KeyType returns the CKA_KEY_TYPE of the key, e.g. CKK_AES.
+func (key *SecretKey) KeyType() (uint, error) {
+	attribute, err := key.Attribute(pkcs11.CKA_KEY_TYPE)
+	if err != nil {
+		return 0, err
+	}
+	return bytesToUlong(attribute.Value), nil
+}
+
+// KeyLength returns the length of the key in bits, from its CKA_VALUE_LEN. Triple-DES keys, which have no
+// CKA_VALUE_LEN, are reported as 192 bits.
+func (key *SecretKey) KeyLength() (int, error) {
+	if isDES3(key.Cipher) {
+		return 192, nil
+	}
+
+	attribute, err := key.Attribute(pkcs11.CKA_VALUE_LEN)
+	if err != nil {
+		return 0, err
+	}
+	return int(bytesToUlong(attribute.Value)) * 8, nil
+}
+
 // GenerateSecretKey creates an secret key of given length and type. The id parameter is used to
 // set CKA_ID and must be non-nil.
+//
+// The length is given in bits and sets CKA_VALUE_LEN, except for triple-DES keys, which have a fixed length. AES keys
+// must be 128, 192 or 256 bits, and the length must also be one the token reports for CKM_AES_KEY_GEN.
 func (c *Context) GenerateSecretKey(id []byte, bits int, cipher *SymmetricCipher) (*SecretKey, error) {
 	if c.closed.Get() {
 		return nil, errClosed
@@ -281,6 +308,77 @@ func (c *Context) GenerateSecretKeyWithLabel(id, label []byte, bits int, cipher
 
 }
 
+// GenerateSecretKeyWithRandomID creates a secret key of given length and type, like GenerateSecretKey, with a random
+// CKA_ID that no other object on the token has. The CKA_ID is returned along with the key. If label is non-nil, it is
+// used to set CKA_LABEL.
+func (c *Context) GenerateSecretKeyWithRandomID(label []byte, bits int, cipher *SymmetricCipher) (*SecretKey, []byte,
+	error) {
+
+	if c.closed.Get() {
+		return nil, nil, errClosed
+	}
+
+	var k *SecretKey
+	id, err := c.withRandomID(func(id []byte) (err error) {
+		k, err = c.GenerateSecretKeyWithOptions(id, label, bits, cipher, KeyGenOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, id, nil
+}
+
+// GenerateSecretKeyWithOptions creates a secret key of given length and type, protected as selected in opts. The id
+// parameter is used to set CKA_ID and must be non-nil. If label is non-nil, it is used to set CKA_LABEL.
+func (c *Context) GenerateSecretKeyWithOptions(id, label []byte, bits int, cipher *SymmetricCipher,
+	opts KeyGenOptions) (*SecretKey, error) {
+
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if err := opts.validateNonRSA(); err != nil {
+		return nil, err
+	}
+
+	template, err := newKeyAttributeSet(id, label)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.apply(template)
+
+	return c.GenerateSecretKeyWithAttributes(template, bits, cipher)
+}
+
+// GenerateSecretKeyWithUsage creates a secret key of given length and type, permitting only the operations selected
+// in usage. The id parameter is used to set CKA_ID and must be non-nil. If label is non-nil, it is used to set
+// CKA_LABEL.
+//
+// For example, an AES key used both to wrap keys and as the base key of a derivation can be created with
+// SecretKeyWrap|SecretKeyUnwrap|SecretKeyDerive.
+func (c *Context) GenerateSecretKeyWithUsage(id, label []byte, bits int, cipher *SymmetricCipher,
+	usage SecretKeyUsage) (*SecretKey, error) {
+
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if err := usage.validate(); err != nil {
+		return nil, err
+	}
+
+	template, err := newKeyAttributeSet(id, label)
+	if err != nil {
+		return nil, err
+	}
+
+	usage.apply(template)
+
+	return c.GenerateSecretKeyWithAttributes(template, bits, cipher)
+}
+
 // GenerateSecretKeyWithAttributes creates an secret key of given length and type. After this function returns, template
 // will contain the attributes applied to the key. If required attributes are missing, they will be set to a default
 // value.
@@ -289,7 +387,11 @@ func (c *Context) GenerateSecretKeyWithAttributes(template AttributeSet, bits in
 		return nil, errClosed
 	}
 
-	err = c.withSession(func(session *pkcs11Session) error {
+	if err = checkSecretKeyLength(cipher, bits); err != nil {
+		return nil, err
+	}
+
+	err = c.withObjectSession(template, func(session *pkcs11Session) error {
 
 		// CKK_*_HMAC exists but there is no specific corresponding CKM_*_KEY_GEN
 		// mechanism. Therefore we attempt both CKM_GENERIC_SECRET_KEY_GEN and
@@ -305,10 +407,21 @@ func (c *Context) GenerateSecretKeyWithAttributes(template AttributeSet, bits in
 			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
 			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
 		})
-		if bits > 0 {
+		// Triple-DES keys have a fixed length, so CKA_VALUE_LEN must not be given
+		if bits > 0 && !isDES3(cipher) {
 			_ = template.Set(pkcs11.CKA_VALUE_LEN, bits/8) // safe for an int
 		}
 
+		if isAES(cipher) {
+			info, err := session.ctx.GetMechanismInfo(c.SlotID(),
+				[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_GEN, nil)})
+			if err == nil {
+				if err = checkAESKeyLength(info, bits); err != nil {
+					return err
+				}
+			}
+		}
+
 		for n, genMech := range cipher.GenParams {
 
 			_ = template.Set(CkaKeyType, genMech.KeyType)
@@ -341,6 +454,11 @@ func (c *Context) GenerateSecretKeyWithAttributes(template AttributeSet, bits in
 			if n == len(cipher.GenParams)-1 {
 				// If we have tried all available gen params, we should return a sensible error. So we skip the
 				// retry logic below and return directly.
+				if isAES(cipher) && (isPKCS11Error(err, pkcs11.CKR_KEY_SIZE_RANGE) ||
+					isPKCS11Error(err, pkcs11.CKR_TEMPLATE_INCONSISTENT) ||
+					isPKCS11Error(err, pkcs11.CKR_ATTRIBUTE_VALUE_INVALID)) {
+					return fmt.Errorf("token rejected an AES key length of %d bits for CKM_AES_KEY_GEN: %w", bits, err)
+				}
 				return err
 			}
 
@@ -360,7 +478,229 @@ func (c *Context) GenerateSecretKeyWithAttributes(template AttributeSet, bits in
 	return
 }
 
+// GenerateGenericSecretKey creates a CKK_GENERIC_SECRET key of length bytes, using CKM_GENERIC_SECRET_KEY_GEN.
+// Unlike GenerateSecretKey, length need not be a standard cipher size, which suits secrets used as KDF input.
+// The id parameter is used to set CKA_ID and must be non-nil. The length is checked against the key sizes the token
+// reports for CKM_GENERIC_SECRET_KEY_GEN.
+func (c *Context) GenerateGenericSecretKey(id []byte, length int) (*SecretKey, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if length <= 0 {
+		return nil, errors.New("length must be positive")
+	}
+
+	template, err := NewAttributeSetWithID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.withSession(func(session *pkcs11Session) error {
+		return c.checkKeySize(session, pkcs11.CKM_GENERIC_SECRET_KEY_GEN, "generic secret", length*8)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// CKA_VALUE_LEN is a CK_ULONG, so make sure it is encoded as one.
+	_ = template.Set(CkaValueLen, uint(length))
+
+	return c.GenerateSecretKeyWithAttributes(template, 0, CipherGeneric)
+}
+
+// ImportWrappedSecretKey imports a secret key that has been wrapped under the public half of unwrappingKey, typically
+// using CKM_RSA_PKCS_OAEP or CKM_RSA_AES_KEY_WRAP. The key is unwrapped inside the token, so the plaintext key is never
+// exposed. The private half of unwrappingKey must permit unwrapping (CKA_UNWRAP).
+//
+// The template must specify CKA_KEY_TYPE and should identify the key with CKA_ID and/or CKA_LABEL. If other required
+// attributes are missing, they will be set to a default value. After this function returns, template will contain the
+// attributes applied to the key.
+func (c *Context) ImportWrappedSecretKey(unwrappingKey Signer, mech *pkcs11.Mechanism, wrapped []byte,
+	template AttributeSet) (*SecretKey, error) {
+
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	unwrapper, ok := unwrappingKey.(keyPair)
+	if !ok {
+		return nil, errors.New("unwrapping key is not a PKCS#11 key pair")
+	}
+
+	return c.unwrapSecretKey(unwrapper.privateKey().handle, mech, wrapped, template)
+}
+
+// unwrapSecretKey unwraps a secret key using the unwrapping key with the given handle. The template must specify
+// CKA_KEY_TYPE; other missing attributes are set to default values.
+func (c *Context) unwrapSecretKey(unwrappingKey pkcs11.ObjectHandle, mech *pkcs11.Mechanism, wrapped []byte,
+	template AttributeSet) (*SecretKey, error) {
+
+	if mech == nil {
+		return nil, errors.New("mechanism must be specified")
+	}
+
+	keyTypeAttribute, ok := template[CkaKeyType]
+	if !ok {
+		return nil, errors.New("template must specify CKA_KEY_TYPE")
+	}
+	cipher, ok := Ciphers[int(bytesToUlong(keyTypeAttribute.Value))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %X", bytesToUlong(keyTypeAttribute.Value))
+	}
+
+	template.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, cipher.MAC),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, cipher.MAC),
+		pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, cipher.Encrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, cipher.Encrypt),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	})
+
+	var k *SecretKey
+	err := c.withRWSession(func(session *pkcs11Session) error {
+		if err := checkKeyPermits(session, unwrappingKey, pkcs11.CKA_UNWRAP,
+			"unwrapping key does not permit unwrapping (CKA_UNWRAP is false)"); err != nil {
+			return err
+		}
+
+		handle, err := session.ctx.UnwrapKey(session.handle, []*pkcs11.Mechanism{mech}, unwrappingKey, wrapped,
+			template.ToSlice())
+		if err != nil {
+			return newOperationError(session, unwrappingKey, "unwrap", mech.Mechanism, err)
+		}
+
+		k = &SecretKey{pkcs11Object{handle, c}, cipher}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// isDES3 returns true if cipher generates triple-DES keys.
+func isDES3(cipher *SymmetricCipher) bool {
+	for _, p := range cipher.GenParams {
+		if p.KeyType == pkcs11.CKK_DES3 {
+			return true
+		}
+	}
+	return false
+}
+
+// isAES returns true if cipher generates AES keys.
+func isAES(cipher *SymmetricCipher) bool {
+	for _, p := range cipher.GenParams {
+		if p.KeyType == pkcs11.CKK_AES {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecretKeyLength checks that bits is a valid key length for cipher. Zero selects the default length, except for
+// AES, which has none.
+func checkSecretKeyLength(cipher *SymmetricCipher, bits int) error {
+	if isDES3(cipher) && bits != 0 && bits != 168 && bits != 192 {
+		return fmt.Errorf("triple-DES keys must be 168 bits, or 192 bits including parity, not %d", bits)
+	}
+	if isAES(cipher) && bits != 128 && bits != 192 && bits != 256 {
+		return fmt.Errorf("AES keys must be 128, 192 or 256 bits, not %d", bits)
+	}
+	return nil
+}
+
+// checkAESKeyLength checks that bits lies within the key sizes reported for CKM_AES_KEY_GEN. PKCS#11 gives these in
+// bytes, but some tokens report bits, which is assumed if the maximum is larger than any AES key in bytes. A maximum of
+// zero is treated as unbounded.
+func checkAESKeyLength(info pkcs11.MechanismInfo, bits int) error {
+	size, unit := uint(bits)/8, "bytes"
+	if info.MaxKeySize > 32 {
+		size, unit = uint(bits), "bits"
+	}
+	if size < info.MinKeySize || (info.MaxKeySize != 0 && size > info.MaxKeySize) {
+		return fmt.Errorf("AES key length of %d bits is not supported by the token, which allows %d-%d %s for "+
+			"CKM_AES_KEY_GEN", bits, info.MinKeySize, info.MaxKeySize, unit)
+	}
+	return nil
+}
+
 // Delete deletes the secret key from the token.
 func (key *SecretKey) Delete() error {
 	return key.pkcs11Object.Delete()
 }
+
+// kcvLength is the length of a key check value computed by CheckValue.
+const kcvLength = 3
+
+// CheckValue returns the key check value (KCV) of the key, allowing it to be compared against an expected KCV without
+// exposing the key. The token's CKA_CHECK_VALUE is used if present. Otherwise, if the key permits encryption, the
+// standard KCV is computed: the first three bytes of a block of zeros encrypted in ECB mode.
+func (key *SecretKey) CheckValue() (kcv []byte, err error) {
+	err = key.context.withSession(func(session *pkcs11Session) error {
+		attributes, err := session.ctx.GetAttributeValue(session.handle, key.handle,
+			[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CHECK_VALUE, nil)})
+		if err == nil && len(attributes[0].Value) > 0 {
+			kcv = attributes[0].Value
+			return nil
+		}
+		if e, ok := err.(pkcs11.Error); err != nil && (!ok || e != pkcs11.CKR_ATTRIBUTE_TYPE_INVALID) {
+			return err
+		}
+
+		if !key.Cipher.Encrypt || key.Cipher.ECBMech == 0 {
+			return errors.New("token does not provide CKA_CHECK_VALUE and key cannot be used to compute it")
+		}
+
+		mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(key.Cipher.ECBMech, nil)}
+		if err = session.ctx.EncryptInit(session.handle, mech, key.handle); err != nil {
+			return err
+		}
+		result, err := session.ctx.Encrypt(session.handle, make([]byte, key.Cipher.BlockSize))
+		if err != nil {
+			return err
+		}
+		if len(result) < kcvLength {
+			return fmt.Errorf("C_Encrypt: unexpectedly returned %v bytes", len(result))
+		}
+		kcv = result[:kcvLength]
+		return nil
+	})
+	return
+}
+
+// deriveSecretKey derives a CKK_GENERIC_SECRET key of length bytes from the base key using mech. The new key permits
+// signing (for HMAC) and further derivation. It is a session object, so it is destroyed when the Context is closed.
+func (c *Context) deriveSecretKey(base pkcs11.ObjectHandle, mech *pkcs11.Mechanism, length int) (*SecretKey, error) {
+	template := NewAttributeSet()
+	template.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_GENERIC_SECRET),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, length),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_DERIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	})
+
+	var k *SecretKey
+	err := c.withObjectSession(template, func(session *pkcs11Session) error {
+		handle, err := session.ctx.DeriveKey(session.handle, []*pkcs11.Mechanism{mech}, base, template.ToSlice())
+		if err != nil {
+			return newOperationError(session, base, "derive", mech.Mechanism, err)
+		}
+
+		k = &SecretKey{pkcs11Object{handle, c}, CipherGeneric}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
@@ -23,6 +23,7 @@ package crypto11
 
 import (
 	"bytes"
+	"crypto/aes"
 	"crypto/cipher"
 	"runtime"
 	"testing"
@@ -339,3 +340,239 @@ func TestSymmetricRequiredArgs(t *testing.T) {
 }
 
 // TODO BenchmarkGCM along the same lines as above
+
+func TestGenericSecretKeyLengths(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		for _, length := range []int{7, 20, 65} {
+			key, err := ctx.GenerateGenericSecretKey(randomBytes(), length)
+			require.NoError(t, err)
+			defer func(k *SecretKey) { _ = k.Delete() }(key)
+
+			attr, err := ctx.GetAttribute(key, CkaValueLen)
+			require.NoError(t, err)
+			require.Equal(t, uint(length), bytesToUlong(attr.Value))
+		}
+
+		_, err := ctx.GenerateGenericSecretKey(randomBytes(), 0)
+		require.Error(t, err)
+	})
+}
+
+func TestGenericSecretKeySizeError(t *testing.T) {
+	check := func(info pkcs11.MechanismInfo, length int) error {
+		return keySizeError(info, pkcs11.CKM_GENERIC_SECRET_KEY_GEN, "generic secret", length*8)
+	}
+
+	info := pkcs11.MechanismInfo{MinKeySize: 8, MaxKeySize: 512}
+	require.NoError(t, check(info, 1))
+	require.NoError(t, check(info, 64))
+	require.Error(t, check(info, 65))
+
+	info = pkcs11.MechanismInfo{MinKeySize: 80}
+	require.Error(t, check(info, 9))
+	require.NoError(t, check(info, 4096))
+}
+
+func TestSecretKeyCheckValue(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		require.NoError(t, template.Set(CkaExtractable, true))
+		require.NoError(t, template.Set(CkaSensitive, false))
+
+		key, err := ctx.GenerateSecretKeyWithAttributes(template, 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		kcv, err := key.CheckValue()
+		require.NoError(t, err)
+
+		value, err := ctx.GetAttribute(key, CkaValue)
+		require.NoError(t, err)
+
+		block, err := aes.NewCipher(value.Value)
+		require.NoError(t, err)
+		expected := make([]byte, aes.BlockSize)
+		block.Encrypt(expected, make([]byte, aes.BlockSize))
+
+		require.Equal(t, expected[:kcvLength], kcv)
+	})
+}
+
+func TestGCMMatchesSoftware(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		require.NoError(t, template.Set(CkaExtractable, true))
+		require.NoError(t, template.Set(CkaSensitive, false))
+
+		key, err := ctx.GenerateSecretKeyWithAttributes(template, 256, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_AES_GCM)
+		require.True(t, key.GCMNonceHonored())
+
+		value, err := ctx.GetAttribute(key, CkaValue)
+		require.NoError(t, err)
+		block, err := aes.NewCipher(value.Value)
+		require.NoError(t, err)
+		software, err := cipher.NewGCMWithNonceSize(block, ctx.cfg.GCMIVLength)
+		require.NoError(t, err)
+
+		aead, err := key.NewGCM()
+		require.NoError(t, err)
+		require.Equal(t, software.NonceSize(), aead.NonceSize())
+		require.Equal(t, software.Overhead(), aead.Overhead())
+
+		nonce := randomBytes()[:aead.NonceSize()]
+		plaintext := []byte("some plaintext to protect")
+		additionalData := []byte("some additional data")
+
+		ciphertext := aead.Seal(nil, nonce, plaintext, additionalData)
+		require.Equal(t, software.Seal(nil, nonce, plaintext, additionalData), ciphertext)
+
+		decrypted, err := aead.Open(nil, nonce, ciphertext, additionalData)
+		require.NoError(t, err)
+		require.Equal(t, plaintext, decrypted)
+
+		_, err = aead.Open(nil, nonce, ciphertext, []byte("wrong additional data"))
+		require.Error(t, err)
+	})
+}
+
+func TestDES3RoundTrip(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_DES3_KEY_GEN)
+
+		id := randomBytes()
+		key, err := ctx.GenerateSecretKey(id, 192, CipherDES3)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		found, err := ctx.FindKey(id, nil)
+		require.NoError(t, err)
+		require.Equal(t, 8, found.BlockSize())
+
+		iv := randomBytes()[:found.BlockSize()]
+		plaintext := randomBytes()
+
+		encrypter, err := found.NewCBCEncrypterCloser(iv)
+		require.NoError(t, err)
+		ciphertext := make([]byte, len(plaintext))
+		encrypter.CryptBlocks(ciphertext, plaintext)
+		encrypter.Close()
+		require.NotEqual(t, plaintext, ciphertext)
+
+		decrypter, err := key.NewCBCDecrypterCloser(iv)
+		require.NoError(t, err)
+		decrypted := make([]byte, len(ciphertext))
+		decrypter.CryptBlocks(decrypted, ciphertext)
+		decrypter.Close()
+		require.Equal(t, plaintext, decrypted)
+	})
+}
+
+func TestCheckSecretKeyLength(t *testing.T) {
+	require.NoError(t, checkSecretKeyLength(CipherDES3, 0))
+	require.NoError(t, checkSecretKeyLength(CipherDES3, 168))
+	require.NoError(t, checkSecretKeyLength(CipherDES3, 192))
+	require.Error(t, checkSecretKeyLength(CipherDES3, 128))
+	require.Error(t, checkSecretKeyLength(CipherDES3, 256))
+	require.NoError(t, checkSecretKeyLength(CipherAES, 256))
+	require.NoError(t, checkSecretKeyLength(CipherAES, 192))
+	require.Error(t, checkSecretKeyLength(CipherAES, 0))
+	require.Error(t, checkSecretKeyLength(CipherAES, 512))
+}
+
+func TestCheckAESKeyLength(t *testing.T) {
+	bytes := pkcs11.MechanismInfo{MinKeySize: 16, MaxKeySize: 32}
+	require.NoError(t, checkAESKeyLength(bytes, 128))
+	require.NoError(t, checkAESKeyLength(bytes, 256))
+
+	bytes.MinKeySize = 24
+	err := checkAESKeyLength(bytes, 128)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "24-32 bytes")
+
+	bits := pkcs11.MechanismInfo{MinKeySize: 128, MaxKeySize: 256}
+	require.NoError(t, checkAESKeyLength(bits, 192))
+}
+
+func TestGenerateAESKeySizes(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		for _, bits := range []int{128, 192, 256} {
+			key, err := ctx.GenerateSecretKeyWithLabel(randomBytes(), randomBytes(), bits, CipherAES)
+			if err != nil && bits == 192 {
+				// Some tokens do not support 192-bit keys, but must say so clearly.
+				require.Contains(t, err.Error(), "CKM_AES_KEY_GEN")
+				continue
+			}
+			require.NoError(t, err, "%d bits", bits)
+			defer func(k *SecretKey) { _ = k.Delete() }(key)
+
+			length, err := ctx.GetAttribute(key, CkaValueLen)
+			require.NoError(t, err)
+			require.Equal(t, uint(bits/8), bytesToUlong(length.Value))
+		}
+
+		_, err := ctx.GenerateSecretKey(randomBytes(), 64, CipherAES)
+		require.Error(t, err)
+	})
+}
+
+func TestCBCPadRoundTrip(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		skipIfMechUnsupported(t, ctx, pkcs11.CKM_AES_CBC_PAD)
+
+		key, err := ctx.GenerateSecretKey(randomBytes(), 128, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		iv := make([]byte, 16)
+		for _, length := range []int{0, 1, 15, 16, 17, 100} {
+			plaintext := make([]byte, length)
+			for i := range plaintext {
+				plaintext[i] = byte(i)
+			}
+
+			encrypter, err := key.NewCBCPadEncrypterCloser(iv)
+			require.NoError(t, err)
+			ciphertext, err := encrypter.Update(plaintext[:length/2])
+			require.NoError(t, err)
+			more, err := encrypter.Update(plaintext[length/2:])
+			require.NoError(t, err)
+			ciphertext = append(ciphertext, more...)
+			more, err = encrypter.Final()
+			require.NoError(t, err)
+			ciphertext = append(ciphertext, more...)
+			require.Len(t, ciphertext, (length/16+1)*16, "length %d", length)
+
+			decrypter, err := key.NewCBCPadDecrypterCloser(iv)
+			require.NoError(t, err)
+			decrypted, err := decrypter.Update(ciphertext)
+			require.NoError(t, err)
+			more, err = decrypter.Final()
+			require.NoError(t, err)
+			decrypted = append(decrypted, more...)
+			require.Equal(t, plaintext, append([]byte{}, decrypted...), "length %d", length)
+		}
+
+		// A block ending in a zero byte is not validly padded
+		blockMode, err := key.NewCBCEncrypterCloser(iv)
+		require.NoError(t, err)
+		ciphertext := make([]byte, 16)
+		blockMode.CryptBlocks(ciphertext, make([]byte, 16))
+		blockMode.Close()
+
+		decrypter, err := key.NewCBCPadDecrypterCloser(iv)
+		require.NoError(t, err)
+		_, err = decrypter.Update(ciphertext)
+		require.NoError(t, err)
+		_, err = decrypter.Final()
+		require.Error(t, err)
+
+		// Close after Final is harmless
+		decrypter.Close()
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// Verify verifies sig, a signature over data, using the public half of key and the mechanism mech. The verification
+// is performed by the token, using C_VerifyInit and C_Verify, rather than in Go. The signature and data are in the
+// form the mechanism expects: for instance, CKM_ECDSA and CKM_DSA take the digest as data and a raw signature (r
+// followed by s, each padded to the size of the group order) rather than the DER encoding returned by Sign.
+//
+// Verify returns nil if the signature is valid. If the token finds it invalid (CKR_SIGNATURE_INVALID or
+// CKR_SIGNATURE_LEN_RANGE), the error returned satisfies errors.Is(err, ErrSignatureInvalid). The key must have a
+// public key object on the token, which is not the case for a key pair whose public key came from a certificate.
+func (c *Context) Verify(key Signer, mech *pkcs11.Mechanism, data, sig []byte) error {
+	if c.closed.Get() {
+		return errClosed
+	}
+
+	pair, ok := key.(keyPair)
+	if !ok {
+		return errors.Errorf("unsupported key type %T", key)
+	}
+	pubHandle := pair.privateKey().PublicHandle()
+	if pubHandle == 0 {
+		return errors.New("key has no public key object on the token")
+	}
+
+	return c.withSession(func(session *pkcs11Session) error {
+		if err := session.ctx.VerifyInit(session.handle, []*pkcs11.Mechanism{mech}, pubHandle); err != nil {
+			return newOperationError(session, pubHandle, "verify", mech.Mechanism, err)
+		}
+		if err := session.ctx.Verify(session.handle, data, sig); err != nil {
+			return newOperationError(session, pubHandle, "verify", mech.Mechanism, err)
+		}
+		c.traceMechanism("verify", mech.Mechanism)
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+)
+
+// WrapKey exports target, encrypted under wrappingKey using C_WrapKey, for backup or transport. The target is either
+// a *SecretKey or a key pair returned by this package, in which case its private key is wrapped. The wrapping key is
+// either a *SecretKey, e.g. with CKM_AES_KEY_WRAP_PAD, or a key pair, whose public key object is used, e.g. with
+// CKM_RSA_PKCS_OAEP. The wrapping key must permit wrapping (CKA_WRAP) and the target must be extractable
+// (CKA_EXTRACTABLE).
+func (c *Context) WrapKey(wrappingKey interface{}, target interface{}, mech *pkcs11.Mechanism) ([]byte, error) {
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	if mech == nil {
+		return nil, errors.New("mechanism must be specified")
+	}
+
+	wrappingHandle, err := wrapKeyHandle(wrappingKey, true)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid wrapping key")
+	}
+	targetHandle, err := wrapKeyHandle(target, false)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid target")
+	}
+
+	var wrapped []byte
+	err = c.withSession(func(session *pkcs11Session) (err error) {
+		if err = checkKeyPermits(session, wrappingHandle, pkcs11.CKA_WRAP,
+			"wrapping key does not permit wrapping (CKA_WRAP is false)"); err != nil {
+			return err
+		}
+
+		wrapped, err = session.ctx.WrapKey(session.handle, []*pkcs11.Mechanism{mech}, wrappingHandle, targetHandle)
+		if err != nil {
+			return newOperationError(session, wrappingHandle, "wrap", mech.Mechanism, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// UnwrapKey imports a secret key that was encrypted under wrappingKey, using C_UnwrapKey. The key is decrypted inside
+// the token. The wrapping key is either a *SecretKey, e.g. with CKM_AES_KEY_WRAP, or a key pair, whose private key is
+// used, e.g. with CKM_RSA_PKCS_OAEP. It must permit unwrapping (CKA_UNWRAP).
+//
+// The template must specify CKA_KEY_TYPE and should identify the key with CKA_ID and/or CKA_LABEL. If other
+// required attributes are missing, they will be set to a default value.
+func (c *Context) UnwrapKey(wrappingKey interface{}, wrapped []byte, template []*pkcs11.Attribute,
+	mech *pkcs11.Mechanism) (*SecretKey, error) {
+
+	if c.closed.Get() {
+		return nil, errClosed
+	}
+
+	handle, err := wrapKeyHandle(wrappingKey, false)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid wrapping key")
+	}
+
+	attributes := NewAttributeSet()
+	attributes.AddIfNotPresent(template)
+
+	return c.unwrapSecretKey(handle, mech, wrapped, attributes)
+}
+
+// wrapKeyHandle returns the handle of a key given to WrapKey or UnwrapKey: a *SecretKey, or a key pair returned by
+// this package, in which case the handle of the public key object is returned if public is true, and the handle of
+// the private key otherwise.
+func wrapKeyHandle(key interface{}, public bool) (pkcs11.ObjectHandle, error) {
+	switch k := key.(type) {
+	case nil:
+		return 0, errors.New("key must be specified")
+	case *SecretKey:
+		if k == nil {
+			return 0, errors.New("key must be specified")
+		}
+		return k.handle, nil
+	case keyPair:
+		priv := k.privateKey()
+		if !public {
+			defer priv.lockHandles()()
+			return priv.handle, nil
+		}
+		handle := priv.PublicHandle()
+		if handle == 0 {
+			return 0, errors.New("key pair has no public key object on the token")
+		}
+		return handle, nil
+	default:
+		return 0, errors.Errorf("unsupported key type %T", key)
+	}
+}
+
+// checkKeyPermits returns a descriptive error if the key does not have the boolean attribute, such as CKA_WRAP, that
+// permits the intended use. If the attribute cannot be read, the token is left to enforce it.
+func checkKeyPermits(session *pkcs11Session, key pkcs11.ObjectHandle, attribute uint, message string) error {
+	attributes, err := session.ctx.GetAttributeValue(session.handle, key,
+		[]*pkcs11.Attribute{pkcs11.NewAttribute(attribute, nil)})
+	if err != nil || len(attributes[0].Value) == 0 {
+		return nil
+	}
+
+	if attributes[0].Value[0] == 0 {
+		return errors.New(message)
+	}
+	return nil
+}
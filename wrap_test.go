@@ -0,0 +1,191 @@
+// Copyright 2026 Thales e-Security, Inc
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package crypto11
+
+import (
+	"crypto/aes"
+	"crypto/elliptic"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+	"github.com/stretchr/testify/require"
+)
+
+func generateWrappingKey(t *testing.T, ctx *Context, permitted bool) *SecretKey {
+	template, err := NewAttributeSetWithID(randomBytes())
+	require.NoError(t, err)
+	template.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_WRAP, permitted),
+		pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, permitted),
+	})
+
+	key, err := ctx.GenerateSecretKeyWithAttributes(template, 256, CipherAES)
+	require.NoError(t, err)
+	return key
+}
+
+func TestWrapKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		wrappingKey := generateWrappingKey(t, ctx, true)
+		defer func() { _ = wrappingKey.Delete() }()
+
+		public, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		private := public.Copy()
+		_ = private.Set(CkaExtractable, true)
+
+		key, err := ctx.GenerateRSAKeyPairWithAttributes(public, private, rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		wrapped, err := ctx.WrapKey(wrappingKey, key, pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP_PAD, nil))
+		require.NoError(t, err)
+		require.NotEmpty(t, wrapped)
+
+		_, err = ctx.WrapKey(wrappingKey, key, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestWrapAndUnwrapSecretKey(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		wrappingKey := generateWrappingKey(t, ctx, true)
+		defer func() { _ = wrappingKey.Delete() }()
+
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		_ = template.Set(CkaExtractable, true)
+
+		key, err := ctx.GenerateSecretKeyWithAttributes(template, 256, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		mech := pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP, nil)
+
+		var wrapped []byte
+		err = ctx.withSession(func(session *pkcs11Session) (err error) {
+			wrapped, err = session.ctx.WrapKey(session.handle, []*pkcs11.Mechanism{mech}, wrappingKey.handle,
+				key.handle)
+			return err
+		})
+		require.NoError(t, err)
+
+		unwrapped, err := ctx.UnwrapKey(wrappingKey, wrapped, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, randomBytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		}, mech)
+		require.NoError(t, err)
+		defer func() { _ = unwrapped.Delete() }()
+
+		// The unwrapped key must encrypt exactly as the original does
+		plaintext := randomBytes()
+		expected := make([]byte, aes.BlockSize)
+		key.Encrypt(expected, plaintext)
+
+		actual := make([]byte, aes.BlockSize)
+		unwrapped.Encrypt(actual, plaintext)
+		require.Equal(t, expected, actual)
+
+		_, err = ctx.UnwrapKey(wrappingKey, wrapped, nil, mech)
+		require.Error(t, err)
+	})
+}
+
+func TestWrapSecretKeyWithKeyPair(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		public, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		private := public.Copy()
+		_ = public.Set(CkaWrap, true)
+		_ = private.Set(CkaUnwrap, true)
+
+		pair, err := ctx.GenerateRSAKeyPairWithAttributes(public, private, rsaSize)
+		require.NoError(t, err)
+		defer func() { _ = pair.Delete() }()
+
+		template, err := NewAttributeSetWithID(randomBytes())
+		require.NoError(t, err)
+		_ = template.Set(CkaExtractable, true)
+
+		key, err := ctx.GenerateSecretKeyWithAttributes(template, 256, CipherAES)
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		mech := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP,
+			pkcs11.NewOAEPParams(pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1, pkcs11.CKZ_DATA_SPECIFIED, nil))
+		wrapped, err := ctx.WrapKey(pair, key, mech)
+		require.NoError(t, err)
+
+		unwrapped, err := ctx.UnwrapKey(pair, wrapped, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, randomBytes()),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		}, mech)
+		require.NoError(t, err)
+		defer func() { _ = unwrapped.Delete() }()
+
+		plaintext := randomBytes()
+		expected := make([]byte, aes.BlockSize)
+		key.Encrypt(expected, plaintext)
+
+		actual := make([]byte, aes.BlockSize)
+		unwrapped.Encrypt(actual, plaintext)
+		require.Equal(t, expected, actual)
+	})
+}
+
+func TestWrapKeyRequiresKeys(t *testing.T) {
+	ctx := &Context{}
+	mech := pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP, nil)
+
+	var nilKey *SecretKey
+	_, err := ctx.WrapKey(nil, &SecretKey{}, mech)
+	require.Error(t, err)
+	_, err = ctx.WrapKey(nilKey, &SecretKey{}, mech)
+	require.Error(t, err)
+	_, err = ctx.WrapKey(&SecretKey{}, nil, mech)
+	require.Error(t, err)
+	_, err = ctx.WrapKey(&SecretKey{}, "not a key", mech)
+	require.Error(t, err)
+	_, err = ctx.UnwrapKey(nil, randomBytes(), nil, mech)
+	require.Error(t, err)
+}
+
+func TestWrappingKeyNotPermitted(t *testing.T) {
+	withContext(t, func(ctx *Context) {
+		wrappingKey := generateWrappingKey(t, ctx, false)
+		defer func() { _ = wrappingKey.Delete() }()
+
+		key, err := ctx.GenerateECDSAKeyPair(randomBytes(), elliptic.P256())
+		require.NoError(t, err)
+		defer func() { _ = key.Delete() }()
+
+		_, err = ctx.WrapKey(wrappingKey, key, pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP_PAD, nil))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "CKA_WRAP")
+
+		_, err = ctx.UnwrapKey(wrappingKey, randomBytes(), []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		}, pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP, nil))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "CKA_UNWRAP")
+	})
+}